@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	// ActionRestore brings back an earlier version of an entity, or a deleted one
+	ActionRestore = "restore"
+)
+
+// Entities whose changes are recorded in the audit log
+const (
+	EntityEvent = "event"
+	EntityUser  = "user"
+)
+
+// Redacted stands in for the values of secret fields, such as passwords, which are recorded as changed
+var Redacted = json.RawMessage(`"[redacted]"`)
+
+// maxRequestIDLength bounds the length of request IDs supplied by clients
+const maxRequestIDLength = 128
+
+// requestIDPattern matches request IDs clients may supply, keeping them safe to log and echo back
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// Change is the JSON value of a field before and after a change. Before is null for a field of a
+// created entity, and After for a field of a deleted one.
+type Change struct {
+	Before json.RawMessage `json:"before"`
+	After  json.RawMessage `json:"after"`
+}
+
+// Changes maps the name of each changed field onto its change.
+type Changes map[string]Change
+
+// fields returns the top-level fields of the JSON encoding of an entity, which may be nil.
+func fields(entity interface{}) (map[string]json.RawMessage, error) {
+	values := make(map[string]json.RawMessage)
+	if entity == nil {
+		return values, nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(data, []byte("null")) {
+		return values, nil
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("audited entities must encode as JSON objects: %w", err)
+	}
+	return values, nil
+}
+
+// Diff compares the JSON encodings of an entity before and after a change, returning the fields whose
+// values differ, except the ignored ones. Before is nil for a create and after is nil for a delete, so
+// that every field is recorded.
+func Diff(before interface{}, after interface{}, ignore ...string) (Changes, error) {
+	old, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range ignore {
+		delete(old, field)
+		delete(updated, field)
+	}
+
+	changes := make(Changes)
+	for field, value := range old {
+		if !bytes.Equal(value, updated[field]) {
+			changes[field] = Change{Before: value, After: updated[field]}
+		}
+	}
+	for field, value := range updated {
+		if _, ok := old[field]; !ok {
+			changes[field] = Change{After: value}
+		}
+	}
+	return changes, nil
+}
+
+// NewRequestID returns a new random request ID.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether a request ID supplied by a client can be used: it must be at most 128
+// letters, digits, dots, underscores, colons and hyphens.
+func ValidRequestID(id string) bool {
+	return len(id) <= maxRequestIDLength && requestIDPattern.MatchString(id)
+}
@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type entity struct {
+	ID        uint
+	Title     string
+	Tags      []string
+	UpdatedAt string
+	Secret    string `json:"-"`
+}
+
+func TestDiffUpdate(t *testing.T) {
+	before := entity{ID: 1, Title: "On call", Tags: []string{"a"}, UpdatedAt: "monday", Secret: "x"}
+	after := entity{ID: 1, Title: "Holiday", Tags: []string{"a", "b"}, UpdatedAt: "tuesday", Secret: "y"}
+
+	changes, err := Diff(before, after, "UpdatedAt")
+	assert.NoError(t, err)
+	assert.Equal(t, Changes{
+		"Title": {Before: json.RawMessage(`"On call"`), After: json.RawMessage(`"Holiday"`)},
+		"Tags":  {Before: json.RawMessage(`["a"]`), After: json.RawMessage(`["a","b"]`)},
+	}, changes)
+
+	changes, err = Diff(before, before)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestDiffCreateAndDelete(t *testing.T) {
+	e := entity{ID: 2, Title: "On call"}
+
+	created, err := Diff(nil, e, "UpdatedAt")
+	assert.NoError(t, err)
+	assert.Len(t, created, 3)
+	assert.Nil(t, created["ID"].Before)
+	assert.JSONEq(t, `2`, string(created["ID"].After))
+
+	deleted, err := Diff(&e, nil)
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 4)
+	assert.JSONEq(t, `"On call"`, string(deleted["Title"].Before))
+	assert.Nil(t, deleted["Title"].After)
+
+	data, err := json.Marshal(deleted["Title"])
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"before":"On call","after":null}`, string(data))
+}
+
+func TestDiffRejectsNonObjects(t *testing.T) {
+	_, err := Diff("title", nil)
+	assert.Error(t, err)
+}
+
+func TestRequestIDs(t *testing.T) {
+	id := NewRequestID()
+	assert.Len(t, id, 32)
+	assert.True(t, ValidRequestID(id))
+	assert.NotEqual(t, id, NewRequestID())
+
+	assert.True(t, ValidRequestID("req-1.2:abc_D"))
+	assert.False(t, ValidRequestID(""))
+	assert.False(t, ValidRequestID("has space"))
+	assert.False(t, ValidRequestID("line\nbreak"))
+	assert.False(t, ValidRequestID(strings.Repeat("a", 129)))
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrInvalidAccessToken is returned when an access token is malformed, badly signed or expired
+var ErrInvalidAccessToken = errors.New("invalid access token")
+
+// AccessClaims are the claims of an access token: the user is the subject, and the token's ID
+// can be revoked individually or with its whole session.
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	SessionID uint `json:"sid"`
+}
+
+// UserID returns the ID of the user the token was issued to.
+func (claims AccessClaims) UserID() (uint, error) {
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	return uint(id), err
+}
+
+// SignAccessToken returns an access token signed with the active key for a user's session, valid for ttl,
+// along with its claims.
+func SignAccessToken(userID uint, sessionID uint, ttl time.Duration) (string, AccessClaims, error) {
+	jti, err := RandomString(16)
+	if err != nil {
+		return "", AccessClaims{}, err
+	}
+	now := time.Now()
+	claims := AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Subject:   strconv.FormatUint(uint64(userID), 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		SessionID: sessionID,
+	}
+	signed, err := CurrentKeys().Sign(claims)
+	return signed, claims, err
+}
+
+// ParseAccessToken verifies an access token's signature against the current keys and its expiry,
+// and returns its claims.
+func ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims := &AccessClaims{}
+	if err := CurrentKeys().ParseWithClaims(tokenString, claims); err != nil {
+		return nil, errors.Join(ErrInvalidAccessToken, err)
+	}
+	if claims.ID == "" || claims.SessionID == 0 || claims.ExpiresAt == nil {
+		return nil, ErrInvalidAccessToken
+	}
+	if _, err := claims.UserID(); err != nil {
+		return nil, ErrInvalidAccessToken
+	}
+	return claims, nil
+}
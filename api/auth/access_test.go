@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessToken(t *testing.T) {
+	t.Setenv("JWT_AUTH_SECRET_KEY", "test-secret")
+
+	signed, claims, err := SignAccessToken(42, 7, time.Minute)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, claims.ID)
+
+	parsed, err := ParseAccessToken(signed)
+	assert.NoError(t, err)
+	userID, err := parsed.UserID()
+	assert.NoError(t, err)
+	assert.Equal(t, uint(42), userID)
+	assert.Equal(t, uint(7), parsed.SessionID)
+	assert.Equal(t, claims.ID, parsed.ID)
+
+	other, otherClaims, err := SignAccessToken(42, 7, time.Minute)
+	assert.NoError(t, err)
+	assert.NotEqual(t, signed, other)
+	assert.NotEqual(t, claims.ID, otherClaims.ID)
+}
+
+func TestParseAccessTokenRejects(t *testing.T) {
+	t.Setenv("JWT_AUTH_SECRET_KEY", "test-secret")
+
+	expired, _, err := SignAccessToken(42, 7, -time.Minute)
+	assert.NoError(t, err)
+
+	// a token from before sessions, without a session or token ID
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": 42,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("test-secret"))
+	assert.NoError(t, err)
+
+	wrongKey, err := jwt.NewWithClaims(jwt.SigningMethodHS256, AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ID: "x", Subject: "42", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		SessionID:        7,
+	}).SignedString([]byte("another-secret"))
+	assert.NoError(t, err)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "42", "sid": 7, "jti": "x"}).
+		SignedString(jwt.UnsafeAllowNoneSignatureType)
+	assert.NoError(t, err)
+
+	for name, token := range map[string]string{"expired": expired, "legacy": legacy, "wrong key": wrongKey, "unsigned": unsigned, "garbage": "abc"} {
+		_, err := ParseAccessToken(token)
+		assert.ErrorIs(t, err, ErrInvalidAccessToken, name)
+	}
+}
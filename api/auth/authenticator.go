@@ -0,0 +1,58 @@
+// Package auth verifies user credentials against identity providers.
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrInvalidCredentials is returned when a username and password are not accepted
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Identity is an authenticated user as described by an identity provider.
+type Identity struct {
+	// Provider is the name of the provider which authenticated the user, e.g. "ldap"
+	Provider string
+	// Subject uniquely identifies the user within the provider, e.g. their LDAP DN
+	Subject  string
+	Username string
+	// Groups are the groups the user is a member of, e.g. LDAP group DNs
+	Groups []string
+}
+
+// Authenticator is an identity provider users can sign in with.
+type Authenticator interface {
+	// Name identifies the provider, and is stored with the users it authenticates
+	Name() string
+}
+
+// PasswordAuthenticator is an identity provider which checks usernames and passwords.
+type PasswordAuthenticator interface {
+	Authenticator
+	// Authenticate checks a username and password.
+	// ErrInvalidCredentials is returned if they aren't accepted, any other error means the
+	// provider couldn't be queried.
+	Authenticate(username string, password string) (*Identity, error)
+}
+
+// RedirectAuthenticator is an identity provider which users sign in with by being redirected to it,
+// such as an OpenID Connect provider.
+type RedirectAuthenticator interface {
+	Authenticator
+	// AuthCodeURL returns the address to redirect users to for signing in.
+	// state, nonce and verifier are random values which must be passed to Exchange when the user returns.
+	AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error)
+	// Exchange verifies the authorization code the user returned with and identifies the user.
+	Exchange(ctx context.Context, code string, nonce string, verifier string) (*Identity, error)
+}
+
+// RandomString returns a URL-safe random string encoding n random bytes, for use as a secret.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
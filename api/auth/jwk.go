@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+// JSONWebKey is a public key in JWK format, per RFC 7517.
+type JSONWebKey struct {
+	Kid string `json:"kid,omitempty"`
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a JWKS document.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// parseJWK converts a JSON web key into an RSA or ECDSA public key.
+func parseJWK(key JSONWebKey) (interface{}, error) {
+	decode := func(value string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, err
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+
+	switch key.Kty {
+	case "RSA":
+		n, err := decode(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := decode(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+}
+
+// publicJWK converts an RSA or ECDSA public key into a JSON web key for verifying signatures.
+func publicJWK(kid string, alg string, publicKey interface{}) (JSONWebKey, error) {
+	encode := func(i *big.Int, size int) string {
+		return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, size)))
+	}
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		e := big.NewInt(int64(key.E))
+		return JSONWebKey{
+			Kid: kid, Kty: "RSA", Use: "sig", Alg: alg,
+			N: encode(key.N, (key.N.BitLen()+7)/8),
+			E: encode(e, (e.BitLen()+7)/8),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return JSONWebKey{
+			Kid: kid, Kty: "EC", Use: "sig", Alg: alg,
+			Crv: key.Curve.Params().Name,
+			X:   encode(key.X, size),
+			Y:   encode(key.Y, size),
+		}, nil
+	}
+	return JSONWebKey{}, fmt.Errorf("unsupported public key type %T", publicKey)
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/glssn/scheduler-api/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signingKey is an asymmetric key tokens are signed or verified with.
+type signingKey struct {
+	kid    string
+	method jwt.SigningMethod
+	// private is nil for keys which can only verify tokens
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySet holds the keys access tokens are signed and verified with. Tokens are signed with the active
+// key and verified with whichever key their kid header names, so that tokens signed with a previous key
+// keep working while it is still listed. Without asymmetric keys, tokens are signed with the HS256 secret,
+// which only verifies tokens alongside keys when JWT_ACCEPT_LEGACY_HS256 is set.
+type KeySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+	secret []byte
+}
+
+var (
+	keysMu sync.RWMutex
+	keys   *KeySet
+)
+
+// SetKeys sets the key set used to sign and verify access tokens.
+func SetKeys(keySet *KeySet) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	keys = keySet
+}
+
+// CurrentKeys returns the key set used to sign and verify access tokens. Until SetKeys is called
+// this is the HS256 secret in JWT_AUTH_SECRET_KEY.
+func CurrentKeys() *KeySet {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	if keys == nil {
+		return &KeySet{secret: []byte(os.Getenv("JWT_AUTH_SECRET_KEY"))}
+	}
+	return keys
+}
+
+// LoadKeys reads the keys listed in the JWT settings from their PEM files.
+func LoadKeys(jwtConfig config.JWTConfig) (*KeySet, error) {
+	keySet := &KeySet{keys: make(map[string]*signingKey), secret: []byte(jwtConfig.Secret)}
+	for _, file := range jwtConfig.Keys {
+		if _, exists := keySet.keys[file.KID]; exists {
+			return nil, fmt.Errorf("JWT key %q is listed twice", file.KID)
+		}
+		pemBytes, err := os.ReadFile(file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read JWT key %q: %w", file.KID, err)
+		}
+		key, err := parseSigningKey(file.KID, pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse JWT key %q: %w", file.KID, err)
+		}
+		keySet.keys[file.KID] = key
+	}
+
+	if len(keySet.keys) > 0 {
+		keySet.active = keySet.keys[jwtConfig.ActiveKID]
+		if keySet.active == nil {
+			return nil, fmt.Errorf("JWT_ACTIVE_KID %q is not one of the JWT_SIGNING_KEYS", jwtConfig.ActiveKID)
+		}
+		if keySet.active.private == nil {
+			return nil, fmt.Errorf("JWT key %q can't be active as it has no private key", jwtConfig.ActiveKID)
+		}
+		if !jwtConfig.AcceptLegacyHS256 {
+			keySet.secret = nil
+		}
+	} else if len(keySet.secret) == 0 {
+		return nil, errors.New("no JWT signing keys or secret are configured")
+	}
+	return keySet, nil
+}
+
+// parseSigningKey parses an RSA or ECDSA key from a PEM file holding either a private key
+// (PKCS #8, PKCS #1 or SEC 1) or just a public key (PKIX).
+func parseSigningKey(kid string, pemBytes []byte) (*signingKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{kid: kid}
+	if signer, ok := parsed.(crypto.Signer); ok {
+		key.private = signer
+		key.public = signer.Public()
+	} else {
+		key.public = parsed
+	}
+
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		key.method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		switch public.Curve {
+		case elliptic.P256():
+			key.method = jwt.SigningMethodES256
+		case elliptic.P384():
+			key.method = jwt.SigningMethodES384
+		case elliptic.P521():
+			key.method = jwt.SigningMethodES512
+		default:
+			return nil, errors.New("unsupported elliptic curve")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key.public)
+	}
+	return key, nil
+}
+
+// Sign returns a token with claims signed by the active key.
+func (keySet *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if keySet.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(keySet.secret)
+	}
+	token := jwt.NewWithClaims(keySet.active.method, claims)
+	token.Header["kid"] = keySet.active.kid
+	return token.SignedString(keySet.active.private)
+}
+
+// ParseWithClaims verifies a token's signature with the key its kid header names, or the HS256 secret
+// for tokens without a kid if it is in use, and parses its claims.
+func (keySet *KeySet) ParseWithClaims(tokenString string, claims jwt.Claims) error {
+	methods := make([]string, 0, len(keySet.keys)+1)
+	for _, key := range keySet.keys {
+		methods = append(methods, key.method.Alg())
+	}
+	if len(keySet.secret) > 0 {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+
+	parser := jwt.NewParser(jwt.WithValidMethods(methods))
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, hasKid := token.Header["kid"].(string)
+		if !hasKid {
+			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() || len(keySet.secret) == 0 {
+				return nil, errors.New("token has no kid")
+			}
+			return keySet.secret, nil
+		}
+		key, ok := keySet.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("token algorithm %s doesn't match key %q", token.Method.Alg(), kid)
+		}
+		return key.public, nil
+	})
+	return err
+}
+
+// JWKS returns the public keys tokens are verified with, for other services to verify our tokens.
+func (keySet *KeySet) JWKS() JSONWebKeySet {
+	jwks := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(keySet.keys))}
+	for _, key := range keySet.keys {
+		if jwk, err := publicJWK(key.kid, key.method.Alg(), key.public); err == nil {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
@@ -0,0 +1,185 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glssn/scheduler-api/config"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// writePEM writes a PEM block to a file in dir and returns its path.
+func writePEM(t *testing.T, dir string, name string, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testKeyFiles writes an RSA key as PKCS #1, an ECDSA P-256 key as PKCS #8 and the public half of
+// another RSA key, and returns their paths.
+func testKeyFiles(t *testing.T) (rsaPath string, ecPath string, publicPath string) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath = writePEM(t, dir, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey))
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPath = writePEM(t, dir, "ec.pem", "PRIVATE KEY", der)
+
+	retiredKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err = x509.MarshalPKIXPublicKey(&retiredKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPath = writePEM(t, dir, "retired.pem", "PUBLIC KEY", der)
+	return rsaPath, ecPath, publicPath
+}
+
+func testClaims() AccessClaims {
+	return AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ID: "jti", Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))},
+		SessionID:        1,
+	}
+}
+
+func TestKeySetRotation(t *testing.T) {
+	rsaPath, ecPath, _ := testKeyFiles(t)
+	keyFiles := []config.JWTKeyFile{{KID: "2024-01", Path: rsaPath}, {KID: "2024-06", Path: ecPath}}
+
+	before, err := LoadKeys(config.JWTConfig{Keys: keyFiles, ActiveKID: "2024-01"})
+	assert.NoError(t, err)
+	oldToken, err := before.Sign(testClaims())
+	assert.NoError(t, err)
+	parsed, _ := jwt.Parse(oldToken, nil)
+	assert.Equal(t, "RS256", parsed.Header["alg"])
+	assert.Equal(t, "2024-01", parsed.Header["kid"])
+
+	// after rotating, new tokens are signed with the new key and old tokens still verify
+	after, err := LoadKeys(config.JWTConfig{Keys: keyFiles, ActiveKID: "2024-06"})
+	assert.NoError(t, err)
+	newToken, err := after.Sign(testClaims())
+	assert.NoError(t, err)
+	parsed, _ = jwt.Parse(newToken, nil)
+	assert.Equal(t, "ES256", parsed.Header["alg"])
+	assert.Equal(t, "2024-06", parsed.Header["kid"])
+	assert.NoError(t, after.ParseWithClaims(oldToken, &AccessClaims{}))
+	assert.NoError(t, after.ParseWithClaims(newToken, &AccessClaims{}))
+
+	// once the old key is removed its tokens are rejected
+	removed, err := LoadKeys(config.JWTConfig{Keys: keyFiles[1:], ActiveKID: "2024-06"})
+	assert.NoError(t, err)
+	assert.Error(t, removed.ParseWithClaims(oldToken, &AccessClaims{}))
+	assert.NoError(t, removed.ParseWithClaims(newToken, &AccessClaims{}))
+}
+
+func TestKeySetRejectsForgedHeaders(t *testing.T) {
+	rsaPath, _, _ := testKeyFiles(t)
+	keySet, err := LoadKeys(config.JWTConfig{Keys: []config.JWTKeyFile{{KID: "rsa", Path: rsaPath}}, ActiveKID: "rsa"})
+	assert.NoError(t, err)
+
+	// an HS256 token using the RSA public key as its secret
+	pemBytes, _ := os.ReadFile(rsaPath)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	forged.Header["kid"] = "rsa"
+	signed, err := forged.SignedString(pemBytes)
+	assert.NoError(t, err)
+	assert.Error(t, keySet.ParseWithClaims(signed, &AccessClaims{}))
+
+	// without a secret configured, HS256 tokens are never accepted
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	signed, err = unknown.SignedString([]byte(""))
+	assert.NoError(t, err)
+	assert.Error(t, keySet.ParseWithClaims(signed, &AccessClaims{}))
+}
+
+func TestKeySetSecretFallback(t *testing.T) {
+	rsaPath, _, _ := testKeyFiles(t)
+	secretOnly, err := LoadKeys(config.JWTConfig{Secret: "shared-secret"})
+	assert.NoError(t, err)
+	hmacToken, err := secretOnly.Sign(testClaims())
+	assert.NoError(t, err)
+	assert.NoError(t, secretOnly.ParseWithClaims(hmacToken, &AccessClaims{}))
+	assert.Empty(t, secretOnly.JWKS().Keys)
+
+	// switching to keys stops HS256 tokens working, even while the secret is still set
+	withKeys, err := LoadKeys(config.JWTConfig{Keys: []config.JWTKeyFile{{KID: "rsa", Path: rsaPath}}, ActiveKID: "rsa", Secret: "shared-secret"})
+	assert.NoError(t, err)
+	assert.Error(t, withKeys.ParseWithClaims(hmacToken, &AccessClaims{}))
+
+	// unless they're explicitly still accepted
+	legacy, err := LoadKeys(config.JWTConfig{Keys: []config.JWTKeyFile{{KID: "rsa", Path: rsaPath}}, ActiveKID: "rsa", Secret: "shared-secret", AcceptLegacyHS256: true})
+	assert.NoError(t, err)
+	assert.NoError(t, legacy.ParseWithClaims(hmacToken, &AccessClaims{}))
+	signed, err := legacy.Sign(testClaims())
+	assert.NoError(t, err)
+	assert.Error(t, secretOnly.ParseWithClaims(signed, &AccessClaims{}))
+
+	_, err = LoadKeys(config.JWTConfig{})
+	assert.Error(t, err)
+}
+
+func TestLoadKeysErrors(t *testing.T) {
+	rsaPath, _, publicPath := testKeyFiles(t)
+	tests := map[string]config.JWTConfig{
+		"unknown active key": {Keys: []config.JWTKeyFile{{KID: "a", Path: rsaPath}}, ActiveKID: "b"},
+		"public active key":  {Keys: []config.JWTKeyFile{{KID: "a", Path: publicPath}}, ActiveKID: "a"},
+		"missing file":       {Keys: []config.JWTKeyFile{{KID: "a", Path: filepath.Join(t.TempDir(), "missing.pem")}}, ActiveKID: "a"},
+		"duplicate kid":      {Keys: []config.JWTKeyFile{{KID: "a", Path: rsaPath}, {KID: "a", Path: publicPath}}, ActiveKID: "a"},
+	}
+	for name, jwtConfig := range tests {
+		_, err := LoadKeys(jwtConfig)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaPath, ecPath, publicPath := testKeyFiles(t)
+	keySet, err := LoadKeys(config.JWTConfig{
+		Keys:      []config.JWTKeyFile{{KID: "a-rsa", Path: rsaPath}, {KID: "b-ec", Path: ecPath}, {KID: "c-retired", Path: publicPath}},
+		ActiveKID: "b-ec",
+	})
+	assert.NoError(t, err)
+
+	jwks := keySet.JWKS()
+	assert.Len(t, jwks.Keys, 3)
+	assert.Equal(t, []string{"a-rsa", "b-ec", "c-retired"}, []string{jwks.Keys[0].Kid, jwks.Keys[1].Kid, jwks.Keys[2].Kid})
+	assert.Equal(t, "RS256", jwks.Keys[0].Alg)
+	assert.Equal(t, "ES256", jwks.Keys[1].Alg)
+	assert.Equal(t, "P-256", jwks.Keys[1].Crv)
+
+	// tokens verify with the published keys, as another service would
+	signed, err := keySet.Sign(testClaims())
+	assert.NoError(t, err)
+	_, err = jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+		for _, jwk := range jwks.Keys {
+			if jwk.Kid == token.Header["kid"] {
+				return parseJWK(jwk)
+			}
+		}
+		return nil, nil
+	})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+	"gopkg.in/ldap.v3"
+)
+
+// LDAP authenticates users against an LDAP directory, by searching for the user with a
+// read-only bind and then binding as the user with their password.
+type LDAP struct {
+	config config.LDAPConfig
+}
+
+// Name returns the provider name stored with users authenticated by LDAP.
+func (l *LDAP) Name() string {
+	return config.ProviderLDAP
+}
+
+// NewLDAP returns an LDAP authenticator using the given settings.
+func NewLDAP(ldapConfig config.LDAPConfig) *LDAP {
+	return &LDAP{config: ldapConfig}
+}
+
+// tlsConfig returns the TLS settings for connecting to the directory, trusting the CA certificates
+// in CACertFile if one is configured.
+func (l *LDAP) tlsConfig() (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(l.config.Host)
+	if err != nil {
+		host = l.config.Host
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: l.config.InsecureSkipVerify,
+	}
+	if l.config.CACertFile != "" {
+		pem, err := os.ReadFile(l.config.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read LDAP CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in LDAP CA certificate file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// connect opens a connection to the directory, using TLS as configured.
+// The caller is expected to Close the connection when finished.
+func (l *LDAP) connect() (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: l.config.Timeout}
+	var conn *ldap.Conn
+
+	switch l.config.TLS {
+	case config.LDAPTLSLDAPS:
+		tlsConfig, err := l.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		c, err := tls.DialWithDialer(dialer, "tcp", l.config.Host, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		conn = ldap.NewConn(c, true)
+		conn.Start()
+	default:
+		c, err := dialer.Dial("tcp", l.config.Host)
+		if err != nil {
+			return nil, err
+		}
+		conn = ldap.NewConn(c, false)
+		conn.Start()
+	}
+	conn.SetTimeout(l.config.Timeout)
+
+	if l.config.TLS == config.LDAPTLSStartTLS {
+		tlsConfig, err := l.tlsConfig()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// userFilter returns the search filter matching username.
+func (l *LDAP) userFilter(username string) string {
+	filter := fmt.Sprintf("(%s=%s)", l.config.UserAttribute, ldap.EscapeFilter(username))
+	if l.config.UserFilter != "" {
+		filter = fmt.Sprintf("(&%s%s)", filter, l.config.UserFilter)
+	}
+	return filter
+}
+
+// Authenticate checks a username and password against the directory.
+// ErrInvalidCredentials is returned if the user doesn't exist or the password is wrong,
+// any other error means the directory couldn't be queried.
+func (l *LDAP) Authenticate(username string, password string) (*Identity, error) {
+	// an empty password would be an unauthenticated bind, which always succeeds
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// perform the read-only bind
+	if err := conn.Bind(l.config.BindDN, l.config.BindPassword); err != nil {
+		return nil, fmt.Errorf("LDAP read-only bind failed: %w", err)
+	}
+
+	// find the user attempting to login
+	attributes := []string{"dn", l.config.UserAttribute}
+	if l.config.GroupAttribute != "" {
+		attributes = append(attributes, l.config.GroupAttribute)
+	}
+	results, err := conn.Search(ldap.NewSearchRequest(
+		l.config.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(l.config.Timeout.Seconds()), false, l.userFilter(username),
+		attributes, nil,
+	))
+	if err != nil {
+		return nil, err
+	}
+	if len(results.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := results.Entries[0]
+
+	// attempt to bind as the user
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	identity := &Identity{Provider: l.Name(), Subject: entry.DN, Username: username}
+	if l.config.GroupAttribute != "" {
+		identity.Groups = entry.GetAttributeValues(l.config.GroupAttribute)
+	}
+	if l.config.GroupFilter != "" {
+		groups, err := l.searchGroups(conn, entry.DN, username)
+		if err != nil {
+			return nil, err
+		}
+		identity.Groups = append(identity.Groups, groups...)
+	}
+	return identity, nil
+}
+
+// searchGroups returns the DNs of the groups matching GroupFilter for a user.
+// The search is made with the read-only credentials, as users can't always read group entries.
+func (l *LDAP) searchGroups(conn *ldap.Conn, dn string, username string) ([]string, error) {
+	if err := conn.Bind(l.config.BindDN, l.config.BindPassword); err != nil {
+		return nil, fmt.Errorf("LDAP read-only bind failed: %w", err)
+	}
+	filter := strings.NewReplacer(
+		"{dn}", ldap.EscapeFilter(dn),
+		"{username}", ldap.EscapeFilter(username),
+	).Replace(l.config.GroupFilter)
+
+	results, err := conn.Search(ldap.NewSearchRequest(
+		l.config.GroupBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		0, int(l.config.Timeout.Seconds()), false, filter,
+		[]string{"dn"}, nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("LDAP group search failed: %w", err)
+	}
+	groups := make([]string, 0, len(results.Entries))
+	for _, entry := range results.Entries {
+		groups = append(groups, entry.DN)
+	}
+	return groups, nil
+}
+
+// groupCommonName returns the value of the first RDN of a group DN, e.g. "admins" for
+// "cn=admins,ou=groups,dc=example,dc=com", or an empty string if dn isn't a valid DN.
+func groupCommonName(dn string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 || len(parsed.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return parsed.RDNs[0].Attributes[0].Value
+}
+
+// RoleForGroups maps group DNs onto the most privileged role given to any of them by groupRoles,
+// which is keyed by lower case group DN or common name.
+// It returns false if none of the groups are mapped to a role.
+func RoleForGroups(groups []string, groupRoles map[string]string) (string, bool) {
+	var roles []string
+	for _, group := range groups {
+		if role, ok := groupRoles[strings.ToLower(group)]; ok {
+			roles = append(roles, role)
+		}
+		if cn := groupCommonName(group); cn != "" {
+			if role, ok := groupRoles[strings.ToLower(cn)]; ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+	role := models.HighestRole(roles...)
+	return role, role != ""
+}
@@ -0,0 +1,356 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glssn/scheduler-api/config"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/asn1-ber.v1"
+	"gopkg.in/ldap.v3"
+)
+
+// fakeEntry is a user in the fake directory
+type fakeEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// fakeDirectory is an in-process LDAP server supporting just enough of the protocol for simple binds
+// and searches with equality filters.
+type fakeDirectory struct {
+	bindDN       string
+	bindPassword string
+	entries      []fakeEntry
+}
+
+var equalityFilter = regexp.MustCompile(`\(([\w-]+)=([^()]*)\)`)
+
+// start listens on a random local port, with TLS if tlsConfig is set, and returns the listener's address.
+func (d *fakeDirectory) start(t *testing.T, tlsConfig *tls.Config) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// serve answers requests on a connection until it is closed.
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			name := request.Children[1].Value.(string)
+			password := request.Children[2].Data.String()
+			result := uint16(ldap.LDAPResultInvalidCredentials)
+			if d.checkPassword(name, password) {
+				result = ldap.LDAPResultSuccess
+			}
+			conn.Write(response(messageID, ldapResult(ldap.ApplicationBindResponse, result)).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(request.Children[6])
+			for _, entry := range d.search(filter) {
+				conn.Write(response(messageID, searchEntry(entry)).Bytes())
+			}
+			conn.Write(response(messageID, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess)).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+// checkPassword reports whether a bind with name and password succeeds.
+func (d *fakeDirectory) checkPassword(name string, password string) bool {
+	if name == d.bindDN {
+		return password == d.bindPassword
+	}
+	for _, entry := range d.entries {
+		if entry.dn == name {
+			return password == entry.password
+		}
+	}
+	return false
+}
+
+// search returns the entries matching every equality term in filter.
+func (d *fakeDirectory) search(filter string) []fakeEntry {
+	var matches []fakeEntry
+	for _, entry := range d.entries {
+		matched := true
+		for _, term := range equalityFilter.FindAllStringSubmatch(filter, -1) {
+			values := entry.attributes[term[1]]
+			if term[2] == "*" && len(values) > 0 {
+				continue
+			}
+			found := false
+			for _, value := range values {
+				if strings.EqualFold(value, term[2]) {
+					found = true
+				}
+			}
+			matched = matched && found
+		}
+		if matched {
+			matches = append(matches, entry)
+		}
+	}
+	return matches
+}
+
+func response(messageID int64, op *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "MessageID"))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapResult(tag ber.Tag, resultCode uint16) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, uint64(resultCode), "resultCode"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return packet
+}
+
+func searchEntry(entry fakeEntry) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "objectName"))
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for name, values := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "val"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+	return packet
+}
+
+// testDirectory returns a directory containing the users einstein and tesla, and the group scientists.
+func testDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		bindDN:       "cn=read-only-admin,dc=example,dc=com",
+		bindPassword: "read-only",
+		entries: []fakeEntry{
+			{
+				dn:       "uid=einstein,dc=example,dc=com",
+				password: "relativity",
+				attributes: map[string][]string{
+					"uid":         {"einstein"},
+					"objectClass": {"person"},
+					"memberOf":    {"cn=Physicists,ou=groups,dc=example,dc=com"},
+				},
+			},
+			{
+				dn:         "uid=tesla,dc=example,dc=com",
+				password:   "coils",
+				attributes: map[string][]string{"uid": {"tesla"}, "objectClass": {"device"}},
+			},
+			{
+				dn: "ou=scientists,dc=example,dc=com",
+				attributes: map[string][]string{
+					"objectClass":  {"groupOfUniqueNames"},
+					"uniqueMember": {"uid=einstein,dc=example,dc=com", "uid=tesla,dc=example,dc=com"},
+				},
+			},
+		},
+	}
+}
+
+// testLDAPConfig returns settings for connecting to a test directory at addr.
+func testLDAPConfig(addr string) config.LDAPConfig {
+	return config.LDAPConfig{
+		Host:           addr,
+		TLS:            config.LDAPTLSNone,
+		BaseDN:         "dc=example,dc=com",
+		BindDN:         "cn=read-only-admin,dc=example,dc=com",
+		BindPassword:   "read-only",
+		UserAttribute:  "uid",
+		Timeout:        2 * time.Second,
+		GroupAttribute: "memberOf",
+	}
+}
+
+// selfSignedCertificate returns a TLS certificate for 127.0.0.1, and writes it to a PEM file to use as a CA.
+func selfSignedCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "fake ldap"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	addr := testDirectory().start(t, nil)
+	authenticator := NewLDAP(testLDAPConfig(addr))
+
+	identity, err := authenticator.Authenticate("einstein", "relativity")
+	assert.NoError(t, err)
+	assert.Equal(t, &Identity{
+		Provider: "ldap",
+		Subject:  "uid=einstein,dc=example,dc=com",
+		Username: "einstein",
+		Groups:   []string{"cn=Physicists,ou=groups,dc=example,dc=com"},
+	}, identity)
+
+	for _, credentials := range [][2]string{{"einstein", "wrong"}, {"nobody", "relativity"}, {"einstein", ""}} {
+		_, err = authenticator.Authenticate(credentials[0], credentials[1])
+		assert.ErrorIs(t, err, ErrInvalidCredentials, credentials[0])
+	}
+}
+
+func TestLDAPUserFilter(t *testing.T) {
+	addr := testDirectory().start(t, nil)
+	ldapConfig := testLDAPConfig(addr)
+	ldapConfig.UserFilter = "(objectClass=person)"
+	authenticator := NewLDAP(ldapConfig)
+
+	_, err := authenticator.Authenticate("einstein", "relativity")
+	assert.NoError(t, err)
+	_, err = authenticator.Authenticate("tesla", "coils")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestLDAPGroupSearch(t *testing.T) {
+	addr := testDirectory().start(t, nil)
+	ldapConfig := testLDAPConfig(addr)
+	ldapConfig.GroupBaseDN = ldapConfig.BaseDN
+	ldapConfig.GroupFilter = "(&(objectClass=groupOfUniqueNames)(uniqueMember={dn}))"
+	authenticator := NewLDAP(ldapConfig)
+
+	identity, err := authenticator.Authenticate("einstein", "relativity")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cn=Physicists,ou=groups,dc=example,dc=com", "ou=scientists,dc=example,dc=com"}, identity.Groups)
+
+	identity, err = authenticator.Authenticate("tesla", "coils")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ou=scientists,dc=example,dc=com"}, identity.Groups)
+}
+
+func TestRoleForGroups(t *testing.T) {
+	groupRoles := map[string]string{
+		"cn=admins,ou=groups,dc=example,dc=com": "admin",
+		"physicists":                            "editor",
+		"scientists":                            "viewer",
+	}
+	tests := []struct {
+		groups []string
+		role   string
+		ok     bool
+	}{
+		{[]string{"CN=Admins,OU=Groups,DC=example,DC=com"}, "admin", true},
+		{[]string{"cn=admins,ou=groups,dc=example,dc=com"}, "admin", true},
+		{[]string{"cn=Physicists,ou=groups,dc=example,dc=com"}, "editor", true},
+		{[]string{"ou=scientists,dc=example,dc=com", "cn=physicists,dc=example,dc=com"}, "editor", true},
+		{[]string{"ou=scientists,dc=example,dc=com", "cn=admins,ou=groups,dc=example,dc=com"}, "admin", true},
+		{[]string{"cn=chemists,dc=example,dc=com"}, "", false},
+		{nil, "", false},
+	}
+	for _, test := range tests {
+		role, ok := RoleForGroups(test.groups, groupRoles)
+		assert.Equal(t, test.role, role, test.groups)
+		assert.Equal(t, test.ok, ok, test.groups)
+	}
+}
+
+func TestLDAPSWithCustomCA(t *testing.T) {
+	certificate, caFile := selfSignedCertificate(t)
+	addr := testDirectory().start(t, &tls.Config{Certificates: []tls.Certificate{certificate}})
+
+	ldapConfig := testLDAPConfig(addr)
+	ldapConfig.TLS = config.LDAPTLSLDAPS
+	ldapConfig.CACertFile = caFile
+	_, err := NewLDAP(ldapConfig).Authenticate("einstein", "relativity")
+	assert.NoError(t, err)
+
+	// the self-signed certificate isn't trusted without the custom CA
+	ldapConfig.CACertFile = ""
+	_, err = NewLDAP(ldapConfig).Authenticate("einstein", "relativity")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrInvalidCredentials))
+}
+
+func TestLDAPTimeout(t *testing.T) {
+	// a server which accepts connections but never answers
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ldapConfig := testLDAPConfig(listener.Addr().String())
+	ldapConfig.Timeout = 200 * time.Millisecond
+	started := time.Now()
+	_, err = NewLDAP(ldapConfig).Authenticate("einstein", "relativity")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(started), 2*time.Second)
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/glssn/scheduler-api/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the shortest password accepted for local users
+const MinPasswordLength = 8
+
+// ErrPasswordTooShort is returned when hashing a password shorter than MinPasswordLength
+var ErrPasswordTooShort = errors.New("password must be at least 8 characters")
+
+// dummyHash is compared against when a user doesn't exist, so that unknown and known usernames
+// take the same time to reject
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("scheduler-api dummy password"), bcrypt.DefaultCost)
+
+// PasswordLookup returns the bcrypt hash of a local user's password, or an empty string if there's no such user.
+type PasswordLookup func(username string) (string, error)
+
+// Local authenticates users against bcrypt password hashes stored by the scheduler.
+type Local struct {
+	lookup PasswordLookup
+}
+
+// NewLocal returns a local password authenticator which finds password hashes with lookup.
+func NewLocal(lookup PasswordLookup) *Local {
+	return &Local{lookup: lookup}
+}
+
+// Name returns the provider name stored with local users.
+func (l *Local) Name() string {
+	return config.ProviderLocal
+}
+
+// Authenticate checks a username and password against the user's stored hash.
+func (l *Local) Authenticate(username string, password string) (*Identity, error) {
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	hash, err := l.lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	if hash == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &Identity{Provider: l.Name(), Subject: username, Username: username}, nil
+}
+
+// HashPassword returns the bcrypt hash of a local user's password.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalAuthenticate(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	assert.NoError(t, err)
+	authenticator := NewLocal(func(username string) (string, error) {
+		if username == "alice" {
+			return hash, nil
+		}
+		return "", nil
+	})
+
+	identity, err := authenticator.Authenticate("alice", "correct horse")
+	assert.NoError(t, err)
+	assert.Equal(t, &Identity{Provider: "local", Subject: "alice", Username: "alice"}, identity)
+
+	for _, credentials := range [][2]string{{"alice", "battery staple"}, {"bob", "correct horse"}, {"alice", ""}} {
+		_, err = authenticator.Authenticate(credentials[0], credentials[1])
+		assert.ErrorIs(t, err, ErrInvalidCredentials, credentials[0])
+	}
+
+	failing := NewLocal(func(string) (string, error) { return "", errors.New("database unavailable") })
+	_, err = failing.Authenticate("alice", "correct horse")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestHashPassword(t *testing.T) {
+	_, err := HashPassword("short")
+	assert.ErrorIs(t, err, ErrPasswordTooShort)
+
+	hash, err := HashPassword("long enough")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "long enough", hash)
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/glssn/scheduler-api/config"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// oidcSigningMethods are the ID token signing algorithms accepted from the provider
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// oidcDiscovery is the subset of the provider's configuration document used to sign users in.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDC signs users in with an OpenID Connect provider using the authorization code flow with PKCE.
+// The provider's configuration and signing keys are discovered from the issuer URL and cached.
+type OIDC struct {
+	config config.OIDCConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]interface{}
+}
+
+// NewOIDC returns an OpenID Connect authenticator using the given settings.
+func NewOIDC(oidcConfig config.OIDCConfig) *OIDC {
+	return &OIDC{
+		config: oidcConfig,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Name returns the provider name stored with users authenticated by OpenID Connect.
+func (o *OIDC) Name() string {
+	return config.ProviderOIDC
+}
+
+// getJSON fetches a JSON document into v.
+func (o *OIDC) getJSON(ctx context.Context, address string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, address, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s returned %s", address, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// discover returns the provider's configuration, fetching it on first use.
+func (o *OIDC) discover(ctx context.Context) (*oidcDiscovery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.discovery != nil {
+		return o.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := o.getJSON(ctx, o.config.IssuerURL+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(discovery.Issuer, "/") != o.config.IssuerURL {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", discovery.Issuer, o.config.IssuerURL)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+	o.discovery = &discovery
+	return o.discovery, nil
+}
+
+// signingKey returns the provider's public key with the given key ID. The keys are fetched again
+// when an unknown key ID is seen, so that rotated keys are picked up.
+func (o *OIDC) signingKey(ctx context.Context, discovery *oidcDiscovery, kid string) (interface{}, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+
+	var jwks JSONWebKeySet
+	if err := o.getJSON(ctx, discovery.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("fetching OIDC signing keys failed: %w", err)
+	}
+	o.keys = make(map[string]interface{})
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if key, err := parseJWK(jwk); err == nil {
+			o.keys[jwk.Kid] = key
+		}
+	}
+	if key, ok := o.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown OIDC signing key %q", kid)
+}
+
+// codeChallenge returns the S256 PKCE challenge for a code verifier.
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider's authorization endpoint address for signing a user in.
+func (o *OIDC) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	discovery, err := o.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	authURL, err := url.Parse(discovery.AuthorizationEndpoint)
+	if err != nil {
+		return "", err
+	}
+	query := authURL.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", o.config.ClientID)
+	query.Set("redirect_uri", o.config.RedirectURL)
+	query.Set("scope", strings.Join(append([]string{"openid"}, o.config.Scopes...), " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", codeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+	authURL.RawQuery = query.Encode()
+	return authURL.String(), nil
+}
+
+// Exchange redeems an authorization code at the provider's token endpoint, verifies the ID token
+// it returns and identifies the user from its claims.
+// ErrInvalidCredentials is returned if the code or the ID token aren't accepted.
+func (o *OIDC) Exchange(ctx context.Context, code string, nonce string, verifier string) (*Identity, error) {
+	discovery, err := o.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {o.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(o.config.ClientID), url.QueryEscape(o.config.ClientSecret))
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("OIDC token response could not be read: %w", err)
+	}
+	if tokens.Error == "invalid_grant" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, tokens.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return nil, fmt.Errorf("OIDC token request returned %s %s", resp.Status, tokens.Error)
+	}
+
+	claims, err := o.verifyIDToken(ctx, discovery, tokens.IDToken, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+	return o.identity(claims)
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry and nonce, and returns its claims.
+func (o *OIDC) verifyIDToken(ctx context.Context, discovery *oidcDiscovery, idToken string, nonce string) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(oidcSigningMethods))
+	claims := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return o.signingKey(ctx, discovery, kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(discovery.Issuer, true) {
+		return nil, errors.New("ID token has the wrong issuer")
+	}
+	if !claims.VerifyAudience(o.config.ClientID, true) {
+		return nil, errors.New("ID token has the wrong audience")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != o.config.ClientID {
+		return nil, errors.New("ID token was issued to another client")
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("ID token has no expiry")
+	}
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, errors.New("ID token has the wrong nonce")
+	}
+	return claims, nil
+}
+
+// identity converts verified ID token claims into an Identity.
+func (o *OIDC) identity(claims jwt.MapClaims) (*Identity, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: ID token has no subject", ErrInvalidCredentials)
+	}
+
+	identity := &Identity{Provider: o.Name(), Subject: subject, Username: subject}
+	for _, claim := range []string{o.config.UsernameClaim, "email"} {
+		if username, _ := claims[claim].(string); username != "" {
+			identity.Username = username
+			break
+		}
+	}
+	if groups, ok := claims[o.config.GroupsClaim].([]interface{}); ok {
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	}
+	return identity, nil
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/glssn/scheduler-api/config"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockIssuer is a local OpenID Connect provider which issues ID tokens for a single authorization code.
+type mockIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// code is the authorization code the token endpoint accepts
+	code string
+	// challenge is the PKCE challenge sent with the authorization request
+	challenge string
+	// claims are added to, or override, the claims of issued ID tokens
+	claims jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{key: key, code: "valid-code", claims: jwt.MapClaims{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "test-key",
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if clientID != "scheduler" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		if r.PostFormValue("code") != issuer.code || codeChallenge(r.PostFormValue("code_verifier")) != issuer.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": issuer.idToken(t), "token_type": "Bearer"})
+	})
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// idToken returns a signed ID token for the user einstein.
+func (issuer *mockIssuer) idToken(t *testing.T) string {
+	claims := jwt.MapClaims{
+		"iss":                issuer.server.URL,
+		"aud":                "scheduler",
+		"sub":                "248289761001",
+		"preferred_username": "einstein",
+		"groups":             []string{"physicists"},
+		"nonce":              "expected-nonce",
+		"iat":                time.Now().Unix(),
+		"exp":                time.Now().Add(time.Minute).Unix(),
+	}
+	for name, value := range issuer.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(issuer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (issuer *mockIssuer) config() config.OIDCConfig {
+	return config.OIDCConfig{
+		IssuerURL:     issuer.server.URL,
+		ClientID:      "scheduler",
+		ClientSecret:  "secret",
+		RedirectURL:   "http://localhost:3000/auth/oidc/callback",
+		Scopes:        []string{"profile"},
+		UsernameClaim: "preferred_username",
+		GroupsClaim:   "groups",
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	issuer := newMockIssuer(t)
+	authURL, err := NewOIDC(issuer.config()).AuthCodeURL(context.Background(), "the-state", "the-nonce", "the-verifier")
+	assert.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "/authorize", parsed.Path)
+	query := parsed.Query()
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "scheduler", query.Get("client_id"))
+	assert.Equal(t, "http://localhost:3000/auth/oidc/callback", query.Get("redirect_uri"))
+	assert.Equal(t, "openid profile", query.Get("scope"))
+	assert.Equal(t, "the-state", query.Get("state"))
+	assert.Equal(t, "the-nonce", query.Get("nonce"))
+	assert.Equal(t, codeChallenge("the-verifier"), query.Get("code_challenge"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+}
+
+func TestOIDCExchange(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.challenge = codeChallenge("the-verifier")
+	authenticator := NewOIDC(issuer.config())
+
+	identity, err := authenticator.Exchange(context.Background(), "valid-code", "expected-nonce", "the-verifier")
+	assert.NoError(t, err)
+	assert.Equal(t, &Identity{
+		Provider: "oidc",
+		Subject:  "248289761001",
+		Username: "einstein",
+		Groups:   []string{"physicists"},
+	}, identity)
+
+	_, err = authenticator.Exchange(context.Background(), "wrong-code", "expected-nonce", "the-verifier")
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "wrong code")
+	_, err = authenticator.Exchange(context.Background(), "valid-code", "expected-nonce", "wrong-verifier")
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "wrong verifier")
+	_, err = authenticator.Exchange(context.Background(), "valid-code", "other-nonce", "the-verifier")
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "wrong nonce")
+}
+
+func TestOIDCRejectsInvalidIDTokens(t *testing.T) {
+	tests := map[string]jwt.MapClaims{
+		"expired":        {"exp": time.Now().Add(-time.Minute).Unix()},
+		"wrong audience": {"aud": "another-client"},
+		"wrong issuer":   {"iss": "https://attacker.example.com"},
+		"no subject":     {"sub": ""},
+	}
+	for name, claims := range tests {
+		issuer := newMockIssuer(t)
+		issuer.challenge = codeChallenge("the-verifier")
+		issuer.claims = claims
+
+		_, err := NewOIDC(issuer.config()).Exchange(context.Background(), "valid-code", "expected-nonce", "the-verifier")
+		assert.ErrorIs(t, err, ErrInvalidCredentials, name)
+	}
+
+	// an ID token signed by a key the issuer doesn't publish
+	issuer := newMockIssuer(t)
+	issuer.challenge = codeChallenge("the-verifier")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer.key = otherKey
+	_, err = NewOIDC(issuer.config()).Exchange(context.Background(), "valid-code", "expected-nonce", "the-verifier")
+	assert.ErrorIs(t, err, ErrInvalidCredentials, "wrong key")
+}
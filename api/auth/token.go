@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// APITokenPrefix starts every API token, so they can be told apart from other bearer tokens and spotted by secret scanners
+const APITokenPrefix = "sch_"
+
+// apiTokenDisplayLength is how much of a token is stored in the clear to help users recognise it
+const apiTokenDisplayLength = len(APITokenPrefix) + 6
+
+// NewAPIToken returns a new random API token, the hash to store for it and its displayable prefix.
+func NewAPIToken() (token string, hash string, prefix string, err error) {
+	secret, err := RandomString(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	token = APITokenPrefix + secret
+	return token, HashToken(token), token[:apiTokenDisplayLength], nil
+}
+
+// HashToken returns the hash stored for an API or refresh token. Tokens are long and random,
+// so a fast unsalted hash is enough to make a leaked hash useless.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIToken reports whether a bearer token looks like an API token.
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, APITokenPrefix)
+}
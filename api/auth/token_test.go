@@ -0,0 +1,24 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAPIToken(t *testing.T) {
+	token, hash, prefix, err := NewAPIToken()
+	assert.NoError(t, err)
+	assert.True(t, IsAPIToken(token))
+	assert.True(t, strings.HasPrefix(token, prefix))
+	assert.Len(t, prefix, len("sch_")+6)
+	assert.Equal(t, HashToken(token), hash)
+	assert.NotContains(t, hash, token[len(prefix):])
+
+	other, otherHash, _, err := NewAPIToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, hash, otherHash)
+	assert.False(t, IsAPIToken("legacy-static-token"))
+}
@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+)
+
+// auditEntities lists the entities which can be filtered on
+var auditEntities = map[string]bool{audit.EntityEvent: true, audit.EntityUser: true}
+
+// auditActions lists the actions which can be filtered on
+var auditActions = map[string]bool{audit.ActionCreate: true, audit.ActionUpdate: true, audit.ActionDelete: true, audit.ActionRestore: true}
+
+// AuditPage is a page of the audit log, newest first, with the cursor of the next page if there is one.
+type AuditPage struct {
+	Data       []models.AuditLog `json:"data"`
+	NextCursor *string           `json:"next_cursor"`
+}
+
+// requestActor returns the caller, or nil for requests authenticated with an allowed token, which have no user.
+func requestActor(c *gin.Context) *models.User {
+	if user, ok := currentUser(c); ok {
+		return &user
+	}
+	return nil
+}
+
+// newAuditLog returns the record of actor changing an entity in the request, before being nil for a create
+// and after being nil for a delete.
+func newAuditLog(c *gin.Context, actor *models.User, action string, entity string, id uint, before interface{}, after interface{}) (models.AuditLog, error) {
+	return models.NewAuditLog(actor, c.GetString("request_id"), action, entity, id, before, after)
+}
+
+// recordChangeBy records actor changing an entity in the audit log, as part of the transaction making the change.
+func recordChangeBy(c *gin.Context, tx *gorm.DB, actor *models.User, action string, entity string, id uint, before interface{}, after interface{}) error {
+	entry, err := newAuditLog(c, actor, action, entity, id, before, after)
+	if err != nil {
+		return err
+	}
+	return tx.Create(&entry).Error
+}
+
+// recordChange records the caller changing an entity in the audit log, as part of the transaction making the change.
+func recordChange(c *gin.Context, tx *gorm.DB, action string, entity string, id uint, before interface{}, after interface{}) error {
+	return recordChangeBy(c, tx, requestActor(c), action, entity, id, before, after)
+}
+
+// GET /api/audit
+// List the audit log of changes to events and users, newest first
+// Filter by entity (event or user) and entity_id, by actor_id, the user who made the changes, by action
+// (create, update, delete or restore), by request_id, and by start_date and end_date, which bound when the changes were made
+// A date-only end_date includes the whole of that day, and dates without an offset are in the tz parameter's zone,
+// defaulting to the caller's timezone
+// Up to limit entries (default 100, at most 1000) are returned as data, with a next_cursor which is passed
+// as cursor to get the next page, and is null on the last page
+// If a parameter is invalid, return a 400 status code
+func GetAuditLogs(c *gin.Context) {
+	query, loc, limit, err := auditQuery(c, initializers.DB.Model(&models.AuditLog{}))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	logs := make([]models.AuditLog, 0)
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find audit logs"})
+		return
+	}
+	page := AuditPage{Data: logs}
+	if len(logs) > limit {
+		page.Data = logs[:limit]
+		next := strconv.FormatUint(uint64(page.Data[limit-1].ID), 10)
+		page.NextCursor = &next
+	}
+	for i := range page.Data {
+		page.Data[i].CreatedAt = page.Data[i].CreatedAt.In(loc)
+	}
+	c.JSON(http.StatusOK, page)
+}
+
+// auditQuery applies the filters of a GET /api/audit request to query, returning the zone dates are in
+// and the page size.
+func auditQuery(c *gin.Context, query *gorm.DB) (*gorm.DB, *time.Location, int, error) {
+	loc, ok := requestLocation(c)
+	if !ok {
+		return nil, nil, 0, errors.New("unknown timezone")
+	}
+
+	if entity := c.Query("entity"); entity != "" {
+		if !auditEntities[entity] {
+			return nil, nil, 0, errors.New("entity must be event or user")
+		}
+		query = query.Where("entity = ?", entity)
+	}
+	if action := c.Query("action"); action != "" {
+		if !auditActions[action] {
+			return nil, nil, 0, errors.New("action must be create, update, delete or restore")
+		}
+		query = query.Where("action = ?", action)
+	}
+	for _, key := range []string{"entity_id", "actor_id"} {
+		value := c.Query(key)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid %s %q", key, value)
+		}
+		query = query.Where(key+" = ?", id)
+	}
+	if requestID := c.Query("request_id"); requestID != "" {
+		query = query.Where("request_id = ?", requestID)
+	}
+
+	if startDate := c.Query("start_date"); startDate != "" {
+		start, err := ParseTimeIn(startDate, loc)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid start_date: %w", err)
+		}
+		query = query.Where("created_at >= ?", start)
+	}
+	if endDate := c.Query("end_date"); endDate != "" {
+		end, err := ParseTimeIn(endDate, loc)
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("invalid end_date: %w", err)
+		}
+		if end.Equal(time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, end.Location())) {
+			end = end.AddDate(0, 0, 1)
+		}
+		query = query.Where("created_at < ?", end)
+	}
+
+	limit := defaultSearchLimit
+	if value := c.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			return nil, nil, 0, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		id, err := strconv.ParseUint(cursor, 10, 0)
+		if err != nil {
+			return nil, nil, 0, errors.New("invalid cursor")
+		}
+		query = query.Where("id < ?", id)
+	}
+	return query, loc, limit, nil
+}
@@ -1,23 +1,200 @@
 package controllers
 
 import (
+	"crypto/subtle"
+	"errors"
+	"log"
 	"net/http"
 	"os"
-	"time"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
+	"github.com/glssn/scheduler-api/api/auth"
 	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
 	"github.com/glssn/scheduler-api/initializers"
-	"github.com/golang-jwt/jwt/v4"
-	"github.com/nerney/dappy"
+	"gorm.io/gorm"
 )
 
-// Login logs the user in and returns a JWT token.
+// defaultRole returns the role given to new users, set by the DEFAULT_USER_ROLE
+// environment variable and defaulting to viewer.
+func defaultRole() string {
+	role := models.NormaliseRole(os.Getenv("DEFAULT_USER_ROLE"))
+	if !models.IsValidRole(role) {
+		return models.RoleViewer
+	}
+	return role
+}
+
+// isAdminIdentity checks if an identity is in the comma-separated ADMIN_USERNAMES environment variable.
+// Usernames only match LDAP and local identities, since an OpenID Connect username is a claim of the identity
+// provider which anybody able to sign in to it might make, so OIDC admins are listed as oidc:<subject> instead.
+func isAdminIdentity(identity *auth.Identity) bool {
+	trusted := identity.Provider == config.ProviderLDAP || identity.Provider == config.ProviderLocal
+	for _, admin := range strings.Split(os.Getenv("ADMIN_USERNAMES"), ",") {
+		admin = strings.TrimSpace(admin)
+		if admin == "" {
+			continue
+		}
+		if (trusted && admin == identity.Username) || admin == identity.Provider+":"+identity.Subject {
+			return true
+		}
+	}
+	return false
+}
+
+// oidcStateCookie holds the state, nonce and PKCE verifier of an OpenID Connect login in progress
+const oidcStateCookie = "oidc_state"
+
+// oidcProvider returns the OpenID Connect authenticator, which caches the provider's configuration and keys.
+var oidcProvider = sync.OnceValue(func() *auth.OIDC {
+	return auth.NewOIDC(config.OIDC)
+})
+
+// localPasswordHash returns the password hash of the local user with username.
+func localPasswordHash(username string) (string, error) {
+	var user models.User
+	err := initializers.DB.Where("provider = ? AND subject = ?", config.ProviderLocal, username).Limit(1).Find(&user).Error
+	return user.PasswordHash, err
+}
+
+// passwordAuthenticators returns the enabled providers which check usernames and passwords,
+// in the order they're listed in AUTH_PROVIDERS.
+func passwordAuthenticators() []auth.PasswordAuthenticator {
+	var authenticators []auth.PasswordAuthenticator
+	for _, provider := range config.AuthProviders {
+		switch provider {
+		case config.ProviderLDAP:
+			authenticators = append(authenticators, auth.NewLDAP(config.LDAP))
+		case config.ProviderLocal:
+			authenticators = append(authenticators, auth.NewLocal(localPasswordHash))
+		}
+	}
+	return authenticators
+}
+
+// groupRole returns the role given to a user by their groups, through the provider's
+// LDAP_GROUP_ROLES or OIDC_GROUP_ROLES mapping. Users in no mapped groups get the default role.
+// It returns false if the provider has no mapping, in which case roles are managed in the scheduler.
+func groupRole(identity *auth.Identity) (string, bool) {
+	var groupRoles map[string]string
+	switch identity.Provider {
+	case config.ProviderLDAP:
+		groupRoles = config.LDAP.GroupRoles
+	case config.ProviderOIDC:
+		groupRoles = config.OIDC.GroupRoles
+	}
+	if len(groupRoles) == 0 {
+		return "", false
+	}
+	if role, ok := auth.RoleForGroups(identity.Groups, groupRoles); ok {
+		return role, true
+	}
+	return defaultRole(), true
+}
+
+// findOrCreateUser returns the user with an identity's provider and subject, creating them on their first login.
+// Users from before providers were recorded are claimed by the LDAP identity with the same username.
+// A new user whose username is already taken by a user of another provider is named username@provider.
+// Users are recorded in the audit log as making these changes themselves.
+func findOrCreateUser(c *gin.Context, identity *auth.Identity) (models.User, error) {
+	var user models.User
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).Limit(1).Find(&user).Error
+		if err != nil || user.ID != 0 {
+			return err
+		}
+
+		if identity.Provider == config.ProviderLDAP {
+			err := tx.Where("provider = '' AND username = ? AND role <> ?", identity.Username, models.RoleBot).Limit(1).Find(&user).Error
+			if err != nil {
+				return err
+			}
+			if user.ID != 0 {
+				before := user
+				user.Provider, user.Subject = identity.Provider, identity.Subject
+				if err := tx.Model(&user).Updates(models.User{Provider: user.Provider, Subject: user.Subject}).Error; err != nil {
+					return err
+				}
+				return recordChangeBy(c, tx, &user, audit.ActionUpdate, audit.EntityUser, user.ID, before, user)
+			}
+		}
+
+		username := identity.Username
+		var taken int64
+		if err := tx.Model(&models.User{}).Where("username = ?", username).Count(&taken).Error; err != nil {
+			return err
+		}
+		if taken > 0 {
+			username = identity.Username + "@" + identity.Provider
+		}
+		user = models.User{Username: username, Role: defaultRole(), Provider: identity.Provider, Subject: identity.Subject}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return recordChangeBy(c, tx, &user, audit.ActionCreate, audit.EntityUser, user.ID, nil, user)
+	})
+	return user, err
+}
+
+// completeLogin finds or creates the user for an authenticated identity, updates their role and
+// starts a session. On failure the error response is written and false is returned.
+func completeLogin(c *gin.Context, identity *auth.Identity) (models.User, bool) {
+	user, err := findOrCreateUser(c, identity)
+	if err != nil {
+		log.Println("Failed to create user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create user",
+		})
+		return user, false
+	}
+
+	// Re-evaluate the user's role from their groups on every login,
+	// users listed in ADMIN_USERNAMES are always admins
+	role := user.Role
+	if mappedRole, ok := groupRole(identity); ok {
+		role = mappedRole
+	}
+	if isAdminIdentity(identity) {
+		role = models.RoleAdmin
+	}
+	if role != user.Role {
+		before := user
+		user.Role = role
+		err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&user).Update("role", role).Error; err != nil {
+				return err
+			}
+			return recordChangeBy(c, tx, &user, audit.ActionUpdate, audit.EntityUser, user.ID, before, user)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Failed to update user role",
+			})
+			return user, false
+		}
+	}
+
+	if err := startSession(c, user); err != nil {
+		log.Println("Failed to start session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Failed to create token",
+		})
+		return user, false
+	}
+	return user, true
+}
+
+// Login logs the user in with a username and password and returns a JWT token.
+// The password providers in AUTH_PROVIDERS are tried in turn, unless the body names one as Provider.
 func Login(c *gin.Context) {
 	// get the user and pass from the request body
 	var body struct {
 		User     string
 		Password string
+		Provider string
 	}
 
 	if c.Bind(&body) != nil {
@@ -27,58 +204,128 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// Attempt to bind the requested user to LDAP
-	client, err := dappy.New(dappy.Config{
-		BaseDN: "dc=example,dc=com",
-		Filter: "uid",
-		ROUser: dappy.User{Name: "cn=read-only-admin,dc=example,dc=com", Pass: "password"},
-		Host:   "ldap.forumsys.com:389",
-	})
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "Unable to connect to LDAP backend",
+	var authenticators []auth.PasswordAuthenticator
+	for _, authenticator := range passwordAuthenticators() {
+		if body.Provider == "" || authenticator.Name() == body.Provider {
+			authenticators = append(authenticators, authenticator)
+		}
+	}
+	if len(authenticators) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "No password login provider is enabled",
 		})
+		return
 	}
 
-	if err := client.Auth(body.User, body.Password); err != nil {
+	// Attempt to authenticate with each provider until one accepts the credentials
+	var identity *auth.Identity
+	var backendErr error
+	for _, authenticator := range authenticators {
+		var err error
+		identity, err = authenticator.Authenticate(body.User, body.Password)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, auth.ErrInvalidCredentials) {
+			log.Printf("%s authentication failed: %v", authenticator.Name(), err)
+			backendErr = err
+		}
+	}
+	if identity == nil {
+		if backendErr != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "Unable to connect to authentication backend",
+			})
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"error": "Invalid username and password",
 		})
 		return
 	}
 
-	// Create the user in the database
-	user := models.User{Username: body.User, Role: "Viewer"}
-	result := initializers.DB.FirstOrCreate(&user, models.User{Username: body.User})
-
-	if result.Error != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Failed to create user",
-		})
+	user, ok := completeLogin(c, identity)
+	if !ok {
+		return
 	}
+	c.JSON(http.StatusOK, userToAPIUser(user))
+}
 
-	apiUser := userToAPIUser(user)
+// GET /auth/oidc/login
+// Redirect the user to the OpenID Connect provider to sign in
+func OIDCLogin(c *gin.Context) {
+	if !config.ProviderEnabled(config.ProviderOIDC) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
 
-	// Generate the JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": user.ID,
-		"exp": time.Now().Add(time.Hour * 24 * 90).Unix(),
-	})
+	values := make([]string, 3)
+	for i := range values {
+		value, err := auth.RandomString(32)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start OIDC login"})
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
 
-	// Sign the token
-	tokenStringSigned, err := token.SignedString([]byte(os.Getenv("JWT_AUTH_SECRET_KEY")))
+	authURL, err := oidcProvider().AuthCodeURL(c.Request.Context(), state, nonce, verifier)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Failed to create token",
-		})
+		log.Println("OIDC login failed:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to connect to OIDC provider"})
 		return
 	}
 
-	// Send the token back
 	c.SetSameSite(http.SameSiteLaxMode)
-	c.SetCookie("Authorization", tokenStringSigned, 3600*24*90, "", "", false, true)
-	c.JSON(http.StatusOK, apiUser)
+	c.SetCookie(oidcStateCookie, strings.Join(values, "."), 600, "/auth/oidc", "", false, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// GET /auth/oidc/callback
+// Complete an OpenID Connect login when the provider redirects the user back
+// Sets the session cookie, then redirects to OIDC_POST_LOGIN_URL or returns the user
+func OIDCCallback(c *gin.Context) {
+	if !config.ProviderEnabled(config.ProviderOIDC) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "OIDC login is not enabled"})
+		return
+	}
+
+	// the state cookie can only be used once
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", false, true)
+
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "OIDC login failed: " + providerError})
+		return
+	}
+	values := strings.Split(cookie, ".")
+	if len(values) != 3 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid OIDC state"})
+		return
+	}
+	nonce, verifier := values[1], values[2]
+
+	identity, err := oidcProvider().Exchange(c.Request.Context(), c.Query("code"), nonce, verifier)
+	if err != nil {
+		log.Println("OIDC login failed:", err)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "OIDC login failed"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unable to connect to OIDC provider"})
+		return
+	}
+
+	user, ok := completeLogin(c, identity)
+	if !ok {
+		return
+	}
+	if config.OIDC.PostLoginURL != "" {
+		c.Redirect(http.StatusFound, config.OIDC.PostLoginURL)
+		return
+	}
+	c.JSON(http.StatusOK, userToAPIUser(user))
 }
 
 // Validate validates the user and returns an APIUser struct.
@@ -100,7 +347,9 @@ func Validate(c *gin.Context) {
 	c.JSON(http.StatusOK, apiUser)
 }
 
-func Logout(c *gin.Context) {
-	c.SetCookie("Authorization", "", -1, "", "", false, true)
-	c.Status(http.StatusOK)
+// GET /.well-known/jwks.json
+// Get the public keys access tokens are signed with, so that other services can verify them
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, auth.CurrentKeys().JWKS())
 }
@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/auth"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// login completes the login of identity, as the login endpoints do once it has been authenticated.
+func login(t *testing.T, identity auth.Identity) models.User {
+	t.Helper()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/login", nil)
+	user, ok := completeLogin(c, &identity)
+	require.True(t, ok)
+	return user
+}
+
+func TestIsAdminIdentity(t *testing.T) {
+	t.Setenv("ADMIN_USERNAMES", "alice, oidc:0b5e1c")
+
+	assert.True(t, isAdminIdentity(&auth.Identity{Provider: "ldap", Subject: "cn=alice,dc=example", Username: "alice"}))
+	assert.True(t, isAdminIdentity(&auth.Identity{Provider: "local", Subject: "alice", Username: "alice"}))
+	assert.True(t, isAdminIdentity(&auth.Identity{Provider: "oidc", Subject: "0b5e1c", Username: "bob@example.com"}))
+	assert.False(t, isAdminIdentity(&auth.Identity{Provider: "oidc", Subject: "9f3a77", Username: "alice"}))
+	assert.False(t, isAdminIdentity(&auth.Identity{Provider: "ldap", Subject: "cn=bob,dc=example", Username: "bob"}))
+}
+
+func TestCompleteLoginUsernameCollision(t *testing.T) {
+	setUpDB(t)
+	t.Setenv("ADMIN_USERNAMES", "alice")
+	t.Setenv("JWT_AUTH_SECRET_KEY", "secret")
+
+	// an OIDC identity claiming an admin's username before the admin has logged in takes the name, but not the role
+	impostor := login(t, auth.Identity{Provider: "oidc", Subject: "9f3a77", Username: "alice"})
+	assert.Equal(t, "alice", impostor.Username)
+	assert.Equal(t, models.RoleViewer, impostor.Role)
+
+	admin := login(t, auth.Identity{Provider: "ldap", Subject: "cn=alice,dc=example", Username: "alice"})
+	assert.Equal(t, "alice@ldap", admin.Username)
+	assert.Equal(t, models.RoleAdmin, admin.Role)
+
+	// logging in again doesn't promote the impostor either
+	impostor = login(t, auth.Identity{Provider: "oidc", Subject: "9f3a77", Username: "alice"})
+	assert.Equal(t, models.RoleViewer, impostor.Role)
+}
@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/ical"
+)
+
+// calendarProdID identifies this API as the producer of iCalendar feeds
+const calendarProdID = "-//glssn//scheduler-api//EN"
+
+// eventUID returns the stable iCalendar UID of an event, derived from its ID.
+func eventUID(event models.Event) string {
+	return fmt.Sprintf("event-%d@scheduler-api", event.ID)
+}
+
+// eventToICalEvent converts an Event struct to an iCalendar VEVENT.
+func eventToICalEvent(event models.Event) ical.Event {
+	summary := event.Title
+	if summary == "" {
+		summary = event.Type
+	}
+	icalEvent := ical.Event{
+		UID:          eventUID(event),
+		Summary:      summary,
+		Categories:   event.Type,
+		Start:        event.StartDate,
+		End:          event.EndDate,
+		AllDay:       event.AllDay,
+		Created:      event.CreatedAt,
+		LastModified: event.UpdatedAt,
+	}
+	if event.AllDay && event.StartDay != nil && event.EndDay != nil {
+		// all-day events are exported as their dates, which don't depend on a zone
+		icalEvent.Start = event.StartDay.In(time.UTC)
+		icalEvent.End = event.EndDay.AddDays(1).In(time.UTC)
+	}
+	if isRecurring(event) {
+		rule, err := eventRule(event)
+		if err != nil || rule == nil {
+			// events repeating every RecurringInterval seconds have no RRULE equivalent
+			log.Println("exporting recurring event", event.ID, "without a recurrence rule")
+		} else {
+			icalEvent.RRule = rule
+		}
+	}
+	return icalEvent
+}
+
+// writeCalendar renders events as an iCalendar feed.
+func writeCalendar(c *gin.Context, name string, events []models.Event) {
+	calendar := ical.Calendar{ProdID: calendarProdID, Name: name}
+	for _, event := range events {
+		calendar.Events = append(calendar.Events, eventToICalEvent(event))
+	}
+
+	c.Header("Content-Type", "text/calendar; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("inline; filename=%q", c.Param("file")))
+	c.Status(http.StatusOK)
+	if err := calendar.Encode(c.Writer); err != nil {
+		log.Println("error writing calendar:", err)
+	}
+}
+
+// calendarFileName returns the :file parameter without its .ics extension.
+func calendarFileName(c *gin.Context) (string, bool) {
+	name := c.Param("file")
+	if !strings.HasSuffix(name, ".ics") {
+		return "", false
+	}
+	return strings.TrimSuffix(name, ".ics"), true
+}
+
+// GET /api/calendar/:user_id.ics
+// Get an iCalendar feed of the events belonging to a user
+func GetUserCalendar(c *gin.Context) {
+	name, ok := calendarFileName(c)
+	id, err := strconv.Atoi(name)
+	if !ok || err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found."})
+		return
+	}
+	events, err := findEventsByUserID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No events found."})
+		return
+	}
+	writeCalendar(c, fmt.Sprintf("Scheduler events for user %d", id), events)
+}
+
+// GET /api/calendar/type/:type.ics
+// Get an iCalendar feed of the events of a type
+func GetTypeCalendar(c *gin.Context) {
+	eventType, ok := calendarFileName(c)
+	if !ok || eventType == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar not found."})
+		return
+	}
+	events, err := findEventsByType(eventType)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No events found."})
+		return
+	}
+	writeCalendar(c, "Scheduler "+eventType+" events", events)
+}
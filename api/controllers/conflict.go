@@ -0,0 +1,272 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+	"gorm.io/gorm"
+)
+
+// conflictHorizon bounds how far ahead the occurrences of a recurring event are checked for conflicts
+const conflictHorizon = 366 * 24 * time.Hour
+
+// Conflict is an existing event, or an occurrence of one, which an event conflicts with.
+type Conflict struct {
+	EventID   uint      `json:"event_id"`
+	Type      string    `json:"type"`
+	UserID    int       `json:"user_id"`
+	StartDate time.Time `json:"start_date"`
+	EndDate   time.Time `json:"end_date"`
+	// Rule is the rule which was broken, e.g. leave:DutyTech* or exclusive:DutyTech1
+	Rule string `json:"rule"`
+}
+
+// conflictError is returned when an event breaks the conflict rules.
+type conflictError struct {
+	conflicts []Conflict
+}
+
+func (err conflictError) Error() string {
+	return fmt.Sprintf("event conflicts with %d existing events", len(err.conflicts))
+}
+
+// respondConflict rejects a request whose event breaks the conflict rules with a 409 listing the
+// conflicting events, or with a 500 if the conflicts couldn't be checked.
+func respondConflict(c *gin.Context, err error) {
+	var conflictErr conflictError
+	if !errors.As(err, &conflictErr) {
+		log.Println("error checking for conflicts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check for conflicts"})
+		return
+	}
+	ids := make([]uint, 0, len(conflictErr.conflicts))
+	seen := make(map[uint]bool)
+	for _, conflict := range conflictErr.conflicts {
+		if !seen[conflict.EventID] {
+			seen[conflict.EventID] = true
+			ids = append(ids, conflict.EventID)
+		}
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":                 "Event conflicts with existing events",
+		"conflicting_event_ids": ids,
+		"conflicts":             conflictErr.conflicts,
+	})
+}
+
+// overrideConflicts reports whether the request asks to skip the conflict checks with override=true,
+// which only admins may do.
+func overrideConflicts(c *gin.Context) (bool, error) {
+	override, _ := strconv.ParseBool(c.Query("override"))
+	if override && !can(c, models.PermEventsAdmin) {
+		return false, fmt.Errorf("only admins may override conflicts")
+	}
+	return override, nil
+}
+
+// occurrenceSpan returns when an occurrence starts and ends. All-day occurrences without an EndDate
+// last the whole day, other occurrences without one are an instant.
+func occurrenceSpan(event APIEvent) (time.Time, time.Time) {
+	end := event.EndDate
+	if !end.After(event.StartDate) {
+		end = event.StartDate
+		if event.AllDay {
+			end = end.Add(24 * time.Hour)
+		}
+	}
+	return event.StartDate, end
+}
+
+// occurrencesOverlap reports whether two occurrences are on at the same time.
+// Instants are treated as lasting a nanosecond, so they overlap anything on at that time.
+func occurrencesOverlap(a APIEvent, b APIEvent) bool {
+	aStart, aEnd := occurrenceSpan(a)
+	bStart, bEnd := occurrenceSpan(b)
+	if aEnd.Equal(aStart) {
+		aEnd = aEnd.Add(time.Nanosecond)
+	}
+	if bEnd.Equal(bStart) {
+		bEnd = bEnd.Add(time.Nanosecond)
+	}
+	return aStart.Before(bEnd) && bStart.Before(aEnd)
+}
+
+// occurrenceDays returns the first and last days an occurrence falls on.
+func occurrenceDays(event APIEvent) (time.Time, time.Time) {
+	if event.StartDay != nil && event.EndDay != nil {
+		return event.StartDay.In(time.UTC), event.EndDay.In(time.UTC)
+	}
+	start, end := occurrenceSpan(event)
+	if end.After(start) {
+		end = end.Add(-time.Nanosecond)
+	}
+	// the days of timed occurrences are the dates in the event's zone
+	loc := locationOf(event.Timezone)
+	return models.DateOf(start.In(loc)).In(time.UTC), models.DateOf(end.In(loc)).In(time.UTC)
+}
+
+// shareDay reports whether two occurrences fall on any of the same days.
+func shareDay(a APIEvent, b APIEvent) bool {
+	aFirst, aLast := occurrenceDays(a)
+	bFirst, bLast := occurrenceDays(b)
+	return !aFirst.After(bLast) && !bFirst.After(aLast)
+}
+
+// conflictRule returns the rule broken by a user having events of two types at once, if any.
+func conflictRule(a string, b string) (string, bool) {
+	for _, rule := range config.Conflicts.Rules {
+		if rule.Matches(a, b) {
+			return rule.Type + ":" + rule.Blocks, true
+		}
+	}
+	return "", false
+}
+
+// lockConflicts takes the advisory locks which serialise the conflict checks of events that could conflict with
+// each other, until the end of the transaction: one on the user of an event whose type has conflict rules, and one
+// on each day an event of an exclusive type is on. The check which waits sees the events saved by the one before it.
+// Other databases than Postgres have no advisory locks, so aren't locked.
+func lockConflicts(tx *gorm.DB, event models.Event, occurrences []APIEvent, exclusive bool, hasRules bool) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	seen := make(map[string]bool)
+	keys := make([]string, 0)
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if hasRules {
+		add(fmt.Sprintf("conflicts:user:%d", event.UserID))
+	}
+	if exclusive {
+		for _, occurrence := range occurrences {
+			first, last := occurrenceDays(occurrence)
+			for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+				add("conflicts:" + event.Type + ":" + day.Format(time.DateOnly))
+			}
+		}
+	}
+	// taking the locks in order stops concurrent checks deadlocking
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", key).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findConflicts returns the existing events which the event conflicts with under the conflict rules:
+// events of the same user whose type is blocked by the event's type, or the reverse, and events of an
+// exclusive type on the same day. Types are exclusive if EXCLUSIVE_TYPES or their registered type says so. The occurrences of recurring events are compared up to a year ahead.
+// The event itself, its parent and its overrides are ignored, so that an update doesn't conflict with itself.
+// It must be called in the transaction saving the event, which holds the locks serialising the checks until it ends.
+func findConflicts(tx *gorm.DB, event models.Event) ([]Conflict, error) {
+	eventType, _ := findEventType(tx, event.Type)
+	exclusive := config.Conflicts.IsExclusive(event.Type) || eventType.ExclusivePerDay
+	hasRules := false
+	for _, rule := range config.Conflicts.Rules {
+		hasRules = hasRules || config.MatchType(rule.Type, event.Type) || config.MatchType(rule.Blocks, event.Type)
+	}
+	if !exclusive && !hasRules {
+		return nil, nil
+	}
+
+	windowStart := event.StartDate
+	windowEnd := windowStart.Add(eventDuration(event))
+	if isRecurring(event) {
+		windowEnd = windowStart.Add(conflictHorizon)
+	}
+	occurrences := expandEvents([]models.Event{event}, windowStart, windowEnd)
+	if len(occurrences) == 0 {
+		return nil, nil
+	}
+	// occurrences can't start before the event, but may start after the window when the horizon cuts them off
+	windowEnd = occurrences[len(occurrences)-1].StartDate.Add(eventDuration(event))
+	if err := lockConflicts(tx, event, occurrences, exclusive, hasRules); err != nil {
+		return nil, err
+	}
+
+	query := tx.Where("start_date <= ?", windowEnd.Add(24*time.Hour)).
+		Where("(start_date >= ? OR end_date >= ? OR "+recurringCondition+")", windowStart.Add(-24*time.Hour), windowStart)
+	if exclusive {
+		query = query.Where("(user_id = ? OR type = ?)", event.UserID, event.Type)
+	} else {
+		query = query.Where("user_id = ?", event.UserID)
+	}
+	if event.ID != 0 {
+		query = query.Where("id <> ? AND (parent_id IS NULL OR parent_id <> ?)", event.ID, event.ID)
+	}
+	if event.ParentID != nil {
+		query = query.Where("id <> ?", *event.ParentID)
+	}
+	var others []models.Event
+	if err := query.Find(&others).Error; err != nil {
+		return nil, err
+	}
+
+	// exclusive types conflict with anything on the same days, not just at the same time, which are the days
+	// in the event's zone
+	loc := eventLocation(event)
+	first, last := windowStart.In(loc), windowEnd.In(loc)
+	dayStart := time.Date(first.Year(), first.Month(), first.Day(), 0, 0, 0, 0, loc)
+	dayEnd := time.Date(last.Year(), last.Month(), last.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1).Add(-time.Nanosecond)
+	conflicts := make([]Conflict, 0)
+	for _, other := range expandEvents(others, dayStart, dayEnd) {
+		userRule, blocked := "", false
+		if other.UserID == event.UserID {
+			userRule, blocked = conflictRule(event.Type, other.Type)
+		}
+		sameExclusive := exclusive && other.Type == event.Type
+
+		for _, occurrence := range occurrences {
+			rule := ""
+			if blocked && occurrencesOverlap(occurrence, other) {
+				rule = userRule
+			} else if sameExclusive && shareDay(occurrence, other) {
+				rule = "exclusive:" + event.Type
+			} else {
+				continue
+			}
+			start, end := occurrenceSpan(other)
+			conflicts = append(conflicts, Conflict{
+				EventID:   uint(other.ID),
+				Type:      other.Type,
+				UserID:    other.UserID,
+				StartDate: start,
+				EndDate:   end,
+				Rule:      rule,
+			})
+			break
+		}
+	}
+	return conflicts, nil
+}
+
+// checkConflicts returns a conflictError if the event breaks the conflict rules, unless they're overridden.
+// Like findConflicts, it must be called in the transaction saving the event.
+func checkConflicts(tx *gorm.DB, event models.Event, override bool) error {
+	conflicts, err := findConflicts(tx, event)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+	if override {
+		log.Printf("event %d of type %s saved despite %d conflicts", event.ID, event.Type, len(conflicts))
+		return nil
+	}
+	return conflictError{conflicts: conflicts}
+}
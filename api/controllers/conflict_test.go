@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// setConflicts replaces the conflict rules for the rest of the test.
+func setConflicts(t *testing.T, conflicts config.ConflictConfig) {
+	previous := config.Conflicts
+	config.Conflicts = conflicts
+	t.Cleanup(func() { config.Conflicts = previous })
+}
+
+// conflictResponse is the body of a 409 response to an event breaking the conflict rules.
+type conflictResponse struct {
+	Error               string     `json:"error"`
+	ConflictingEventIDs []uint     `json:"conflicting_event_ids"`
+	Conflicts           []Conflict `json:"conflicts"`
+}
+
+func TestCreateEventConflicts(t *testing.T) {
+	setUpDB(t)
+	setConflicts(t, config.ConflictConfig{
+		Rules:          []config.ConflictRule{{Type: "leave", Blocks: "DutyTech*"}},
+		ExclusiveTypes: []string{"DutyTech1"},
+	})
+	for _, name := range []string{"leave", "DutyTech1", "DutyTech2"} {
+		createEventType(t, models.EventType{Name: name})
+	}
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	leave := createEvent(t, alice, "leave", start)
+	duty := createEvent(t, bob, "DutyTech1", start.Add(3*time.Hour))
+
+	// alice is on leave, and bob already has the day's DutyTech1
+	w := request(testRouter(alice, eventRoutes), "POST", "/api/events/", gin.H{"type": "DutyTech1", "start_date": start, "end_date": start.Add(time.Hour)})
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	var body conflictResponse
+	decode(t, w, &body)
+	assert.ElementsMatch(t, []uint{leave.ID, duty.ID}, body.ConflictingEventIDs)
+	rules := make(map[uint]string)
+	for _, conflict := range body.Conflicts {
+		rules[conflict.EventID] = conflict.Rule
+	}
+	assert.Equal(t, map[uint]string{leave.ID: "leave:DutyTech*", duty.ID: "exclusive:DutyTech1"}, rules)
+
+	var count int64
+	initializers.DB.Model(&models.Event{}).Count(&count)
+	assert.Equal(t, int64(2), count)
+
+	// another day is free
+	w = request(testRouter(alice, eventRoutes), "POST", "/api/events/", gin.H{"type": "DutyTech2", "start_date": start.AddDate(0, 0, 1)})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+}
+
+func TestUpdateEventConflicts(t *testing.T) {
+	setUpDB(t)
+	setConflicts(t, config.ConflictConfig{Rules: []config.ConflictRule{{Type: "leave", Blocks: "DutyTech*"}}})
+	for _, name := range []string{"leave", "DutyTech1"} {
+		createEventType(t, models.EventType{Name: name})
+	}
+	alice := createUser(t, "alice", models.RoleEditor)
+	admin := createUser(t, "admin", models.RoleAdmin)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	leave := createEvent(t, alice, "leave", start)
+	duty := createEvent(t, alice, "DutyTech1", start.AddDate(0, 0, 1))
+
+	// moving the duty onto the leave conflicts with it, but not with itself
+	patch := gin.H{"type": "DutyTech1", "start_date": start, "end_date": start.Add(time.Hour)}
+	w := request(testRouter(alice, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d", duty.ID), patch)
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	var body conflictResponse
+	decode(t, w, &body)
+	assert.Equal(t, []uint{leave.ID}, body.ConflictingEventIDs)
+
+	// only admins may override the rules
+	w = request(testRouter(alice, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d?override=true", duty.ID), patch)
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	w = request(testRouter(admin, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d?override=true", duty.ID), patch)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestImportEventsSkipsConflicts(t *testing.T) {
+	setUpDB(t)
+	setConflicts(t, config.ConflictConfig{Rules: []config.ConflictRule{{Type: "leave", Blocks: "DutyTech*"}}})
+	for _, name := range []string{"leave", "DutyTech1"} {
+		createEventType(t, models.EventType{Name: name})
+	}
+	alice := createUser(t, "alice", models.RoleEditor)
+	leave := createEvent(t, alice, "leave", time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC))
+
+	calendar := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:on-leave\r\nDTSTART:20300603T093000Z\r\nDTEND:20300603T103000Z\r\nSUMMARY:Duty\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:free\r\nDTSTART:20300604T100000Z\r\nDTEND:20300604T110000Z\r\nSUMMARY:Duty\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	routes := func(r *gin.Engine) { r.POST("/api/events/import", ImportEvents) }
+	w := request(testRouter(alice, routes), "POST", "/api/events/import?type=DutyTech1", calendar)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var report ImportReport
+	decode(t, w, &report)
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Skipped)
+	require.Len(t, report.Events, 2)
+	assert.Equal(t, ImportSkipped, report.Events[0].Status)
+	require.Len(t, report.Events[0].Conflicts, 1)
+	assert.Equal(t, leave.ID, report.Events[0].Conflicts[0].EventID)
+	assert.Equal(t, ImportCreated, report.Events[1].Status)
+	assert.Empty(t, report.Events[1].Conflicts)
+}
+
+func TestSwapConflicts(t *testing.T) {
+	setUpDB(t)
+	setConflicts(t, config.ConflictConfig{Rules: []config.ConflictRule{{Type: "leave", Blocks: "DutyTech*"}, {Type: "DutyTech*", Blocks: "DutyTech*"}}})
+	for _, name := range []string{"leave", "DutyTech1", "DutyTech2"} {
+		createEventType(t, models.EventType{Name: name})
+	}
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	start := time.Now().Add(48 * time.Hour).Truncate(time.Hour)
+	duty := createEvent(t, alice, "DutyTech1", start)
+	leave := createEvent(t, bob, "leave", start)
+	// exchanging duties at the same time is fine, as neither keeps their own
+	other := createEvent(t, bob, "DutyTech2", start)
+
+	w := request(testRouter(alice, swapRoutes), "POST", "/api/swaps/", gin.H{"event_id": duty.ID, "recipient_event_id": other.ID})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var swap models.SwapRequest
+	decode(t, w, &swap)
+	accept := fmt.Sprintf("/api/swaps/%d/accept", swap.ID)
+
+	// bob is on leave when alice's duty is
+	w = request(testRouter(bob, swapRoutes), "POST", accept, nil)
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	var body conflictResponse
+	decode(t, w, &body)
+	assert.Equal(t, []uint{leave.ID}, body.ConflictingEventIDs)
+	var unchanged models.Event
+	require.NoError(t, initializers.DB.First(&unchanged, duty.ID).Error)
+	assert.Equal(t, int(alice.ID), unchanged.UserID)
+
+	require.NoError(t, initializers.DB.Delete(&models.Event{}, leave.ID).Error)
+	w = request(testRouter(bob, swapRoutes), "POST", accept, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+func TestExclusiveDaysInEventZone(t *testing.T) {
+	setUpDB(t)
+	setConflicts(t, config.ConflictConfig{ExclusiveTypes: []string{"DutyTech1"}})
+	createEventType(t, models.EventType{Name: "DutyTech1"})
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	// the weekly event's second occurrence and the other event are on the 3rd in Auckland, at 01:00 and 22:00,
+	// but on the 2nd and 3rd in UTC
+	early := createEvent(t, alice, "DutyTech1", time.Date(2030, 5, 26, 13, 0, 0, 0, time.UTC))
+	late := createEvent(t, bob, "DutyTech1", time.Date(2030, 6, 3, 10, 0, 0, 0, time.UTC))
+	require.NoError(t, initializers.DB.Model(&models.Event{}).Where("id IN ?", []uint{early.ID, late.ID}).
+		Update("timezone", "Pacific/Auckland").Error)
+	require.NoError(t, initializers.DB.Model(&early).Update("rrule", "FREQ=WEEKLY;COUNT=3").Error)
+
+	// the stored start is read back in UTC, which mustn't decide the day
+	w := request(testRouter(bob, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d", late.ID), gin.H{"title": "Late"})
+	require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	var body conflictResponse
+	decode(t, w, &body)
+	assert.Equal(t, []uint{early.ID}, body.ConflictingEventIDs)
+}
+
+func TestLockConflicts(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	require.NoError(t, err)
+	type statement struct {
+		SQL  string
+		Vars []interface{}
+	}
+	var statements []statement
+	require.NoError(t, db.Callback().Raw().After("gorm:raw").Register("test:record", func(tx *gorm.DB) {
+		statements = append(statements, statement{tx.Statement.SQL.String(), tx.Statement.Vars})
+	}))
+
+	event := models.Event{Type: "DutyTech1", UserID: 7}
+	start := time.Date(2030, 6, 3, 22, 0, 0, 0, time.UTC)
+	occurrences := []APIEvent{{StartDate: start, EndDate: start.Add(4 * time.Hour)}}
+	require.NoError(t, lockConflicts(db, event, occurrences, true, true))
+
+	// each key is locked on its own, in order
+	lock := "SELECT pg_advisory_xact_lock(hashtext($1))"
+	assert.Equal(t, []statement{
+		{lock, []interface{}{"conflicts:DutyTech1:2030-06-03"}},
+		{lock, []interface{}{"conflicts:DutyTech1:2030-06-04"}},
+		{lock, []interface{}{"conflicts:user:7"}},
+	}, statements)
+}
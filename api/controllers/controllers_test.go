@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/middleware"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// setUpDB points initializers.DB at an empty in-memory database for the rest of the test.
+func setUpDB(t *testing.T) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger:  logger.Default.LogMode(logger.Silent),
+		NowFunc: func() time.Time { return time.Now().Local().Truncate(time.Microsecond) },
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	// a single connection keeps the database alive, and serialises transactions as Postgres' locks would
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(
+		models.Event{},
+		models.EventMeta{},
+		models.User{},
+		models.Session{},
+		models.RefreshToken{},
+		models.RevokedToken{},
+		models.APIToken{},
+		models.SwapRequest{},
+		models.EventType{},
+		models.AuditLog{},
+		models.EventVersion{}))
+
+	// sqlite only reads times back from columns declared as datetime, date or timestamp
+	var schema []string
+	require.NoError(t, db.Raw("SELECT sql FROM sqlite_master WHERE tbl_name = 'events' AND sql IS NOT NULL ORDER BY type DESC").Scan(&schema).Error)
+	require.NoError(t, db.Exec("DROP TABLE events").Error)
+	for _, statement := range schema {
+		require.NoError(t, db.Exec(strings.ReplaceAll(statement, "TIMESTAMPTZ", "datetime")).Error)
+	}
+
+	previous := initializers.DB
+	initializers.DB = db
+	t.Cleanup(func() {
+		initializers.DB = previous
+		sqlDB.Close()
+	})
+}
+
+// createUser saves a user with role.
+func createUser(t *testing.T, username string, role string) models.User {
+	t.Helper()
+	user := models.User{Username: username, Role: role, Provider: "local", Subject: username}
+	require.NoError(t, initializers.DB.Create(&user).Error)
+	return user
+}
+
+// createEventType saves an event type.
+func createEventType(t *testing.T, eventType models.EventType) models.EventType {
+	t.Helper()
+	require.NoError(t, initializers.DB.Create(&eventType).Error)
+	return eventType
+}
+
+// createEvent saves an event of type belonging to user, starting at start and lasting an hour.
+func createEvent(t *testing.T, user models.User, eventType string, start time.Time) models.Event {
+	t.Helper()
+	event := models.Event{Type: eventType, StartDate: start, EndDate: start.Add(time.Hour), UserID: int(user.ID)}
+	require.NoError(t, initializers.DB.Create(&event).Error)
+	// false is left out of the insert, so the column's default of true would apply
+	require.NoError(t, initializers.DB.Model(&event).Update("all_day", false).Error)
+	return event
+}
+
+// testRouter returns a router whose requests are authenticated as user, and which calls register to add routes.
+func testRouter(user models.User, register func(r *gin.Engine)) *gin.Engine {
+	r := gin.New()
+	r.Use(middleware.RequestID, func(c *gin.Context) {
+		c.Set("user", user)
+		c.Next()
+	})
+	register(r)
+	return r
+}
+
+// eventRoutes registers the event routes.
+func eventRoutes(r *gin.Engine) {
+	r.GET("/api/events/", GetEvent)
+	r.POST("/api/events/", CreateEvent)
+	r.PATCH("/api/events/:id", UpdateEvent)
+	r.DELETE("/api/events/:id", DeleteEvent)
+	r.GET("/api/events/trash", GetTrash)
+	r.POST("/api/events/:id/restore", RestoreEvent)
+}
+
+// request serves a request with a body, which is sent as it is if it's a string, left out if it's nil and sent
+// as JSON otherwise, and the given headers as name, value pairs.
+func request(r *gin.Engine, method string, target string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	var data []byte
+	switch body := body.(type) {
+	case nil:
+	case string:
+		data = []byte(body)
+	default:
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, target, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// decode unmarshals a response's body into v.
+func decode(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+}
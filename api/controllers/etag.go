@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errPreconditionFailed is returned when an event has changed since the version named by the If-Match header
+var errPreconditionFailed = errors.New("the event has been changed since it was read, fetch it again and retry")
+
+// eventETag returns the entity tag of the current state of an event, which changes whenever the event is saved.
+func eventETag(event models.Event) string {
+	return fmt.Sprintf(`"%d-%d"`, event.ID, event.UpdatedAt.UnixMicro())
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists etag, or is *.
+// If-Match compares strongly, so weak tags never match it, while If-None-Match ignores the W/ prefix.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// lockIfMatch honours the If-Match header of a request changing an event. When it's set, the event is locked for the
+// rest of the transaction and errPreconditionFailed is returned unless it's still at the version the header names,
+// and unchanged since it was loaded as event.
+func lockIfMatch(c *gin.Context, tx *gorm.DB, event models.Event) error {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return nil
+	}
+	var current models.Event
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", event.ID).First(&current).Error; err != nil {
+		return err
+	}
+	if !etagMatches(header, eventETag(current), false) || eventETag(current) != eventETag(event) {
+		return errPreconditionFailed
+	}
+	return nil
+}
+
+// respondPreconditionFailed writes the 412 response of a change whose If-Match header no longer matches.
+func respondPreconditionFailed(c *gin.Context) {
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": errPreconditionFailed.Error()})
+}
+
+// respondEvent writes a response of a single event, with its entity tag in the ETag header.
+func respondEvent(c *gin.Context, status int, event APIEvent) {
+	c.Header("ETag", event.ETag)
+	c.JSON(status, event)
+}
+
+// respondCachedEvent writes the response to a GET of a single event, with its entity tag in the ETag header, so that
+// it can be sent back in If-Match to change the event, or a 304 status code without the body when the request's
+// If-None-Match header shows the client already has this version of it.
+func respondCachedEvent(c *gin.Context, event APIEvent) {
+	c.Header("ETag", event.ETag)
+	if etagMatches(c.GetHeader("If-None-Match"), event.ETag, true) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, event)
+}
+
+// respondCached writes a JSON response to a GET with an entity tag of its body in the ETag header, or a 304 status
+// code without the body when the request's If-None-Match header shows the client already has it.
+func respondCached(c *gin.Context, body interface{}) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode response"})
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+	if etagMatches(c.GetHeader("If-None-Match"), etag, true) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, gin.MIMEJSON+"; charset=utf-8", data)
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventETagRoundTrip(t *testing.T) {
+	setUpDB(t)
+	createEventType(t, models.EventType{Name: "DutyTech1"})
+	alice := createUser(t, "alice", models.RoleEditor)
+	event := createEvent(t, alice, "DutyTech1", time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC))
+	router := testRouter(alice, eventRoutes)
+	get := fmt.Sprintf("/api/events/?id=%d", event.ID)
+	patch := fmt.Sprintf("/api/events/%d", event.ID)
+
+	// the ETag of a read is the event's etag
+	w := request(router, "GET", get, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	read := w.Header().Get("ETag")
+	var body APIEvent
+	decode(t, w, &body)
+	assert.Equal(t, body.ETag, read)
+	w = request(router, "GET", get, nil, "If-None-Match", "W/"+read)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	// so it changes the event until somebody else does
+	w = request(router, "PATCH", patch, gin.H{"title": "First"}, "If-Match", read)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	updated := w.Header().Get("ETag")
+	assert.NotEqual(t, read, updated)
+	w = request(router, "PATCH", patch, gin.H{"title": "Second"}, "If-Match", read)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code, w.Body.String())
+
+	w = request(router, "GET", get, nil, "If-None-Match", read)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, updated, w.Header().Get("ETag"))
+	decode(t, w, &body)
+	assert.Equal(t, "First", body.Title)
+	w = request(router, "GET", get, nil, "If-None-Match", updated)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
@@ -8,8 +8,10 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
 	"github.com/glssn/scheduler-api/api/models"
 	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
 )
 
 type NewEventInput struct {
@@ -20,6 +22,10 @@ type NewEventInput struct {
 	AllDay            bool      `json:"all_day"`
 	RecurringType     string    `json:"recurring_type"`
 	RecurringInterval uint32    `json:"recurring_interval"`
+	RRule             string    `json:"rrule"`
+	Region            string    `json:"region"`
+	// Timezone is the IANA zone of the event, defaulting to the caller's timezone
+	Timezone string `json:"timezone"`
 }
 
 type PatchEventInput struct {
@@ -30,6 +36,7 @@ type PatchEventInput struct {
 	AllDay            bool      `json:"all_day"`
 	RecurringType     string    `json:"recurring_type"`
 	RecurringInterval uint32    `json:"recurring_interval"`
+	RRule             string    `json:"rrule"`
 }
 
 type APIEvent struct {
@@ -41,8 +48,22 @@ type APIEvent struct {
 	AllDay            bool      `json:"all_day"`
 	RecurringType     string    `json:"recurring_type"`
 	RecurringInterval uint32    `json:"recurring_interval"`
+	RRule             string    `gorm:"column:rrule" json:"rrule"`
+	Region            string    `json:"region"`
+	Notes             string    `json:"notes,omitempty"`
+	Bunting           bool      `json:"bunting,omitempty"`
+	Timezone          string    `json:"timezone"`
+	// StartDay and EndDay are the first and last dates of an all-day event, whatever zone it's rendered in
+	StartDay *models.Date `json:"start_day,omitempty"`
+	EndDay   *models.Date `json:"end_day,omitempty"`
 	// User              APIUser   `json:"user"`
 	UserID int `json:"user_id"`
+	// ParentID is set on occurrences expanded from a recurring event, and on override rows replacing one of them
+	ParentID       uint       `gorm:"-" json:"parent_id,omitempty"`
+	OccurrenceDate *time.Time `gorm:"-" json:"occurrence_date,omitempty"`
+	RecurrenceID   *time.Time `gorm:"-" json:"recurrence_id,omitempty"`
+	// ETag identifies the version of the event, and is sent in If-Match to change it only if it's unchanged
+	ETag string `gorm:"-" json:"etag"`
 }
 
 // eventToAPIEvent converts a Event struct to an APIEvent struct.
@@ -65,6 +86,7 @@ func eventToAPIEvent(event models.Event) (APIEvent, error) {
 		log.Println(err)
 		return apiEvent, errors.New("Could not unmarshal into APIEvent")
 	}
+	apiEvent.ETag = eventETag(event)
 	return apiEvent, nil
 }
 
@@ -90,9 +112,18 @@ func eventsToAPIEvents(events []models.Event) []APIEvent {
 // If the string can be parsed successfully, the corresponding time.Time value is returned.
 // If the string cannot be parsed, an error is returned.
 func ParseTime(input string) (time.Time, error) {
-	var formats = []string{"2006-01-02", "2006-01-02T15:04:05", "2006-01-02T15:04:05.000Z"}
+	return ParseTimeIn(input, time.UTC)
+}
+
+// ParseTimeIn parses a date and time like ParseTime. Times with an offset, e.g. 2024-06-01T09:00:00+01:00,
+// keep it, while dates and times without one are wall-clock times in loc.
+func ParseTimeIn(input string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, input); err == nil {
+		return t, nil
+	}
+	var formats = []string{"2006-01-02", "2006-01-02T15:04:05"}
 	for _, format := range formats {
-		t, err := time.Parse(format, input)
+		t, err := time.ParseInLocation(format, input, loc)
 		if err == nil {
 			return t, nil
 		}
@@ -103,8 +134,13 @@ func ParseTime(input string) (time.Time, error) {
 // ConvertDateRange takes two date strings and returns the corresponding time.Time values.
 // If either of the date strings cannot be parsed, an error is returned.
 func ConvertDateRange(min string, max string) (time.Time, time.Time, error) {
-	parsedMin, errMin := ParseTime(min)
-	parsedMax, errMax := ParseTime(max)
+	return ConvertDateRangeIn(min, max, time.UTC)
+}
+
+// ConvertDateRangeIn converts two date strings like ConvertDateRange, parsing them in loc.
+func ConvertDateRangeIn(min string, max string, loc *time.Location) (time.Time, time.Time, error) {
+	parsedMin, errMin := ParseTimeIn(min, loc)
+	parsedMax, errMax := ParseTimeIn(max, loc)
 	if errMin == nil && errMax == nil {
 		return parsedMin, parsedMax, nil
 	}
@@ -132,193 +168,18 @@ func ParseRecurringInterval(interval string) (uint32, error) {
 
 // GET /events/all
 // Get all events
+// Returns a 304 status code when the ETag of the events is sent in If-None-Match and they haven't changed
 func FindEvents(c *gin.Context) {
-	var events []APIEvent
-	initializers.DB.Model(&models.Event{}).Find(&events)
-	c.JSON(http.StatusOK, events)
-}
-
-type EventQuery struct {
-	ID        float64   `form:"id"`
-	Type      string    `form:"type"`
-	Date      time.Time `form:"date"`
-	StartDate time.Time `form:"start_date"`
-	EndDate   time.Time `form:"end_date"`
-	UserID    int       `form:"user_id"`
-}
-
-// GET /events
-// Get events based on the specified parameters
-// If the query string is empty, fetch all events
-// If the query string contains an "id" parameter, fetch the event with the specified id
-// If the query string contains "start_date" and "end_date" parameters, fetch the events where the start_date and end_dates are within the specified range,
-// or where the start_date is between the specified startDate and endDate and the all_day field is true
-func GetEvent(c *gin.Context) {
-	// Get the query parameters
-	// params := c.Request.URL.Query()
-
-	var eventQuery EventQuery
-	if err := c.ShouldBind(&eventQuery); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required parameters for eventQuery"})
-		return
-	}
-	log.Println("====== Only Bind By Query String ======")
-	log.Println("id: ", eventQuery.ID)
-	log.Println("type: ", eventQuery.Type)
-	log.Println("date: ", eventQuery.Date)
-	log.Println("time is zero?: ", eventQuery.Date.IsZero())
-	log.Println("start_date: ", eventQuery.StartDate)
-	log.Println("end_date: ", eventQuery.EndDate)
-	log.Println("user_id: ", eventQuery.UserID)
-
-	// If event ID is provided, retrieve it
-	if eventQuery.ID != float64(0) {
-		GetEventById(&eventQuery.ID, c)
-		return
-	}
-	// If type is provided, but no User ID
-	if eventQuery.Type != "" && eventQuery.UserID == 0 {
-		// if no date or date range is provided
-		if eventQuery.Date.IsZero() && eventQuery.StartDate.IsZero() && eventQuery.StartDate.IsZero() {
-			GetEventByType(&eventQuery.Type, c)
-			return
-		}
-		// if date is provided
-		if !eventQuery.Date.IsZero() {
-			GetEventByTypeAndDate(&eventQuery.Type, &eventQuery.Date, c)
-			return
-		}
-		// if date range is provided
-		if !eventQuery.StartDate.IsZero() && !eventQuery.StartDate.IsZero() {
-			GetEventByTypeAndDateRange(&eventQuery.Type, &eventQuery.StartDate, &eventQuery.EndDate, c)
-			return
-		}
-	}
-	// If User ID is provided
-	if eventQuery.UserID != 0 {
-		// if no type or date or date range is provided
-		if eventQuery.Type == "" && eventQuery.Date.IsZero() && eventQuery.StartDate.IsZero() && eventQuery.StartDate.IsZero() {
-			GetEventByUserID(&eventQuery.UserID, c)
-			return
-		}
-		// if type is provided, but date and date range is not provided
-		if eventQuery.Type != "" && eventQuery.Date.IsZero() && eventQuery.StartDate.IsZero() && eventQuery.StartDate.IsZero() {
-			GetEventByUserIdAndType(&eventQuery.UserID, &eventQuery.Type, c)
-			return
-		}
-		// if type and date is provided but not date range
-		if eventQuery.Type != "" && !eventQuery.Date.IsZero() && eventQuery.StartDate.IsZero() && eventQuery.StartDate.IsZero() {
-			GetEventByUserIdAndTypeAndDate(&eventQuery.UserID, &eventQuery.Type, &eventQuery.Date, c)
-			return
-		}
-		// if type and date range is provided
-		if eventQuery.Type != "" && !eventQuery.StartDate.IsZero() && !eventQuery.StartDate.IsZero() {
-			GetEventByUserIdAndTypeAndDateRange(&eventQuery.UserID, &eventQuery.Type, &eventQuery.StartDate, &eventQuery.EndDate, c)
-			return
-		}
-	}
-	// If date is provided
-	if !eventQuery.Date.IsZero() {
-		GetEventByDate(&eventQuery.Date, c)
-		return
-	}
-	// If date range is provided
-	if !eventQuery.StartDate.IsZero() && !eventQuery.StartDate.IsZero() {
-		GetEventByDateRange(&eventQuery.StartDate, &eventQuery.EndDate, c)
-		return
-	}
-	FindEvents(c)
-	c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request."})
-}
-
-// GetEventById returns the event from the database where the ID is
-func GetEventById(id *float64, c *gin.Context) {
-	var event models.Event
-	if err := initializers.DB.Where("id = ?", *id).First(&event).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found."})
-		return
-	}
-	// convert the event to apiEvent
-	var apiEvent APIEvent
-	apiEvent, err := eventToAPIEvent(event)
-	if err != nil {
-		log.Println("error converting event to APIEvent:", err)
-	}
-
-	// Return the event
-	c.JSON(http.StatusOK, apiEvent)
-	return
-}
-
-func GetEventByType(t *string, c *gin.Context) {
-	var events []models.Event
-	if err := initializers.DB.Where("type = ?", &t).Find(&events).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No events found."})
-		return
-	}
-	// convert the event to apiEvent
-	var apiEvents []APIEvent
-	apiEvents = eventsToAPIEvents(events)
-
-	// Return the event
-	c.JSON(http.StatusOK, apiEvents)
-}
-
-func GetEventByTypeAndDate(t *string, date *time.Time, c *gin.Context) {
-	var events []models.Event
-	if err := initializers.DB.Where("type = ? & start_date = ?", &t, &date).Find(&events).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No events found."})
-		return
-	}
-	// convert the event to apiEvent
-	var apiEvents []APIEvent
-	apiEvents = eventsToAPIEvents(events)
-
-	// Return the event
-	c.JSON(http.StatusOK, apiEvents)
-}
-
-func GetEventByTypeAndDateRange(t *string, startDate *time.Time, endDate *time.Time, c *gin.Context) {
-	var events []models.Event
-	if err := initializers.DB.Where("(type = ? AND start_date >= ? AND end_date <= ?) OR (type = ? AND start_date BETWEEN ? AND ? AND all_day = true)", &t, &startDate, &endDate, &t, &startDate, &endDate).Find(&events).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No events found."})
-		return
-	}
-	// convert the event to apiEvent
-	var apiEvents []APIEvent
-	apiEvents = eventsToAPIEvents(events)
-
-	// Return the event
-	c.JSON(http.StatusOK, apiEvents)
-}
-
-func GetEventByDate(date *time.Time, c *gin.Context) {
 	var events []models.Event
-	if err := initializers.DB.Where("start_date = ?", &date).Find(&events).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No events found."})
-		return
-	}
-	// convert the event to apiEvent
-	var apiEvents []APIEvent
-	apiEvents = eventsToAPIEvents(events)
-
-	// Return the event
-	c.JSON(http.StatusOK, apiEvents)
+	initializers.DB.Find(&events)
+	respondCached(c, eventsToAPIEvents(events))
 }
 
-func GetEventByDateRange(startDate *time.Time, endDate *time.Time, c *gin.Context) {
+// findEventsByType returns the events of the specified type.
+func findEventsByType(t string) ([]models.Event, error) {
 	var events []models.Event
-	if err := initializers.DB.Where("(start_date >= ? AND end_date <= ?) OR (start_date BETWEEN ? AND ? AND all_day = true)", &startDate, &endDate, &startDate, &endDate).Find(&events).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No events found."})
-		return
-	}
-	// convert the event to apiEvent
-	var apiEvents []APIEvent
-	apiEvents = eventsToAPIEvents(events)
-
-	// Return the event
-	c.JSON(http.StatusOK, apiEvents)
+	err := initializers.DB.Where("type = ?", t).Find(&events).Error
+	return events, err
 }
 
 // POST /events
@@ -326,7 +187,12 @@ func GetEventByDateRange(startDate *time.Time, endDate *time.Time, c *gin.Contex
 // The request must include a valid JSON object with the event details
 // If the input is invalid, return a 400 status code
 // If the user is not authenticated, return a 401 status code
-// Otherwise, return the created event object and a 200 status code
+// Events are always owned by the user creating them
+// The event must satisfy the rules of its registered type, otherwise return a 400 status code,
+// or a 403 status code if the caller's role may not create events of the type
+// If the event breaks the conflict rules, return a 409 status code listing the conflicting events,
+// unless an admin overrides them with override=true
+// Otherwise, return the created event object, with its etag in the ETag header, and a 200 status code
 func CreateEvent(c *gin.Context) {
 	// Validate input
 	var input NewEventInput
@@ -341,32 +207,97 @@ func CreateEvent(c *gin.Context) {
 		return
 	}
 	user := userFromCookie.(models.User)
+	override, err := overrideConflicts(c)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the recurrence rule
+	rrule, err := validateRecurrence(input.RRule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rrule: " + err.Error()})
+		return
+	}
+	if input.Region != "" && !models.IsValidRegion(input.Region) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown region."})
+		return
+	}
+	if input.Timezone == "" {
+		input.Timezone = timezoneFor(user)
+	}
+	if !validTimezone(input.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
 
 	// Create event
 	event := models.Event{
 		Type:              input.Type,
+		Title:             input.Title,
 		StartDate:         input.StartDate,
 		EndDate:           input.EndDate,
 		AllDay:            input.AllDay,
 		RecurringType:     input.RecurringType,
 		RecurringInterval: input.RecurringInterval,
+		RRule:             rrule,
+		Region:            input.Region,
+		Timezone:          input.Timezone,
 		User:              user,
+		UserID:            int(user.ID),
+	}
+	setEventDays(&event, input.StartDate, input.EndDate)
+	if status, err := validateEventType(c, event, true); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := checkConflicts(tx, event, override); err != nil {
+			return err
+		}
+		if err := tx.Save(&event).Error; err != nil {
+			return err
+		}
+		return recordEventChange(c, tx, audit.ActionCreate, nil, &event)
+	})
+	if errors.As(err, &conflictError{}) {
+		respondConflict(c, err)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event"})
+		return
+	}
+	if err := saveEventMeta(initializers.DB, event); err != nil {
+		log.Println("error saving event meta:", err)
 	}
-	initializers.DB.Save(&event)
 	apiEvent, err := eventToAPIEvent(event)
 	if err != nil {
 		log.Println("error converting event to APIEvent:", err)
 	}
-	c.JSON(http.StatusCreated, apiEvent)
+	respondEvent(c, http.StatusCreated, localiseEvent(apiEvent, loc))
 }
 
 // PATCH /events/:id
 // Update an existing event with the specified id
 // The request must include a valid JSON object with the updated event details
+// For recurring events the "scope" query parameter selects the whole "series" (default), a single
+// "occurrence" or the occurrence and all "following" ones, identified by the "occurrence_date" parameter
 // If the id is not provided, return a 400 status code
 // If the event with the specified id does not exist, return a 404 status code
 // If the input is invalid, return a 400 status code
-// Otherwise, return the updated event object and a 200 status code
+// If the event belongs to somebody else and the caller isn't an admin, return a 403 status code
+// The updated event must satisfy the rules of its registered type, as with POST
+// If the updated event breaks the conflict rules, return a 409 status code listing the conflicting events,
+// unless an admin overrides them with override=true
+// If the If-Match header is set and doesn't match the event's etag, because it has been changed since it was read,
+// return a 412 status code
+// Otherwise, return the updated event object, with its new etag in the ETag header, and a 200 status code
 func UpdateEvent(c *gin.Context) {
 	// Get the id parameter from the request
 	id := c.Param("id")
@@ -383,28 +314,115 @@ func UpdateEvent(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found."})
 		return
 	}
+	// Only the owner or an admin may change the event
+	if !canModifyEvent(c, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You may only modify your own events."})
+		return
+	}
 	// Validate input
 	var apiEvent APIEvent
 	if err := c.ShouldBindJSON(&apiEvent); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if apiEvent.UserID != 0 && apiEvent.UserID != event.UserID && !can(c, models.PermEventsAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins may reassign events."})
+		return
+	}
+	override, err := overrideConflicts(c)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the recurrence rule
+	rrule, err := validateRecurrence(apiEvent.RRule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rrule: " + err.Error()})
+		return
+	}
+	apiEvent.RRule = rrule
+	if apiEvent.Region != "" && !models.IsValidRegion(apiEvent.Region) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown region."})
+		return
+	}
+	if apiEvent.Timezone != "" && !validTimezone(apiEvent.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+
+	// Apply changes to part of a recurring event
+	scope, err := requestScope(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if scope != ScopeSeries {
+		occStart, err := findOccurrence(event, c.Query("occurrence_date"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if scope == ScopeOccurrence || !isFirstOccurrence(event, occStart) {
+			updateOccurrence(c, event, apiEvent, scope, occStart, override, loc)
+			return
+		}
+	}
+
+	updated := event
+	applyPatch(&updated, apiEvent)
+	if status, err := validateEventType(c, updated, updated.Type != event.Type); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
 
 	// Update the event in the database
-	if err := initializers.DB.Model(&event).Where("id = ?", id).Updates(&apiEvent).Error; err != nil {
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockIfMatch(c, tx, event); err != nil {
+			return err
+		}
+		if err := checkConflicts(tx, updated, override); err != nil {
+			return err
+		}
+		if err := tx.Save(&updated).Error; err != nil {
+			return err
+		}
+		return recordEventChange(c, tx, audit.ActionUpdate, &event, &updated)
+	})
+	if errors.As(err, &conflictError{}) {
+		respondConflict(c, err)
+		return
+	}
+	if errors.Is(err, errPreconditionFailed) {
+		respondPreconditionFailed(c)
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	apiEvent, err := eventToAPIEvent(event)
+	initializers.DB.Where("id = ?", id).First(&event)
+	if err := saveEventMeta(initializers.DB, event); err != nil {
+		log.Println("error saving event meta:", err)
+	}
+	apiEvent, err = eventToAPIEvent(event)
 	if err != nil {
 		log.Println("error converting event to APIEvent:", err)
 	}
 
-	c.JSON(http.StatusOK, &apiEvent)
+	respondEvent(c, http.StatusOK, localiseEvent(apiEvent, loc))
 }
 
 // DELETE /events/:id
 // Delete a event
+// For recurring events the "scope" and "occurrence_date" query parameters cancel a single occurrence
+// or the occurrence and all following ones, as with PATCH
+// If the If-Match header is set and doesn't match the event's etag, return a 412 status code
 func DeleteEvent(c *gin.Context) {
 	// Get the id parameter from the request
 	id := c.Param("id")
@@ -421,8 +439,57 @@ func DeleteEvent(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Event not found."})
 		return
 	}
+	// Only the owner or an admin may delete the event
+	if !canModifyEvent(c, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You may only delete your own events."})
+		return
+	}
+
+	// Cancel part of a recurring event
+	scope, err := requestScope(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if scope != ScopeSeries {
+		occStart, err := findOccurrence(event, c.Query("occurrence_date"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if scope == ScopeOccurrence || !isFirstOccurrence(event, occStart) {
+			deleteOccurrence(c, event, scope, occStart)
+			return
+		}
+	}
 
-	initializers.DB.Delete(&event)
+	// Delete the event along with any overrides of its occurrences
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockIfMatch(c, tx, event); err != nil {
+			return err
+		}
+		var overrides []models.Event
+		if err := tx.Where("parent_id = ?", event.ID).Find(&overrides).Error; err != nil {
+			return err
+		}
+		for _, deleted := range append(overrides, event) {
+			if err := tx.Delete(&deleted).Error; err != nil {
+				return err
+			}
+			if err := recordEventChange(c, tx, audit.ActionDelete, &deleted, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errPreconditionFailed) {
+		respondPreconditionFailed(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"data": true})
 }
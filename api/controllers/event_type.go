@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+)
+
+// colourPattern matches the hex colours event types are displayed with
+var colourPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// APIEventType is an event type as returned by the API.
+type APIEventType struct {
+	ID               uint     `json:"id"`
+	Name             string   `json:"name"`
+	Label            string   `json:"label"`
+	Colour           string   `json:"colour"`
+	AllDayOnly       bool     `json:"all_day_only"`
+	AllowedRoles     []string `json:"allowed_roles"`
+	MaxDurationHours int      `json:"max_duration_hours"`
+	ExclusivePerDay  bool     `json:"exclusive_per_day"`
+}
+
+// eventTypeToAPIEventType converts an EventType model to the APIEventType returned by the API.
+func eventTypeToAPIEventType(eventType models.EventType) APIEventType {
+	return APIEventType{
+		ID:               eventType.ID,
+		Name:             eventType.Name,
+		Label:            eventType.Label,
+		Colour:           eventType.Colour,
+		AllDayOnly:       eventType.AllDayOnly,
+		AllowedRoles:     append([]string{}, eventType.RoleList()...),
+		MaxDurationHours: eventType.MaxDurationHours,
+		ExclusivePerDay:  eventType.ExclusivePerDay,
+	}
+}
+
+type NewEventTypeInput struct {
+	Name             string   `json:"name" binding:"required"`
+	Label            string   `json:"label"`
+	Colour           string   `json:"colour"`
+	AllDayOnly       bool     `json:"all_day_only"`
+	AllowedRoles     []string `json:"allowed_roles"`
+	MaxDurationHours int      `json:"max_duration_hours"`
+	ExclusivePerDay  bool     `json:"exclusive_per_day"`
+}
+
+// PatchEventTypeInput changes the fields which are set. An event type's name can't be changed.
+type PatchEventTypeInput struct {
+	Label            *string   `json:"label"`
+	Colour           *string   `json:"colour"`
+	AllDayOnly       *bool     `json:"all_day_only"`
+	AllowedRoles     *[]string `json:"allowed_roles"`
+	MaxDurationHours *int      `json:"max_duration_hours"`
+	ExclusivePerDay  *bool     `json:"exclusive_per_day"`
+}
+
+// findEventType returns the registered event type with a name.
+func findEventType(tx *gorm.DB, name string) (models.EventType, bool) {
+	var eventType models.EventType
+	tx.Where("name = ?", name).Limit(1).Find(&eventType)
+	return eventType, eventType.ID != 0
+}
+
+// callerRole returns the role of the caller, from their user or the allowed token they authenticated with.
+func callerRole(c *gin.Context) string {
+	if user, ok := currentUser(c); ok {
+		return user.Role
+	}
+	return c.GetString("role")
+}
+
+// validateEventType checks an event against the rules of its type, returning the status to reject it with.
+// Only roles the type allows may create events of it, or change an event to it, unless they're an admin.
+func validateEventType(c *gin.Context, event models.Event, typeChanged bool) (int, error) {
+	eventType, ok := findEventType(initializers.DB, event.Type)
+	if !ok {
+		return http.StatusBadRequest, fmt.Errorf("unknown event type %q", event.Type)
+	}
+	if typeChanged && !eventType.AllowsRole(callerRole(c)) && !can(c, models.PermEventsAdmin) {
+		return http.StatusForbidden, fmt.Errorf("your role may not create %s events", event.Type)
+	}
+	if eventType.AllDayOnly && !event.AllDay {
+		return http.StatusBadRequest, fmt.Errorf("%s events must be all-day", event.Type)
+	}
+	if max := eventType.MaxDuration(); max > 0 && eventDuration(event) > max {
+		return http.StatusBadRequest, fmt.Errorf("%s events can last at most %d hours", event.Type, eventType.MaxDurationHours)
+	}
+	return http.StatusOK, nil
+}
+
+// validateEventTypeFields checks the settings of an event type.
+func validateEventTypeFields(eventType models.EventType) error {
+	switch {
+	case eventType.Name == "" || strings.ContainsAny(eventType.Name, " \t\r\n"):
+		return errors.New("name must not be empty or contain whitespace")
+	case eventType.Colour != "" && !colourPattern.MatchString(eventType.Colour):
+		return errors.New("colour must be a hex colour, e.g. #1f77b4")
+	case eventType.MaxDurationHours < 0:
+		return errors.New("max_duration_hours must not be negative")
+	}
+	return nil
+}
+
+// normaliseRoles returns the space-separated form of a list of roles, checking they're all known.
+func normaliseRoles(roles []string) (string, error) {
+	normalised := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !models.IsValidRole(role) {
+			return "", fmt.Errorf("unknown role %q", role)
+		}
+		normalised = append(normalised, models.NormaliseRole(role))
+	}
+	return strings.Join(normalised, " "), nil
+}
+
+// GET /api/event-types
+// Get every registered event type
+func GetEventTypes(c *gin.Context) {
+	var eventTypes []models.EventType
+	if err := initializers.DB.Order("name").Find(&eventTypes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get event types"})
+		return
+	}
+	apiEventTypes := make([]APIEventType, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		apiEventTypes = append(apiEventTypes, eventTypeToAPIEventType(eventType))
+	}
+	c.JSON(http.StatusOK, apiEventTypes)
+}
+
+// GET /api/event-types/:id
+// Get an event type
+func GetEventType(c *gin.Context) {
+	var eventType models.EventType
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&eventType).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event type not found."})
+		return
+	}
+	c.JSON(http.StatusOK, eventTypeToAPIEventType(eventType))
+}
+
+// POST /api/event-types
+// Register an event type
+// If an event type with the name already exists, return a 409 status code
+func CreateEventType(c *gin.Context) {
+	var input NewEventTypeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	roles, err := normaliseRoles(input.AllowedRoles)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	eventType := models.EventType{
+		Name:             strings.TrimSpace(input.Name),
+		Label:            input.Label,
+		Colour:           input.Colour,
+		AllDayOnly:       input.AllDayOnly,
+		AllowedRoles:     roles,
+		MaxDurationHours: input.MaxDurationHours,
+		ExclusivePerDay:  input.ExclusivePerDay,
+	}
+	if eventType.Label == "" {
+		eventType.Label = eventType.Name
+	}
+	if err := validateEventTypeFields(eventType); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, exists := findEventType(initializers.DB, eventType.Name); exists {
+		c.JSON(http.StatusConflict, gin.H{"error": "Event type already exists."})
+		return
+	}
+	if err := initializers.DB.Create(&eventType).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event type"})
+		return
+	}
+	c.JSON(http.StatusCreated, eventTypeToAPIEventType(eventType))
+}
+
+// PATCH /api/event-types/:id
+// Update an event type's label, colour and rules
+// The rules apply to events created or updated afterwards, existing events aren't checked
+func UpdateEventType(c *gin.Context) {
+	var eventType models.EventType
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&eventType).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event type not found."})
+		return
+	}
+	var input PatchEventTypeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Label != nil {
+		eventType.Label = *input.Label
+	}
+	if input.Colour != nil {
+		eventType.Colour = *input.Colour
+	}
+	if input.AllDayOnly != nil {
+		eventType.AllDayOnly = *input.AllDayOnly
+	}
+	if input.AllowedRoles != nil {
+		roles, err := normaliseRoles(*input.AllowedRoles)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		eventType.AllowedRoles = roles
+	}
+	if input.MaxDurationHours != nil {
+		eventType.MaxDurationHours = *input.MaxDurationHours
+	}
+	if input.ExclusivePerDay != nil {
+		eventType.ExclusivePerDay = *input.ExclusivePerDay
+	}
+	if err := validateEventTypeFields(eventType); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := initializers.DB.Save(&eventType).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event type"})
+		return
+	}
+	c.JSON(http.StatusOK, eventTypeToAPIEventType(eventType))
+}
+
+// DELETE /api/event-types/:id
+// Delete an event type
+// If there are events of the type, return a 409 status code
+func DeleteEventType(c *gin.Context) {
+	var eventType models.EventType
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&eventType).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event type not found."})
+		return
+	}
+	var events int64
+	initializers.DB.Model(&models.Event{}).Where("type = ?", eventType.Name).Count(&events)
+	if events > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Event type is in use.", "events": events})
+		return
+	}
+	if err := initializers.DB.Delete(&eventType).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event type"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
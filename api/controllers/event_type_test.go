@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateEventValidatesType(t *testing.T) {
+	setUpDB(t)
+	createEventType(t, models.EventType{Name: "DutyTech1", AllowedRoles: models.RoleEditor, MaxDurationHours: 12})
+	createEventType(t, models.EventType{Name: "leave", AllDayOnly: true})
+	editor := createUser(t, "editor", models.RoleEditor)
+	bot := createUser(t, "bot", models.RoleBot)
+	admin := createUser(t, "admin", models.RoleAdmin)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		user   models.User
+		event  gin.H
+		status int
+	}{
+		{"allowed", editor, gin.H{"type": "DutyTech1", "start_date": start, "end_date": start.Add(8 * time.Hour)}, http.StatusCreated},
+		{"unknown type", editor, gin.H{"type": "DutyTech9", "start_date": start}, http.StatusBadRequest},
+		{"role not allowed", bot, gin.H{"type": "DutyTech1", "start_date": start, "end_date": start.Add(time.Hour)}, http.StatusForbidden},
+		{"admins may create any type", admin, gin.H{"type": "DutyTech1", "start_date": start, "end_date": start.Add(time.Hour)}, http.StatusCreated},
+		{"too long", editor, gin.H{"type": "DutyTech1", "start_date": start, "end_date": start.Add(13 * time.Hour)}, http.StatusBadRequest},
+		{"not all-day", editor, gin.H{"type": "leave", "start_date": start, "end_date": start.Add(time.Hour)}, http.StatusBadRequest},
+		{"all-day", editor, gin.H{"type": "leave", "start_date": "2030-06-03T00:00:00Z", "end_date": "2030-06-05T00:00:00Z", "all_day": true}, http.StatusCreated},
+	}
+	for _, test := range tests {
+		w := request(testRouter(test.user, eventRoutes), "POST", "/api/events/", test.event)
+		assert.Equal(t, test.status, w.Code, "%s: %s", test.name, w.Body.String())
+	}
+}
+
+func TestUpdateEventValidatesTypeChange(t *testing.T) {
+	setUpDB(t)
+	createEventType(t, models.EventType{Name: "DutyTech1", AllowedRoles: models.RoleAdmin})
+	createEventType(t, models.EventType{Name: "OnCall"})
+	editor := createUser(t, "editor", models.RoleEditor)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	// events keep their type after the role which created them is no longer allowed it
+	duty := createEvent(t, editor, "DutyTech1", start)
+	onCall := createEvent(t, editor, "OnCall", start.AddDate(0, 0, 1))
+
+	w := request(testRouter(editor, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d", duty.ID),
+		gin.H{"type": "DutyTech1", "title": "Renamed", "start_date": start, "end_date": start.Add(time.Hour)})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = request(testRouter(editor, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d", onCall.ID),
+		gin.H{"type": "DutyTech1", "start_date": start.AddDate(0, 0, 1), "end_date": start.AddDate(0, 0, 1).Add(time.Hour)})
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+}
+
+func TestCreateEventTypeValidatesFields(t *testing.T) {
+	setUpDB(t)
+	admin := createUser(t, "admin", models.RoleAdmin)
+	router := testRouter(admin, func(r *gin.Engine) {
+		r.POST("/api/event-types/", CreateEventType)
+		r.DELETE("/api/event-types/:id", DeleteEventType)
+	})
+
+	w := request(router, "POST", "/api/event-types/", gin.H{"name": "OnCall", "colour": "#1f77b4", "allowed_roles": []string{"Editor"}})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var eventType APIEventType
+	decode(t, w, &eventType)
+	assert.Equal(t, "OnCall", eventType.Label)
+	assert.Equal(t, []string{models.RoleEditor}, eventType.AllowedRoles)
+
+	w = request(router, "POST", "/api/event-types/", gin.H{"name": "OnCall"})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	for name, body := range map[string]gin.H{
+		"whitespace":       {"name": "On Call"},
+		"colour":           {"name": "Duty", "colour": "blue"},
+		"role":             {"name": "Duty", "allowed_roles": []string{"manager"}},
+		"negative maximum": {"name": "Duty", "max_duration_hours": -1},
+	} {
+		w := request(router, "POST", "/api/event-types/", body)
+		assert.Equal(t, http.StatusBadRequest, w.Code, "%s: %s", name, w.Body.String())
+	}
+
+	// types in use can't be deleted
+	createEvent(t, admin, "OnCall", time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC))
+	w = request(router, "DELETE", fmt.Sprintf("/api/event-types/%d", eventType.ID), nil)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+}
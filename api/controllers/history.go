@@ -0,0 +1,278 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+)
+
+// Days of deleted events the trash lists
+const (
+	defaultTrashDays = 30
+	maxTrashDays     = 365
+)
+
+// APIEventVersion is a version from the history of an event: the change which made it, and the event after it.
+type APIEventVersion struct {
+	Version   int       `json:"version"`
+	Action    string    `json:"action"`
+	ActorID   *uint     `json:"actor_id"`
+	Actor     string    `json:"actor"`
+	RequestID string    `json:"request_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Event     APIEvent  `json:"event"`
+}
+
+// TrashedEvent is a deleted event, and when it was deleted.
+type TrashedEvent struct {
+	APIEvent
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// latestEventVersion returns the number of the latest version of an event, 0 if it has none.
+func latestEventVersion(tx *gorm.DB, id uint) (int, error) {
+	var latest int
+	err := tx.Model(&models.EventVersion{}).Where("event_id = ?", id).Select("COALESCE(MAX(version), 0)").Scan(&latest).Error
+	return latest, err
+}
+
+// recordEventChange records the caller changing an event from before to after, which are nil for a create and
+// a delete respectively, in the audit log and the event's history, as part of the transaction making the change.
+func recordEventChange(c *gin.Context, tx *gorm.DB, action string, before *models.Event, after *models.Event) error {
+	id := before
+	if after != nil {
+		id = after
+	}
+	if err := recordChange(c, tx, action, audit.EntityEvent, id.ID, before, after); err != nil {
+		return err
+	}
+
+	latest, err := latestEventVersion(tx, id.ID)
+	if err != nil {
+		return err
+	}
+	versions := models.NewEventVersions(latest, action, before, after, requestActor(c), c.GetString("request_id"))
+	return tx.Create(&versions).Error
+}
+
+// restoreOverrides brings back the overrides of a deleted recurring event which were deleted with it,
+// identified by being deleted in the same request.
+func restoreOverrides(c *gin.Context, tx *gorm.DB, event models.Event) error {
+	var deletion models.EventVersion
+	err := tx.Where("event_id = ? AND action = ?", event.ID, audit.ActionDelete).Order("version DESC").Limit(1).Find(&deletion).Error
+	if err != nil || deletion.RequestID == "" {
+		return err
+	}
+
+	var overrides []models.Event
+	err = tx.Unscoped().Where("parent_id = ? AND deleted_at IS NOT NULL", event.ID).
+		Where("id IN (?)", tx.Model(&models.EventVersion{}).Select("event_id").
+			Where("action = ? AND request_id = ?", audit.ActionDelete, deletion.RequestID)).
+		Find(&overrides).Error
+	if err != nil {
+		return err
+	}
+	for _, override := range overrides {
+		restored := override
+		restored.DeletedAt = gorm.DeletedAt{}
+		if err := tx.Unscoped().Model(&models.Event{}).Where("id = ?", override.ID).Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := recordEventChange(c, tx, audit.ActionRestore, &override, &restored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GET /api/events/:id/history
+// List the versions of an event, oldest first, including the versions of events which have been deleted
+// Each version has the action which made it, who made it and when, and the event as it was after the change,
+// rendered in the tz parameter's zone, defaulting to the caller's timezone
+// Events changed before their history was kept start with a baseline version of how they were before that change
+// Returns a 304 status code when the ETag of the history is sent in If-None-Match and it hasn't changed
+// If the event doesn't exist, return a 404 status code
+func GetEventHistory(c *gin.Context) {
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+	var event models.Event
+	if err := initializers.DB.Unscoped().Where("id = ?", c.Param("id")).Limit(1).Find(&event).Error; err != nil || event.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found."})
+		return
+	}
+
+	var versions []models.EventVersion
+	if err := initializers.DB.Where("event_id = ?", event.ID).Order("version").Find(&versions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find event history"})
+		return
+	}
+	history := make([]APIEventVersion, 0, len(versions))
+	for _, version := range versions {
+		apiEvent, err := eventToAPIEvent(version.Snapshot)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read event history"})
+			return
+		}
+		history = append(history, APIEventVersion{
+			Version:   version.Version,
+			Action:    version.Action,
+			ActorID:   version.ActorID,
+			Actor:     version.Actor,
+			RequestID: version.RequestID,
+			CreatedAt: version.CreatedAt.In(loc),
+			Event:     localiseEvent(apiEvent, loc),
+		})
+	}
+	respondCached(c, gin.H{"data": history})
+}
+
+// POST /api/events/:id/restore
+// Restore an event to a version from its history, or bring back a deleted event
+// With the version parameter the event is put back as it was in that version, and brought back if it had been deleted
+// Without it, a deleted event is brought back as it was when it was deleted
+// The overrides of the occurrences of a recurring event which were deleted with it are brought back with it
+// Only the owner of the event or an admin may restore it, and only admins may restore a version belonging to somebody else
+// The restored event must satisfy the rules of its type, and the conflict rules unless an admin overrides them
+// with override=true, in which case a 409 status code lists the conflicting events
+// If the event or version doesn't exist, return a 404 status code
+// Otherwise, return the restored event and a 200 status code
+func RestoreEvent(c *gin.Context) {
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+	override, err := overrideConflicts(c)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	var event models.Event
+	if err := initializers.DB.Unscoped().Where("id = ?", c.Param("id")).Limit(1).Find(&event).Error; err != nil || event.ID == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found."})
+		return
+	}
+	if !canModifyEvent(c, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You may only restore your own events."})
+		return
+	}
+
+	restored := event
+	restored.DeletedAt = gorm.DeletedAt{}
+	if value := c.Query("version"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid version %q", value)})
+			return
+		}
+		var version models.EventVersion
+		if err := initializers.DB.Where("event_id = ? AND version = ?", event.ID, number).Limit(1).Find(&version).Error; err != nil || version.ID == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found."})
+			return
+		}
+		restored = version.Snapshot
+		restored.ID, restored.CreatedAt, restored.DeletedAt = event.ID, event.CreatedAt, gorm.DeletedAt{}
+		if !canModifyEvent(c, restored) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins may restore a version belonging to somebody else."})
+			return
+		}
+	} else if !event.DeletedAt.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The event hasn't been deleted, give the version to restore."})
+		return
+	}
+	if status, err := validateEventType(c, restored, restored.Type != event.Type); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Save(&restored).Error; err != nil {
+			return err
+		}
+		if err := recordEventChange(c, tx, audit.ActionRestore, &event, &restored); err != nil {
+			return err
+		}
+		if event.DeletedAt.Valid {
+			if err := restoreOverrides(c, tx, event); err != nil {
+				return err
+			}
+		}
+		if err := checkConflicts(tx, restored, override); err != nil {
+			return err
+		}
+		return saveEventMeta(tx, restored)
+	})
+	if errors.As(err, &conflictError{}) {
+		respondConflict(c, err)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	apiEvent, err := eventToAPIEvent(restored)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read restored event"})
+		return
+	}
+	respondEvent(c, http.StatusOK, localiseEvent(apiEvent, loc))
+}
+
+// GET /api/events/trash
+// List the events deleted in the last days days (default 30, at most 365), most recently deleted first,
+// rendered in the tz parameter's zone, defaulting to the caller's timezone
+// Users see their own deleted events, admins see everybody's
+// Deleted events are brought back with POST /api/events/:id/restore
+func GetTrash(c *gin.Context) {
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+	days := defaultTrashDays
+	if value := c.Query("days"); value != "" {
+		var err error
+		days, err = strconv.Atoi(value)
+		if err != nil || days < 1 || days > maxTrashDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("days must be between 1 and %d", maxTrashDays)})
+			return
+		}
+	}
+
+	query := initializers.DB.Unscoped().Where("deleted_at > ?", time.Now().AddDate(0, 0, -days))
+	if !can(c, models.PermEventsAdmin) {
+		user, ok := currentUser(c)
+		if !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		query = query.Where("user_id = ?", user.ID)
+	}
+	var events []models.Event
+	if err := query.Order("deleted_at DESC, id DESC").Find(&events).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find deleted events"})
+		return
+	}
+
+	trash := make([]TrashedEvent, 0, len(events))
+	for _, event := range events {
+		apiEvent, err := eventToAPIEvent(event)
+		if err != nil {
+			continue
+		}
+		trash = append(trash, TrashedEvent{APIEvent: localiseEvent(apiEvent, loc), DeletedAt: event.DeletedAt.Time.In(loc)})
+	}
+	c.JSON(http.StatusOK, gin.H{"data": trash})
+}
@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deleteEvent deletes an event as user.
+func deleteEvent(t *testing.T, user models.User, id uint) {
+	t.Helper()
+	w := request(testRouter(user, eventRoutes), "DELETE", fmt.Sprintf("/api/events/%d", id), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+}
+
+// trash returns the IDs of the deleted events user sees in the trash.
+func trash(t *testing.T, user models.User) []uint {
+	t.Helper()
+	w := request(testRouter(user, eventRoutes), "GET", "/api/events/trash", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var body struct {
+		Data []TrashedEvent `json:"data"`
+	}
+	decode(t, w, &body)
+	ids := make([]uint, 0, len(body.Data))
+	for _, event := range body.Data {
+		assert.False(t, event.DeletedAt.IsZero())
+		ids = append(ids, uint(event.ID))
+	}
+	return ids
+}
+
+func TestTrashAndRestore(t *testing.T) {
+	setUpDB(t)
+	createEventType(t, models.EventType{Name: "DutyTech1"})
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	admin := createUser(t, "admin", models.RoleAdmin)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	mine := createEvent(t, alice, "DutyTech1", start)
+	theirs := createEvent(t, bob, "DutyTech1", start.AddDate(0, 0, 1))
+	kept := createEvent(t, alice, "DutyTech1", start.AddDate(0, 0, 2))
+	deleteEvent(t, alice, mine.ID)
+	deleteEvent(t, bob, theirs.ID)
+
+	// users see their own deleted events, admins everybody's, most recently deleted first
+	assert.Equal(t, []uint{mine.ID}, trash(t, alice))
+	assert.Equal(t, []uint{theirs.ID, mine.ID}, trash(t, admin))
+	for _, days := range []string{"0", "366", "week"} {
+		w := request(testRouter(alice, eventRoutes), "GET", "/api/events/trash?days="+days, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, days)
+	}
+
+	tests := []struct {
+		name   string
+		user   models.User
+		target string
+		status int
+	}{
+		{"somebody else's event", alice, fmt.Sprintf("/api/events/%d/restore", theirs.ID), http.StatusForbidden},
+		{"not deleted", alice, fmt.Sprintf("/api/events/%d/restore", kept.ID), http.StatusBadRequest},
+		{"missing event", alice, "/api/events/99/restore", http.StatusNotFound},
+		{"missing version", alice, fmt.Sprintf("/api/events/%d/restore?version=99", mine.ID), http.StatusNotFound},
+		{"invalid version", alice, fmt.Sprintf("/api/events/%d/restore?version=latest", mine.ID), http.StatusBadRequest},
+		{"own event", alice, fmt.Sprintf("/api/events/%d/restore", mine.ID), http.StatusOK},
+		{"as an admin", admin, fmt.Sprintf("/api/events/%d/restore", theirs.ID), http.StatusOK},
+	}
+	for _, test := range tests {
+		w := request(testRouter(test.user, eventRoutes), "POST", test.target, nil)
+		assert.Equal(t, test.status, w.Code, test.name+": "+w.Body.String())
+	}
+
+	var restored []models.Event
+	require.NoError(t, initializers.DB.Order("id").Find(&restored).Error)
+	require.Len(t, restored, 3)
+	assert.Equal(t, start, restored[0].StartDate.UTC())
+	assert.Empty(t, trash(t, admin))
+}
+
+func TestRestoreVersion(t *testing.T) {
+	setUpDB(t)
+	createEventType(t, models.EventType{Name: "DutyTech1"})
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	admin := createUser(t, "admin", models.RoleAdmin)
+	event := createEvent(t, alice, "DutyTech1", time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC))
+	require.NoError(t, initializers.DB.Model(&event).Update("title", "Early").Error)
+
+	// the first change records a baseline version of the event before it
+	w := request(testRouter(alice, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d", event.ID), gin.H{"title": "Late"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = request(testRouter(admin, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d", event.ID), gin.H{"user_id": bob.ID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// a version belonging to somebody else is only restored by an admin
+	w = request(testRouter(bob, eventRoutes), "POST", fmt.Sprintf("/api/events/%d/restore?version=1", event.ID), nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+	w = request(testRouter(admin, eventRoutes), "POST", fmt.Sprintf("/api/events/%d/restore?version=1", event.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var body APIEvent
+	decode(t, w, &body)
+	assert.Equal(t, "Early", body.Title)
+
+	var restored models.Event
+	require.NoError(t, initializers.DB.First(&restored, event.ID).Error)
+	assert.Equal(t, "Early", restored.Title)
+	assert.Equal(t, int(alice.ID), restored.UserID)
+}
+
+func TestRestoreOverrides(t *testing.T) {
+	setUpDB(t)
+	createEventType(t, models.EventType{Name: "OnCall"})
+	alice := createUser(t, "alice", models.RoleEditor)
+	weekly := createEvent(t, alice, "OnCall", time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC))
+	require.NoError(t, initializers.DB.Model(&weekly).Update("rrule", "FREQ=WEEKLY;COUNT=4").Error)
+	w := request(testRouter(alice, eventRoutes), "PATCH",
+		fmt.Sprintf("/api/events/%d?scope=occurrence&occurrence_date=2030-06-10", weekly.ID), gin.H{"title": "Handed over"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var override APIEvent
+	decode(t, w, &override)
+
+	// the override is deleted with its series, and brought back with it
+	deleteEvent(t, alice, weekly.ID)
+	assert.ElementsMatch(t, []uint{weekly.ID, uint(override.ID)}, trash(t, alice))
+	w = request(testRouter(alice, eventRoutes), "POST", fmt.Sprintf("/api/events/%d/restore", weekly.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var restored []models.Event
+	require.NoError(t, initializers.DB.Order("id").Find(&restored).Error)
+	require.Len(t, restored, 2)
+	assert.Equal(t, weekly.ID, *restored[1].ParentID)
+	assert.Equal(t, "Handed over", restored[1].Title)
+	assert.Empty(t, trash(t, alice))
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+)
+
+// regionFor returns the region whose bank holidays apply to a user.
+func regionFor(user models.User) string {
+	if user.Region != "" {
+		return user.Region
+	}
+	return config.DefaultRegion
+}
+
+// requestRegion returns the region in the region query parameter, defaulting to the caller's region.
+func requestRegion(c *gin.Context) (string, bool) {
+	if region := c.Query("region"); region != "" {
+		return region, models.IsValidRegion(region)
+	}
+	user, _ := currentUser(c)
+	return regionFor(user), true
+}
+
+// findHolidays returns the bank holidays in a region in [start, end].
+func findHolidays(region string, start time.Time, end time.Time) ([]APIEvent, error) {
+	return findOccurrences("type = ? AND (region = ? OR region = '')",
+		[]interface{}{models.EventTypeBankHoliday, region}, start, end)
+}
+
+// GET /api/holidays
+// Get the bank holidays between start_date and end_date in a region
+// The region parameter defaults to the caller's region, or DEFAULT_REGION if they haven't set one
+// Dates without an offset are in the tz parameter's zone, defaulting to the caller's timezone
+func GetHolidays(c *gin.Context) {
+	region, ok := requestRegion(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown region."})
+		return
+	}
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+	start, end, err := ConvertDateRangeIn(c.Query("start_date"), c.Query("end_date"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_date and end_date are required: " + err.Error()})
+		return
+	}
+	holidays, err := findHolidays(region, start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find holidays"})
+		return
+	}
+	c.JSON(http.StatusOK, localiseEvents(holidays, loc))
+}
@@ -0,0 +1,251 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/ical"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+)
+
+// Statuses of the events in an import report
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportSkipped = "skipped"
+)
+
+// defaultImportType is the Type given to imported events when neither the request nor
+// the ICS_IMPORT_DEFAULT_TYPE environment variable specify one
+const defaultImportType = "leave"
+
+// ImportResult reports what happened to a single VEVENT in an imported calendar.
+type ImportResult struct {
+	UID     string `json:"uid"`
+	Status  string `json:"status"`
+	EventID uint   `json:"event_id,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	// Conflicts are the existing events a VEVENT skipped for breaking the conflict rules conflicts with
+	Conflicts []Conflict `json:"conflicts,omitempty"`
+}
+
+// ImportReport is the response to an iCalendar import.
+type ImportReport struct {
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Skipped int            `json:"skipped"`
+	Events  []ImportResult `json:"events"`
+}
+
+// add records the result of importing a VEVENT.
+func (report *ImportReport) add(result ImportResult) {
+	switch result.Status {
+	case ImportCreated:
+		report.Created++
+	case ImportUpdated:
+		report.Updated++
+	case ImportSkipped:
+		report.Skipped++
+	}
+	report.Events = append(report.Events, result)
+}
+
+// importType returns the Type to give imported events.
+func importType(c *gin.Context) string {
+	if t := c.PostForm("type"); t != "" {
+		return t
+	}
+	if t := c.Query("type"); t != "" {
+		return t
+	}
+	if t := os.Getenv("ICS_IMPORT_DEFAULT_TYPE"); t != "" {
+		return t
+	}
+	return defaultImportType
+}
+
+// readCalendarUpload reads the calendar from the "file" field of a multipart upload, or from the request body.
+func readCalendarUpload(c *gin.Context) (*ical.Calendar, error) {
+	var body io.Reader = c.Request.Body
+	if file, err := c.FormFile("file"); err == nil {
+		upload, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer upload.Close()
+		body = upload
+	}
+	return ical.Parse(body)
+}
+
+// icalEventToEvent converts an iCalendar VEVENT into an Event struct in a timezone.
+// All-day VEVENTs keep their dates in the timezone.
+func icalEventToEvent(icalEvent ical.Event, timezone string) models.Event {
+	event := models.Event{
+		Title:         icalEvent.Summary,
+		StartDate:     icalEvent.Start.UTC(),
+		EndDate:       icalEvent.End.UTC(),
+		AllDay:        icalEvent.AllDay,
+		RecurringType: "None",
+		ExternalID:    icalEvent.UID,
+		Timezone:      timezone,
+	}
+	setEventDays(&event, icalEvent.Start, icalEvent.End)
+	if icalEvent.RRule != nil {
+		event.RRule = icalEvent.RRule.String()
+	}
+	return event
+}
+
+// skipConflicting skips a VEVENT whose event breaks the conflict rules, reporting the events it conflicts with.
+// Other errors from checking the conflicts are returned.
+func skipConflicting(result ImportResult, err error) (ImportResult, error) {
+	var conflicted conflictError
+	if !errors.As(err, &conflicted) {
+		return result, err
+	}
+	result.Status, result.Reason, result.Conflicts = ImportSkipped, "conflicts with existing events", conflicted.conflicts
+	return result, nil
+}
+
+// importEvent creates or updates the event for a VEVENT, keyed by the owner and the VEVENT's UID.
+// Events breaking the conflict rules are skipped, unless the rules are overridden.
+func importEvent(c *gin.Context, tx *gorm.DB, icalEvent ical.Event, user models.User, eventType string, override bool) (ImportResult, error) {
+	result := ImportResult{UID: icalEvent.UID, Status: ImportSkipped}
+	switch {
+	case icalEvent.UID == "":
+		result.Reason = "missing UID"
+		return result, nil
+	case !icalEvent.RecurrenceID.IsZero():
+		result.Reason = "overrides of single occurrences are not supported"
+		return result, nil
+	case icalEvent.Status == "CANCELLED":
+		result.Reason = "event is cancelled"
+		return result, nil
+	}
+
+	imported := icalEventToEvent(icalEvent, timezoneFor(user))
+	var existing models.Event
+	tx.Where("user_id = ? AND external_id = ?", user.ID, icalEvent.UID).Limit(1).Find(&existing)
+
+	if existing.ID == 0 {
+		imported.Type = eventType
+		imported.UserID = int(user.ID)
+		if err := checkConflicts(tx, imported, override); err != nil {
+			return skipConflicting(result, err)
+		}
+		if err := tx.Create(&imported).Error; err != nil {
+			return result, err
+		}
+		if err := recordEventChange(c, tx, audit.ActionCreate, nil, &imported); err != nil {
+			return result, err
+		}
+		result.Status, result.EventID = ImportCreated, imported.ID
+		return result, saveEventMeta(tx, imported)
+	}
+
+	result.EventID = existing.ID
+	if existing.Title == imported.Title && existing.StartDate.Equal(imported.StartDate) &&
+		existing.EndDate.Equal(imported.EndDate) && existing.AllDay == imported.AllDay && existing.RRule == imported.RRule &&
+		existing.Timezone == imported.Timezone {
+		result.Reason = "unchanged"
+		return result, nil
+	}
+	before := existing
+	existing.Title = imported.Title
+	existing.StartDate = imported.StartDate
+	existing.EndDate = imported.EndDate
+	existing.AllDay = imported.AllDay
+	existing.RRule = imported.RRule
+	existing.Timezone = imported.Timezone
+	existing.StartDay, existing.EndDay = imported.StartDay, imported.EndDay
+	if err := checkConflicts(tx, existing, override); err != nil {
+		return skipConflicting(result, err)
+	}
+	if err := tx.Save(&existing).Error; err != nil {
+		return result, err
+	}
+	if err := recordEventChange(c, tx, audit.ActionUpdate, &before, &existing); err != nil {
+		return result, err
+	}
+	result.Status = ImportUpdated
+	return result, saveEventMeta(tx, existing)
+}
+
+// POST /events/import
+// Import the events in an uploaded iCalendar file as events owned by the authenticated user
+// The file is read from the "file" field of a multipart form, or from the request body
+// Imported events are given the Type in the "type" field, or ICS_IMPORT_DEFAULT_TYPE, which must be registered
+// Events breaking the rules of the type are skipped, as are events breaking the conflict rules, whose results list
+// the events they conflict with, unless an admin overrides them with override=true
+// Importing is idempotent: events are matched to earlier imports by their UID
+// Returns a report of the created, updated and skipped events
+func ImportEvents(c *gin.Context) {
+	// Get user from middleware
+	userFromCookie, exists := c.Get("user")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	user := userFromCookie.(models.User)
+	override, err := overrideConflicts(c)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+
+	calendar, err := readCalendarUpload(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read calendar: " + err.Error()})
+		return
+	}
+
+	eventType := importType(c)
+	registered, ok := findEventType(initializers.DB, eventType)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event type " + eventType})
+		return
+	}
+	if !registered.AllowsRole(callerRole(c)) && !can(c, models.PermEventsAdmin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Your role may not create " + eventType + " events"})
+		return
+	}
+
+	// events breaking the rules of the type are skipped, rather than failing the import
+	invalid := make(map[int]error)
+	for i, icalEvent := range calendar.Events {
+		candidate := icalEventToEvent(icalEvent, timezoneFor(user))
+		candidate.Type = eventType
+		if _, err := validateEventType(c, candidate, false); err != nil {
+			invalid[i] = err
+		}
+	}
+
+	report := ImportReport{Events: make([]ImportResult, 0)}
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		for i, icalEvent := range calendar.Events {
+			if err := invalid[i]; err != nil {
+				report.add(ImportResult{UID: icalEvent.UID, Status: ImportSkipped, Reason: err.Error()})
+				continue
+			}
+			result, err := importEvent(c, tx, icalEvent, user, eventType, override)
+			if err != nil {
+				return err
+			}
+			report.add(result)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
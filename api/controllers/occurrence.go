@@ -0,0 +1,327 @@
+package controllers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/ical"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+)
+
+// Scopes accepted by PATCH and DELETE /events/:id for recurring events
+const (
+	// ScopeSeries applies the change to every occurrence of the event
+	ScopeSeries = "series"
+	// ScopeOccurrence applies the change to a single occurrence only
+	ScopeOccurrence = "occurrence"
+	// ScopeFollowing applies the change to an occurrence and every occurrence after it
+	ScopeFollowing = "following"
+)
+
+// requestScope returns the scope of a PATCH or DELETE request, defaulting to the whole series.
+func requestScope(c *gin.Context) (string, error) {
+	scope := c.DefaultQuery("scope", ScopeSeries)
+	switch scope {
+	case ScopeSeries, ScopeOccurrence, ScopeFollowing:
+		return scope, nil
+	}
+	return "", errors.New("scope must be one of series, occurrence or following")
+}
+
+// findOccurrence returns the start of the occurrence of a recurring event identified by the occurrence_date
+// query parameter. A date selects the first occurrence on that day, a date and time must match an occurrence exactly.
+func findOccurrence(event models.Event, value string) (time.Time, error) {
+	if !isRecurring(event) {
+		return time.Time{}, errors.New("event is not recurring")
+	}
+	if value == "" {
+		return time.Time{}, errors.New("occurrence_date is required")
+	}
+	parsed, err := ParseTimeIn(value, eventLocation(event))
+	if err != nil {
+		return time.Time{}, err
+	}
+	dayStart := time.Date(parsed.Year(), parsed.Month(), parsed.Day(), 0, 0, 0, 0, parsed.Location())
+	for _, occStart := range occurrenceStarts(event, dayStart, dayStart.Add(24*time.Hour-time.Nanosecond)) {
+		if occStart.Equal(parsed) || parsed.Equal(dayStart) && !occStart.Before(dayStart) {
+			return occStart, nil
+		}
+	}
+	return time.Time{}, errors.New("event has no occurrence at occurrence_date")
+}
+
+// isFirstOccurrence reports whether occStart is the first occurrence of a recurring event, in which
+// case the following scope covers the whole series.
+func isFirstOccurrence(event models.Event, occStart time.Time) bool {
+	starts := occurrenceStarts(event, event.StartDate, occStart)
+	return len(starts) == 0 || starts[0].Equal(occStart)
+}
+
+// materialisedRule returns the recurrence rule of an event, converting a legacy RecurringType into an RRule
+// so that EXDATEs and UNTILs can be added to it.
+func materialisedRule(event models.Event) (*ical.RRule, error) {
+	rule, err := eventRule(event)
+	if err != nil {
+		return nil, err
+	}
+	if rule == nil {
+		return nil, errors.New("only events with a recurrence rule support occurrence scopes")
+	}
+	return rule, nil
+}
+
+// truncateRule ends a series before the occurrence starting at occStart, returning the rule for
+// the truncated series and the rule for a new series beginning at occStart.
+func truncateRule(rule *ical.RRule, event models.Event, occStart time.Time) (*ical.RRule, *ical.RRule) {
+	dtstart := event.StartDate.In(eventLocation(event))
+	before := *rule
+	after := *rule
+	if rule.Count > 0 {
+		// COUNT includes excluded occurrences, so count them without EXDATEs
+		counting := *rule
+		counting.ExDates = nil
+		counting.ExDays = nil
+		n := len(counting.Between(dtstart, dtstart, occStart.Add(-time.Nanosecond), math.MaxInt32))
+		before.Count = n
+		after.Count = rule.Count - n
+	} else if event.AllDay {
+		// all-day series end at the end of their last date in UTC, see occurrenceStarts
+		before.Until = models.DateOf(occStart.In(dtstart.Location())).In(time.UTC).Add(-time.Second)
+	} else {
+		before.Until = occStart.Add(-time.Second)
+	}
+	return &before, &after
+}
+
+// applyPatch copies the non-zero fields of a patch onto an event, with the same semantics as gorm's Updates.
+func applyPatch(event *models.Event, patch APIEvent) {
+	if patch.Type != "" {
+		event.Type = patch.Type
+	}
+	if patch.Title != "" {
+		event.Title = patch.Title
+	}
+	if !patch.StartDate.IsZero() {
+		event.StartDate = patch.StartDate
+	}
+	if !patch.EndDate.IsZero() {
+		event.EndDate = patch.EndDate
+	}
+	if patch.AllDay {
+		event.AllDay = patch.AllDay
+	}
+	if patch.RecurringType != "" {
+		event.RecurringType = patch.RecurringType
+	}
+	if patch.RecurringInterval != 0 {
+		event.RecurringInterval = patch.RecurringInterval
+	}
+	if patch.RRule != "" {
+		event.RRule = patch.RRule
+	}
+	if patch.UserID != 0 {
+		event.UserID = patch.UserID
+	}
+	if patch.Region != "" {
+		event.Region = patch.Region
+	}
+	if patch.Timezone != "" {
+		event.Timezone = patch.Timezone
+	}
+	// all-day events can be moved by their dates as well as their start and end
+	start, end := patch.StartDate, patch.EndDate
+	if start.IsZero() && patch.StartDay != nil {
+		start = patch.StartDay.In(time.UTC)
+	}
+	if end.IsZero() && patch.EndDay != nil {
+		end = patch.EndDay.AddDays(1).In(time.UTC)
+	}
+	setEventDays(event, start, end)
+}
+
+// splitEvent copies a recurring event so that the copy starts at occStart, without its ID or recurrence.
+func splitEvent(event models.Event, occStart time.Time) models.Event {
+	split := models.Event{
+		Type:      event.Type,
+		Title:     event.Title,
+		StartDate: occStart,
+		AllDay:    event.AllDay,
+		UserID:    event.UserID,
+		Region:    event.Region,
+		Timezone:  event.Timezone,
+		StartDay:  event.StartDay,
+		EndDay:    event.EndDay,
+	}
+	if !event.EndDate.IsZero() {
+		split.EndDate = occStart.Add(event.EndDate.Sub(event.StartDate))
+	}
+	// the copy of an all-day event lasts as many days, starting on the date of the occurrence
+	setEventDays(&split, occStart.In(eventLocation(event)), time.Time{})
+	return split
+}
+
+// updateOccurrence applies a PATCH with an occurrence or following scope to a recurring event.
+// A single occurrence is excluded from the series and replaced by an override row pointing back at the parent.
+// Following occurrences are split off into a new series, ending the original series before them.
+// The replacement is checked against the rules of its type before the transaction, and against the conflict rules
+// unless they're overridden.
+// The replacement is returned in loc, the zone the request asked for.
+func updateOccurrence(c *gin.Context, event models.Event, patch APIEvent, scope string, occStart time.Time, override bool, loc *time.Location) {
+	rule, err := materialisedRule(event)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	original := event
+	var updated models.Event
+	if scope == ScopeOccurrence {
+		excludeOccurrence(rule, event, occStart)
+		event.RRule = rule.String()
+
+		recurrenceID := occStart
+		updated = splitEvent(event, occStart)
+		applyPatch(&updated, patch)
+		updated.RecurringType = "None"
+		updated.RecurringInterval = 0
+		updated.RRule = ""
+		updated.ParentID = &event.ID
+		updated.RecurrenceID = &recurrenceID
+	} else {
+		before, after := truncateRule(rule, event, occStart)
+		event.RRule = before.String()
+
+		updated = splitEvent(event, occStart)
+		updated.RecurringType = event.RecurringType
+		updated.RRule = after.String()
+		applyPatch(&updated, patch)
+	}
+	if status, err := validateEventType(c, updated, updated.Type != event.Type); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockIfMatch(c, tx, event); err != nil {
+			return err
+		}
+		if err := tx.Save(&event).Error; err != nil {
+			return err
+		}
+		if err := recordEventChange(c, tx, audit.ActionUpdate, &original, &event); err != nil {
+			return err
+		}
+		if err := saveEventMeta(tx, event); err != nil {
+			return err
+		}
+		if err := tx.Create(&updated).Error; err != nil {
+			return err
+		}
+		if err := recordEventChange(c, tx, audit.ActionCreate, nil, &updated); err != nil {
+			return err
+		}
+		if scope == ScopeFollowing {
+			// overrides of the following occurrences now belong to the new series
+			var overrides []models.Event
+			if err := tx.Where("parent_id = ? AND recurrence_id >= ?", event.ID, occStart).Find(&overrides).Error; err != nil {
+				return err
+			}
+			for _, child := range overrides {
+				moved := child
+				moved.ParentID = &updated.ID
+				if err := tx.Model(&models.Event{}).Where("id = ?", child.ID).Update("parent_id", updated.ID).Error; err != nil {
+					return err
+				}
+				if err := recordEventChange(c, tx, audit.ActionUpdate, &child, &moved); err != nil {
+					return err
+				}
+			}
+		}
+		// check once the original series is truncated, so the new series doesn't conflict with it
+		if err := checkConflicts(tx, updated, override); err != nil {
+			return err
+		}
+		return saveEventMeta(tx, updated)
+	})
+	if errors.As(err, &conflictError{}) {
+		respondConflict(c, err)
+		return
+	}
+	if errors.Is(err, errPreconditionFailed) {
+		respondPreconditionFailed(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	apiEvent, err := eventToAPIEvent(updated)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read updated event"})
+		return
+	}
+	respondEvent(c, http.StatusOK, localiseEvent(apiEvent, loc))
+}
+
+// deleteOccurrence applies a DELETE with an occurrence or following scope to a recurring event.
+// A single occurrence is cancelled with an EXDATE, following occurrences by ending the series before them.
+// Any override rows for the cancelled occurrences are deleted too.
+func deleteOccurrence(c *gin.Context, event models.Event, scope string, occStart time.Time) {
+	rule, err := materialisedRule(event)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	original := event
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := lockIfMatch(c, tx, event); err != nil {
+			return err
+		}
+		cancelled := tx.Where("parent_id = ? AND recurrence_id = ?", event.ID, occStart)
+		if scope == ScopeOccurrence {
+			excludeOccurrence(rule, event, occStart)
+			event.RRule = rule.String()
+		} else {
+			before, _ := truncateRule(rule, event, occStart)
+			event.RRule = before.String()
+			cancelled = tx.Where("parent_id = ? AND recurrence_id >= ?", event.ID, occStart)
+		}
+		var overrides []models.Event
+		if err := cancelled.Find(&overrides).Error; err != nil {
+			return err
+		}
+		for _, override := range overrides {
+			if err := tx.Delete(&override).Error; err != nil {
+				return err
+			}
+			if err := recordEventChange(c, tx, audit.ActionDelete, &override, nil); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&event).Error; err != nil {
+			return err
+		}
+		if err := recordEventChange(c, tx, audit.ActionUpdate, &original, &event); err != nil {
+			return err
+		}
+		return saveEventMeta(tx, event)
+	})
+	if errors.Is(err, errPreconditionFailed) {
+		respondPreconditionFailed(c)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": true})
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// createWeekly saves an OnCall event repeating weekly for four weeks from June 3rd 2030 at 09:00 UTC,
+// registering the OnCall type.
+func createWeekly(t *testing.T, user models.User) models.Event {
+	t.Helper()
+	createEventType(t, models.EventType{Name: "OnCall"})
+	weekly := createEvent(t, user, "OnCall", time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC))
+	require.NoError(t, initializers.DB.Model(&weekly).Update("rrule", "FREQ=WEEKLY;COUNT=4").Error)
+	return weekly
+}
+
+// seriesStarts returns the starts of the occurrences of a stored event in June 2030, formatted as dates.
+func seriesStarts(t *testing.T, id uint) []string {
+	t.Helper()
+	var event models.Event
+	require.NoError(t, initializers.DB.First(&event, id).Error)
+	dates := make([]string, 0)
+	for _, occStart := range occurrenceStarts(event, time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2030, 7, 1, 0, 0, 0, 0, time.UTC)) {
+		dates = append(dates, occStart.UTC().Format("2006-01-02"))
+	}
+	return dates
+}
+
+// overrides returns the override rows of a recurring event.
+func overrides(t *testing.T, parentID uint) []models.Event {
+	t.Helper()
+	var rows []models.Event
+	require.NoError(t, initializers.DB.Where("parent_id = ?", parentID).Order("recurrence_id").Find(&rows).Error)
+	return rows
+}
+
+func TestUpdateOccurrence(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	weekly := createWeekly(t, alice)
+
+	w := request(testRouter(alice, eventRoutes), "PATCH",
+		fmt.Sprintf("/api/events/%d?scope=occurrence&occurrence_date=2030-06-10", weekly.ID), gin.H{"title": "Handed over"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated APIEvent
+	decode(t, w, &updated)
+	assert.Equal(t, "Handed over", updated.Title)
+	assert.Equal(t, time.Date(2030, 6, 10, 9, 0, 0, 0, time.UTC), updated.StartDate.UTC())
+	assert.Empty(t, updated.RRule)
+
+	// the occurrence is excluded from the series, and replaced by an override pointing back at it
+	assert.Equal(t, []string{"2030-06-03", "2030-06-17", "2030-06-24"}, seriesStarts(t, weekly.ID))
+	rows := overrides(t, weekly.ID)
+	require.Len(t, rows, 1)
+	assert.Equal(t, uint(updated.ID), rows[0].ID)
+	assert.Equal(t, time.Date(2030, 6, 10, 9, 0, 0, 0, time.UTC), rows[0].RecurrenceID.UTC())
+
+	var series models.Event
+	require.NoError(t, initializers.DB.First(&series, weekly.ID).Error)
+	assert.Empty(t, series.Title)
+}
+
+func TestUpdateFollowing(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	weekly := createWeekly(t, alice)
+	r := testRouter(alice, eventRoutes)
+	w := request(r, "PATCH", fmt.Sprintf("/api/events/%d?scope=occurrence&occurrence_date=2030-06-24", weekly.ID),
+		gin.H{"title": "Swapped"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = request(r, "PATCH", fmt.Sprintf("/api/events/%d?scope=following&occurrence_date=2030-06-17", weekly.ID),
+		gin.H{"title": "Handed over"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var updated APIEvent
+	decode(t, w, &updated)
+	assert.Equal(t, "Handed over", updated.Title)
+	assert.NotEqual(t, weekly.ID, uint(updated.ID))
+
+	// the series ends before the occurrence, and a new series carries on from it with the override
+	assert.Equal(t, []string{"2030-06-03", "2030-06-10"}, seriesStarts(t, weekly.ID))
+	assert.Equal(t, []string{"2030-06-17"}, seriesStarts(t, uint(updated.ID)))
+	assert.Empty(t, overrides(t, weekly.ID))
+	rows := overrides(t, uint(updated.ID))
+	require.Len(t, rows, 1)
+	assert.Equal(t, "Swapped", rows[0].Title)
+}
+
+func TestUpdateSeries(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	weekly := createWeekly(t, alice)
+
+	// the series scope, and following from the first occurrence, change the stored event
+	for _, query := range []string{"", "?scope=series", "?scope=following&occurrence_date=2030-06-03"} {
+		w := request(testRouter(alice, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d%s", weekly.ID, query),
+			gin.H{"title": "Renamed" + query})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var updated APIEvent
+		decode(t, w, &updated)
+		assert.Equal(t, weekly.ID, uint(updated.ID), query)
+		assert.Equal(t, "Renamed"+query, updated.Title, query)
+		assert.Equal(t, []string{"2030-06-03", "2030-06-10", "2030-06-17", "2030-06-24"}, seriesStarts(t, weekly.ID), query)
+	}
+
+	single := createEvent(t, alice, "OnCall", time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC))
+	tests := []struct {
+		id    uint
+		query string
+	}{
+		{weekly.ID, "?scope=all&occurrence_date=2030-06-10"},
+		{weekly.ID, "?scope=occurrence"},
+		{weekly.ID, "?scope=occurrence&occurrence_date=2030-06-11"},
+		{weekly.ID, "?scope=occurrence&occurrence_date=2030-06-10T10:00:00Z"},
+		{single.ID, "?scope=occurrence&occurrence_date=2030-06-03"},
+	}
+	for _, test := range tests {
+		w := request(testRouter(alice, eventRoutes), "PATCH", fmt.Sprintf("/api/events/%d%s", test.id, test.query),
+			gin.H{"title": "Renamed"})
+		assert.Equal(t, http.StatusBadRequest, w.Code, test.query)
+	}
+}
+
+func TestDeleteOccurrence(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	weekly := createWeekly(t, alice)
+	r := testRouter(alice, eventRoutes)
+	w := request(r, "PATCH", fmt.Sprintf("/api/events/%d?scope=occurrence&occurrence_date=2030-06-24", weekly.ID),
+		gin.H{"title": "Swapped"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = request(r, "DELETE", fmt.Sprintf("/api/events/%d?scope=occurrence&occurrence_date=2030-06-10", weekly.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"2030-06-03", "2030-06-17"}, seriesStarts(t, weekly.ID))
+	assert.Len(t, overrides(t, weekly.ID), 1)
+
+	// cancelling the following occurrences ends the series before them, and deletes their overrides
+	w = request(r, "DELETE", fmt.Sprintf("/api/events/%d?scope=following&occurrence_date=2030-06-17", weekly.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, []string{"2030-06-03"}, seriesStarts(t, weekly.ID))
+	assert.Empty(t, overrides(t, weekly.ID))
+
+	// deleting the series deletes the event
+	w = request(r, "DELETE", fmt.Sprintf("/api/events/%d", weekly.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.ErrorIs(t, initializers.DB.First(&models.Event{}, weekly.ID).Error, gorm.ErrRecordNotFound)
+}
+
+func TestUpdateOccurrenceLocalises(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	weekly := createWeekly(t, alice)
+
+	tests := map[string]string{
+		"occurrence": "2030-06-10",
+		"following":  "2030-06-17",
+	}
+	for scope, date := range tests {
+		w := request(testRouter(alice, eventRoutes), "PATCH",
+			fmt.Sprintf("/api/events/%d?scope=%s&occurrence_date=%s&tz=America/New_York", weekly.ID, scope, date),
+			gin.H{"title": "Handed over"})
+		require.Equal(t, http.StatusOK, w.Code, scope+": "+w.Body.String())
+		var updated APIEvent
+		decode(t, w, &updated)
+		assert.Equal(t, "Handed over", updated.Title, scope)
+		assert.Equal(t, date+"T05:00:00-04:00", updated.StartDate.Format(time.RFC3339), scope)
+	}
+}
@@ -0,0 +1,38 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+)
+
+// currentUser returns the user attached to the request by the auth middleware.
+// Requests authenticated with an allowed token have no user.
+func currentUser(c *gin.Context) (models.User, bool) {
+	user, exists := c.Get("user")
+	if !exists {
+		return models.User{}, false
+	}
+	u, ok := user.(models.User)
+	return u, ok
+}
+
+// can reports whether the caller's role, and the scopes of their API token if they used one, grant permission.
+func can(c *gin.Context, permission string) bool {
+	if scopes, ok := c.Get("scopes"); ok && !models.ScopesAllow(scopes.([]string), permission) {
+		return false
+	}
+	if user, ok := currentUser(c); ok {
+		return user.Can(permission)
+	}
+	return models.RoleHasPermission(c.GetString("role"), permission)
+}
+
+// canModifyEvent reports whether the caller may change or delete an event.
+// Users may modify their own events, modifying anybody else's requires the events:admin permission.
+func canModifyEvent(c *gin.Context, event models.Event) bool {
+	if can(c, models.PermEventsAdmin) {
+		return true
+	}
+	user, ok := currentUser(c)
+	return ok && user.ID != 0 && int(user.ID) == event.UserID
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/middleware"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanModifyEvent(t *testing.T) {
+	owner := models.User{Username: "alice", Role: models.RoleEditor}
+	owner.ID = 1
+	other := models.User{Username: "bob", Role: models.RoleEditor}
+	other.ID = 2
+	admin := models.User{Username: "admin", Role: models.RoleAdmin}
+	admin.ID = 3
+	event := models.Event{UserID: 1}
+
+	tests := []struct {
+		name    string
+		user    *models.User
+		role    string
+		scopes  []string
+		allowed bool
+	}{
+		{"owner", &owner, "", nil, true},
+		{"somebody else", &other, "", nil, false},
+		{"admin", &admin, "", nil, true},
+		{"owner with a token", &owner, "", []string{models.PermEventsWrite}, true},
+		{"admin with a token without events:admin", &admin, "", []string{models.PermEventsWrite}, false},
+		{"admin with a token with events:admin", &admin, "", []string{models.PermEventsAdmin}, true},
+		{"allowed token with the editor role", nil, models.RoleEditor, nil, false},
+		{"allowed token with the admin role", nil, models.RoleAdmin, nil, true},
+	}
+	for _, test := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		if test.user != nil {
+			c.Set("user", *test.user)
+		}
+		if test.role != "" {
+			c.Set("role", test.role)
+		}
+		if test.scopes != nil {
+			c.Set("scopes", test.scopes)
+		}
+		assert.Equal(t, test.allowed, canModifyEvent(c, event), test.name)
+	}
+}
+
+func TestModifyEventPermissions(t *testing.T) {
+	setUpDB(t)
+	createEventType(t, models.EventType{Name: "DutyTech1"})
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	viewer := createUser(t, "viewer", models.RoleViewer)
+	admin := createUser(t, "admin", models.RoleAdmin)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	routes := func(r *gin.Engine) {
+		r.PATCH("/api/events/:id", middleware.RequirePermission(models.PermEventsWrite), UpdateEvent)
+		r.DELETE("/api/events/:id", middleware.RequirePermission(models.PermEventsWrite), DeleteEvent)
+	}
+
+	tests := []struct {
+		name   string
+		user   models.User
+		owner  models.User
+		status int
+	}{
+		{"viewer changing their own event", viewer, viewer, http.StatusForbidden},
+		{"editor changing somebody else's event", bob, alice, http.StatusForbidden},
+		{"editor changing their own event", alice, alice, http.StatusOK},
+		{"admin changing somebody else's event", admin, alice, http.StatusOK},
+	}
+	for _, test := range tests {
+		event := createEvent(t, test.owner, "DutyTech1", start)
+		w := request(testRouter(test.user, routes), "PATCH", fmt.Sprintf("/api/events/%d", event.ID), map[string]string{"title": "Changed"})
+		assert.Equal(t, test.status, w.Code, "PATCH: "+test.name+": "+w.Body.String())
+		w = request(testRouter(test.user, routes), "DELETE", fmt.Sprintf("/api/events/%d", event.ID), nil)
+		assert.Equal(t, test.status, w.Code, "DELETE: "+test.name+": "+w.Body.String())
+	}
+}
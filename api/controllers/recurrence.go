@@ -0,0 +1,270 @@
+package controllers
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/ical"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+)
+
+// maxOccurrences bounds how many occurrences a single recurring event is expanded into,
+// so that an open-ended daily event cannot blow up a response.
+const maxOccurrences = 1000
+
+// recurringCondition matches stored events which repeat, for use in range queries where
+// the stored StartDate may fall before the requested window.
+const recurringCondition = "(rrule <> '' OR (recurring_type <> '' AND recurring_type <> 'None') OR recurring_interval > 0)"
+
+// legacyRules maps the legacy RecurringType values onto equivalent recurrence rules.
+var legacyRules = map[string]string{
+	"daily":       "FREQ=DAILY",
+	"weekly":      "FREQ=WEEKLY",
+	"fortnightly": "FREQ=WEEKLY;INTERVAL=2",
+	"monthly":     "FREQ=MONTHLY",
+	"yearly":      "FREQ=YEARLY",
+}
+
+// isRecurring reports whether the event repeats.
+// Events without an RRule, with a RecurringType of "" or "None" and no RecurringInterval only occur once.
+func isRecurring(event models.Event) bool {
+	if event.RRule != "" {
+		return true
+	}
+	switch strings.ToLower(event.RecurringType) {
+	case "", "none":
+		return event.RecurringInterval > 0
+	}
+	return true
+}
+
+// eventRule returns the recurrence rule of an event, either parsed from its RRule or derived
+// from its legacy RecurringType. A nil rule with no error means the event has no calendar based rule.
+// The EXDATEs of all-day events are converted to dates, as they used to be stored as midnight UTC.
+func eventRule(event models.Event) (*ical.RRule, error) {
+	if event.RRule != "" {
+		rule, err := ical.ParseRRule(event.RRule)
+		if err != nil || !event.AllDay {
+			return rule, err
+		}
+		for _, exDate := range rule.ExDates {
+			rule.ExDays = append(rule.ExDays, exDate.UTC().Format(models.DateFormat))
+		}
+		rule.ExDates = nil
+		return rule, nil
+	}
+	if legacy, ok := legacyRules[strings.ToLower(event.RecurringType)]; ok {
+		return ical.ParseRRule(legacy)
+	}
+	return nil, nil
+}
+
+// intervalStart returns the start of the nth occurrence of an event which repeats every
+// RecurringInterval seconds, where the stored event is occurrence 0.
+func intervalStart(event models.Event, n int) time.Time {
+	return event.StartDate.In(eventLocation(event)).Add(time.Duration(n) * time.Duration(event.RecurringInterval) * time.Second)
+}
+
+// occurrenceStarts returns the start times of the occurrences of a recurring event which overlap [start, end].
+// Occurrences keep the wall-clock time of the first one in the event's zone, e.g. 09:00 in London across BST.
+func occurrenceStarts(event models.Event, start time.Time, end time.Time) []time.Time {
+	duration := eventDuration(event)
+	dtstart := event.StartDate.In(eventLocation(event))
+	rule, err := eventRule(event)
+	if err != nil {
+		log.Println("could not parse recurrence rule for event", event.ID, err)
+		return nil
+	}
+	if rule != nil {
+		if event.AllDay && !rule.Until.IsZero() {
+			// the UNTIL of an all-day series is the end of its last date in UTC, whatever its zone
+			until := *rule
+			until.Until = models.DateOf(rule.Until.UTC()).AddDays(1).In(dtstart.Location()).Add(-time.Second)
+			rule = &until
+		}
+		starts := make([]time.Time, 0)
+		// occurrences starting up to one duration before the window still overlap it
+		for _, occStart := range rule.Between(dtstart, start.Add(-duration), end, maxOccurrences) {
+			if overlaps(occStart, occStart.Add(duration), start, end) {
+				starts = append(starts, occStart)
+			}
+		}
+		return starts
+	}
+
+	starts := make([]time.Time, 0)
+	if event.RecurringInterval == 0 {
+		return starts
+	}
+	// start from the last occurrence beginning a duration before the window, so that the cap counts
+	// occurrences returned rather than those since the first
+	n := 0
+	if from := start.Add(-duration); from.After(dtstart) {
+		n = int(from.Sub(dtstart) / (time.Duration(event.RecurringInterval) * time.Second))
+	}
+	for ; len(starts) < maxOccurrences; n++ {
+		occStart := intervalStart(event, n)
+		if occStart.After(end) {
+			break
+		}
+		if overlaps(occStart, occStart.Add(duration), start, end) {
+			starts = append(starts, occStart)
+		}
+	}
+	return starts
+}
+
+// eventDuration returns how long each occurrence of an event lasts.
+// All-day events without an EndDate last for the whole day.
+func eventDuration(event models.Event) time.Duration {
+	if event.EndDate.After(event.StartDate) {
+		return event.EndDate.Sub(event.StartDate)
+	}
+	if event.AllDay {
+		return 24 * time.Hour
+	}
+	return 0
+}
+
+// overlaps reports whether an occurrence running from occStart to occEnd falls within [start, end].
+func overlaps(occStart time.Time, occEnd time.Time, start time.Time, end time.Time) bool {
+	if occStart.After(end) {
+		return false
+	}
+	return occEnd.After(start) || !occStart.Before(start)
+}
+
+// expandEvent returns the occurrences of a recurring event which overlap [start, end].
+// Each occurrence carries the parent event ID and the date it occurs on.
+func expandEvent(event models.Event, start time.Time, end time.Time) []APIEvent {
+	occurrences := make([]APIEvent, 0)
+	parent, err := eventToAPIEvent(event)
+	if err != nil {
+		return occurrences
+	}
+	for _, occStart := range occurrenceStarts(event, start, end) {
+		occurrence := parent
+		occurrence.ParentID = event.ID
+		occurrence.StartDate = occStart
+		if !event.EndDate.IsZero() {
+			occurrence.EndDate = occStart.Add(event.EndDate.Sub(event.StartDate))
+		}
+		if event.AllDay && event.StartDay != nil && event.EndDay != nil {
+			// all-day occurrences last whole days, which aren't always 24 hours long
+			startDay := models.DateOf(occStart)
+			endDay := startDay.AddDays(event.EndDay.DaysSince(*event.StartDay))
+			occurrence.StartDay, occurrence.EndDay = &startDay, &endDay
+			occurrence.EndDate = endDay.AddDays(1).In(occStart.Location())
+		}
+		occurrenceDate := time.Date(occStart.Year(), occStart.Month(), occStart.Day(), 0, 0, 0, 0, occStart.Location())
+		occurrence.OccurrenceDate = &occurrenceDate
+		occurrences = append(occurrences, occurrence)
+	}
+	return occurrences
+}
+
+// expandEvents converts a slice of Events into APIEvents for the window [start, end],
+// expanding recurring events into their individual occurrences. The result is sorted by start date.
+func expandEvents(events []models.Event, start time.Time, end time.Time) []APIEvent {
+	apiEvents := make([]APIEvent, 0)
+	for _, event := range events {
+		if isRecurring(event) {
+			apiEvents = append(apiEvents, expandEvent(event, start, end)...)
+			continue
+		}
+		apiEvents = append(apiEvents, eventsToAPIEvents([]models.Event{event})...)
+	}
+	sort.SliceStable(apiEvents, func(i, j int) bool {
+		return apiEvents[i].StartDate.Before(apiEvents[j].StartDate)
+	})
+	return apiEvents
+}
+
+// excludeOccurrence cancels the occurrence of a series starting at occStart with an EXDATE.
+// All-day occurrences are excluded by their date in the event's zone.
+func excludeOccurrence(rule *ical.RRule, event models.Event, occStart time.Time) {
+	if !event.AllDay {
+		rule.Exclude(occStart)
+		return
+	}
+	occStart = occStart.In(eventLocation(event))
+	if !rule.IsExcluded(occStart) {
+		rule.ExDays = append(rule.ExDays, occStart.Format(models.DateFormat))
+	}
+}
+
+// findOccurrences returns the occurrences in [start, end] of the events matching query, expanding
+// recurring events. Events which ended more than a day before the window aren't loaded.
+func findOccurrences(query interface{}, args []interface{}, start time.Time, end time.Time) ([]APIEvent, error) {
+	var events []models.Event
+	err := initializers.DB.Where(query, args...).
+		Where("start_date <= ?", end).
+		Where("(start_date >= ? OR end_date >= ? OR "+recurringCondition+")", start.Add(-24*time.Hour), start).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return expandEvents(events, start, end), nil
+}
+
+// validateRecurrence parses an RRule submitted by a client, returning it in canonical form.
+// An empty RRule is valid and means the event doesn't use a recurrence rule.
+func validateRecurrence(rrule string) (string, error) {
+	if strings.TrimSpace(rrule) == "" {
+		return "", nil
+	}
+	rule, err := ical.ParseRRule(rrule)
+	if err != nil {
+		return "", err
+	}
+	return rule.String(), nil
+}
+
+// saveEventMeta stores the parsed recurrence rule of an event in its EventMeta row,
+// removing the row if the event no longer has a rule.
+func saveEventMeta(db *gorm.DB, event models.Event) error {
+	rule, err := eventRule(event)
+	if err != nil {
+		return err
+	}
+	if rule == nil {
+		return db.Where("event_id = ?", event.ID).Delete(&models.EventMeta{}).Error
+	}
+
+	meta := models.EventMeta{EventID: int(event.ID)}
+	db.Where(models.EventMeta{EventID: int(event.ID)}).Limit(1).Find(&meta)
+
+	byDay := make([]string, len(rule.ByDay))
+	for i, weekday := range rule.ByDay {
+		byDay[i] = weekday.String()
+	}
+	byMonthDay := make([]string, len(rule.ByMonthDay))
+	for i, monthDay := range rule.ByMonthDay {
+		byMonthDay[i] = strconv.Itoa(monthDay)
+	}
+	byMonth := make([]string, len(rule.ByMonth))
+	for i, month := range rule.ByMonth {
+		byMonth[i] = strconv.Itoa(month)
+	}
+
+	meta.RecurringStart = uint64(event.StartDate.Unix())
+	meta.RecurringInterval = event.RecurringInterval
+	meta.Freq = rule.Freq
+	meta.Interval = rule.Interval
+	meta.ByDay = strings.Join(byDay, ",")
+	meta.ByMonthDay = strings.Join(byMonthDay, ",")
+	meta.ByMonth = strings.Join(byMonth, ",")
+	meta.Count = rule.Count
+	meta.Until = nil
+	if !rule.Until.IsZero() {
+		until := rule.Until
+		meta.Until = &until
+	}
+	meta.ExDates = strings.Join(rule.ExDateLines(), "\n")
+	return db.Save(&meta).Error
+}
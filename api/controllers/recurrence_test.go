@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOccurrenceStartsInterval(t *testing.T) {
+	start := time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)
+	hourly := models.Event{StartDate: start, EndDate: start.Add(30 * time.Minute), RecurringInterval: 3600}
+	format := func(starts []time.Time) []string {
+		formatted := make([]string, 0, len(starts))
+		for _, occStart := range starts {
+			formatted = append(formatted, occStart.UTC().Format(time.RFC3339))
+		}
+		return formatted
+	}
+
+	tests := []struct {
+		name       string
+		start, end time.Time
+		want       []string
+	}{
+		{"before the event", start.Add(-3 * time.Hour), start.Add(-time.Hour), []string{}},
+		{"overlapping an occurrence", start.Add(15 * time.Minute), start.Add(time.Hour),
+			[]string{"2030-01-01T09:00:00Z", "2030-01-01T10:00:00Z"}},
+		{"between occurrences", start.Add(40 * time.Minute), start.Add(50 * time.Minute), []string{}},
+		// over a thousand intervals after the start
+		{"months later", time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2030, 3, 1, 2, 0, 0, 0, time.UTC),
+			[]string{"2030-03-01T00:00:00Z", "2030-03-01T01:00:00Z", "2030-03-01T02:00:00Z"}},
+		{"decades later", time.Date(2070, 1, 1, 9, 10, 0, 0, time.UTC), time.Date(2070, 1, 1, 9, 20, 0, 0, time.UTC),
+			[]string{"2070-01-01T09:00:00Z"}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.want, format(occurrenceStarts(hourly, test.start, test.end)), test.name)
+	}
+
+	// the cap applies to the occurrences returned, starting from the window
+	window := time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC)
+	starts := occurrenceStarts(hourly, window, window.AddDate(1, 0, 0))
+	assert.Len(t, starts, maxOccurrences)
+	assert.Equal(t, "2031-01-01T00:00:00Z", format(starts[:1])[0])
+}
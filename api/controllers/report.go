@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+)
+
+// maxCoverageSlots bounds how many slots a coverage report can walk through
+const maxCoverageSlots = 10000
+
+// Coverage statuses of a slot
+const (
+	CoverageUncovered     = "uncovered"
+	CoverageCovered       = "covered"
+	CoverageDoubleCovered = "double_covered"
+	// CoverageHoliday is an uncovered slot on a bank holiday, when bank holidays are skipped
+	CoverageHoliday = "holiday"
+)
+
+// CoverageAssignee is an event covering a slot, and who it belongs to.
+type CoverageAssignee struct {
+	EventID  uint   `json:"event_id"`
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// CoverageSlot is a period of a coverage report, from Start up to End, and who is assigned during it.
+type CoverageSlot struct {
+	Start     time.Time          `json:"start"`
+	End       time.Time          `json:"end"`
+	Status    string             `json:"status"`
+	Assignees []CoverageAssignee `json:"assignees"`
+	// Holiday is the name of the bank holiday the slot falls on, if any
+	Holiday string `json:"holiday,omitempty"`
+}
+
+// CoverageInterval is a run of consecutive slots with the same status.
+type CoverageInterval struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	UserIDs []int     `json:"user_ids,omitempty"`
+}
+
+// CoverageReport shows which slots of a date range are covered by events of a type.
+type CoverageReport struct {
+	Type          string             `json:"type"`
+	StartDate     time.Time          `json:"start_date"`
+	EndDate       time.Time          `json:"end_date"`
+	Slot          string             `json:"slot"`
+	Region        string             `json:"region"`
+	Uncovered     []CoverageInterval `json:"uncovered"`
+	DoubleCovered []CoverageInterval `json:"double_covered"`
+	Slots         []CoverageSlot     `json:"slots"`
+}
+
+// nextSlot returns a function stepping from the start of one slot to the next, for a slot of day,
+// week or a duration such as 12h. Days and weeks step by calendar days.
+func nextSlot(slot string) (func(time.Time) time.Time, error) {
+	switch slot {
+	case "", "day":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }, nil
+	case "week":
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }, nil
+	}
+	duration, err := time.ParseDuration(slot)
+	if err != nil || duration < time.Minute {
+		return nil, fmt.Errorf("slot must be day, week or a duration of at least 1m")
+	}
+	return func(t time.Time) time.Time { return t.Add(duration) }, nil
+}
+
+// overlapsSlot reports whether an occurrence is on at any time during a slot.
+func overlapsSlot(occurrence APIEvent, slotStart time.Time, slotEnd time.Time) bool {
+	occStart, occEnd := occurrenceSpan(occurrence)
+	if occEnd.Equal(occStart) {
+		occEnd = occEnd.Add(time.Nanosecond)
+	}
+	return occStart.Before(slotEnd) && slotStart.Before(occEnd)
+}
+
+// coverageSlots walks from start to end one slot at a time, listing the occurrences covering each slot.
+// Uncovered slots on bank holidays are given the holiday status instead when skipHolidays is set.
+func coverageSlots(occurrences []APIEvent, holidays []APIEvent, skipHolidays bool, usernames map[int]string, start time.Time, end time.Time, next func(time.Time) time.Time) ([]CoverageSlot, error) {
+	slots := make([]CoverageSlot, 0)
+	for slotStart := start; slotStart.Before(end); slotStart = next(slotStart) {
+		if len(slots) == maxCoverageSlots {
+			return nil, fmt.Errorf("the report can have at most %d slots, use a longer slot or a shorter range", maxCoverageSlots)
+		}
+		slotEnd := next(slotStart)
+		if slotEnd.After(end) {
+			slotEnd = end
+		}
+		slot := CoverageSlot{Start: slotStart, End: slotEnd, Assignees: make([]CoverageAssignee, 0)}
+		for _, holiday := range holidays {
+			if slot.Holiday == "" && overlapsSlot(holiday, slotStart, slotEnd) {
+				slot.Holiday = holiday.Title
+			}
+		}
+		for _, occurrence := range occurrences {
+			if overlapsSlot(occurrence, slotStart, slotEnd) {
+				slot.Assignees = append(slot.Assignees, CoverageAssignee{
+					EventID:  uint(occurrence.ID),
+					UserID:   occurrence.UserID,
+					Username: usernames[occurrence.UserID],
+				})
+			}
+		}
+		switch len(slot.Assignees) {
+		case 0:
+			slot.Status = CoverageUncovered
+			if skipHolidays && slot.Holiday != "" {
+				slot.Status = CoverageHoliday
+			}
+		case 1:
+			slot.Status = CoverageCovered
+		default:
+			slot.Status = CoverageDoubleCovered
+		}
+		slots = append(slots, slot)
+	}
+	return slots, nil
+}
+
+// coverageIntervals merges consecutive slots with a status into intervals, listing who was assigned during them.
+func coverageIntervals(slots []CoverageSlot, status string) []CoverageInterval {
+	intervals := make([]CoverageInterval, 0)
+	var userIDs map[int]bool
+	for i, slot := range slots {
+		if slot.Status != status {
+			continue
+		}
+		n := len(intervals)
+		if n == 0 || i == 0 || slots[i-1].Status != status {
+			intervals = append(intervals, CoverageInterval{Start: slot.Start})
+			userIDs = make(map[int]bool)
+			n++
+		}
+		intervals[n-1].End = slot.End
+		for _, assignee := range slot.Assignees {
+			if !userIDs[assignee.UserID] {
+				userIDs[assignee.UserID] = true
+				intervals[n-1].UserIDs = append(intervals[n-1].UserIDs, assignee.UserID)
+			}
+		}
+		sort.Ints(intervals[n-1].UserIDs)
+	}
+	return intervals
+}
+
+// writeCoverageCSV renders a coverage report as CSV, with a row per slot.
+func writeCoverageCSV(c *gin.Context, report CoverageReport) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		fmt.Sprintf("coverage-%s-%s.csv", report.Type, report.StartDate.Format("2006-01-02"))))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"start", "end", "status", "holiday", "user_ids", "usernames", "event_ids"})
+	for _, slot := range report.Slots {
+		var userIDs, usernames, eventIDs []string
+		for _, assignee := range slot.Assignees {
+			userIDs = append(userIDs, strconv.Itoa(assignee.UserID))
+			usernames = append(usernames, assignee.Username)
+			eventIDs = append(eventIDs, strconv.FormatUint(uint64(assignee.EventID), 10))
+		}
+		writer.Write([]string{
+			slot.Start.Format(time.RFC3339),
+			slot.End.Format(time.RFC3339),
+			slot.Status,
+			slot.Holiday,
+			strings.Join(userIDs, ";"),
+			strings.Join(usernames, ";"),
+			strings.Join(eventIDs, ";"),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Println("error writing coverage report:", err)
+	}
+}
+
+// GET /api/reports/coverage
+// Report which slots between start_date and end_date are uncovered or double covered by events of a type,
+// and who is assigned during each slot, expanding recurring events
+// The slot parameter is day (default), week or a duration such as 12h
+// Slots on bank holidays in the region are marked, defaulting to the caller's region, and with
+// skip_bank_holidays=true uncovered holidays aren't reported as gaps
+// A date-only end_date includes the whole of that day
+// Dates without an offset are in the tz parameter's zone, defaulting to the caller's timezone, and day and week slots
+// run from midnight to midnight there, even across DST changes
+// The report is returned as CSV when format=csv or the request accepts text/csv, otherwise as JSON
+func GetCoverageReport(c *gin.Context) {
+	eventType := c.Query("type")
+	if eventType == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type is required"})
+		return
+	}
+	loc, ok := requestLocation(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+	start, end, err := ConvertDateRangeIn(c.Query("start_date"), c.Query("end_date"), loc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start_date and end_date are required: " + err.Error()})
+		return
+	}
+	if end.Equal(time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, end.Location())) {
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must be after start_date"})
+		return
+	}
+	slot := c.DefaultQuery("slot", "day")
+	next, err := nextSlot(slot)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	region, ok := requestRegion(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown region."})
+		return
+	}
+	skipHolidays, _ := strconv.ParseBool(c.Query("skip_bank_holidays"))
+
+	occurrences, err := findOccurrences("type = ?", []interface{}{eventType}, start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find events"})
+		return
+	}
+	userIDs := make([]int, 0)
+	for _, occurrence := range occurrences {
+		userIDs = append(userIDs, occurrence.UserID)
+	}
+	var users []models.User
+	if err := initializers.DB.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find users"})
+		return
+	}
+	usernames := make(map[int]string, len(users))
+	for _, user := range users {
+		usernames[int(user.ID)] = user.Username
+	}
+
+	holidays, err := findHolidays(region, start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find bank holidays"})
+		return
+	}
+
+	slots, err := coverageSlots(occurrences, holidays, skipHolidays, usernames, start, end, next)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	report := CoverageReport{
+		Type:          eventType,
+		StartDate:     start,
+		EndDate:       end,
+		Slot:          slot,
+		Region:        region,
+		Uncovered:     coverageIntervals(slots, CoverageUncovered),
+		DoubleCovered: coverageIntervals(slots, CoverageDoubleCovered),
+		Slots:         slots,
+	}
+
+	if c.Query("format") == "csv" || c.NegotiateFormat(gin.MIMEJSON, "text/csv") == "text/csv" {
+		writeCoverageCSV(c, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
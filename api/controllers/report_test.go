@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextSlot(t *testing.T) {
+	start := time.Date(2030, 3, 30, 0, 0, 0, 0, time.UTC)
+	for slot, want := range map[string]time.Time{
+		"":     start.AddDate(0, 0, 1),
+		"day":  start.AddDate(0, 0, 1),
+		"week": start.AddDate(0, 0, 7),
+		"12h":  start.Add(12 * time.Hour),
+	} {
+		next, err := nextSlot(slot)
+		require.NoError(t, err, slot)
+		assert.Equal(t, want, next(start), slot)
+	}
+	for _, slot := range []string{"month", "30s", "-1h"} {
+		_, err := nextSlot(slot)
+		assert.Error(t, err, slot)
+	}
+}
+
+func TestCoverageIntervals(t *testing.T) {
+	day := time.Date(2030, 6, 3, 0, 0, 0, 0, time.UTC)
+	slot := func(i int, status string, userIDs ...int) CoverageSlot {
+		s := CoverageSlot{Start: day.AddDate(0, 0, i), End: day.AddDate(0, 0, i+1), Status: status}
+		for _, id := range userIDs {
+			s.Assignees = append(s.Assignees, CoverageAssignee{UserID: id})
+		}
+		return s
+	}
+	slots := []CoverageSlot{
+		slot(0, CoverageUncovered),
+		slot(1, CoverageUncovered),
+		slot(2, CoverageDoubleCovered, 2, 1),
+		slot(3, CoverageDoubleCovered, 3, 1),
+		slot(4, CoverageCovered, 1),
+		slot(5, CoverageUncovered),
+	}
+	assert.Equal(t, []CoverageInterval{
+		{Start: day, End: day.AddDate(0, 0, 2)},
+		{Start: day.AddDate(0, 0, 5), End: day.AddDate(0, 0, 6)},
+	}, coverageIntervals(slots, CoverageUncovered))
+	assert.Equal(t, []CoverageInterval{
+		{Start: day.AddDate(0, 0, 2), End: day.AddDate(0, 0, 4), UserIDs: []int{1, 2, 3}},
+	}, coverageIntervals(slots, CoverageDoubleCovered))
+	assert.Empty(t, coverageIntervals(slots, CoverageHoliday))
+}
+
+func TestGetCoverageReport(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	bot := createUser(t, "bot", models.RoleBot)
+	day := time.Date(2030, 6, 3, 0, 0, 0, 0, time.UTC)
+	first := createEvent(t, alice, "DutyTech1", day.Add(9*time.Hour))
+	createEvent(t, alice, "DutyTech1", day.AddDate(0, 0, 2).Add(9*time.Hour))
+	createEvent(t, bob, "DutyTech1", day.AddDate(0, 0, 2).Add(13*time.Hour))
+	createEvent(t, bob, "leave", day.AddDate(0, 0, 3).Add(9*time.Hour))
+	holiday := models.Event{Type: models.EventTypeBankHoliday, Title: "Summer bank holiday", AllDay: true, Timezone: "UTC", UserID: int(bot.ID)}
+	setEventDays(&holiday, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2))
+	require.NoError(t, initializers.DB.Create(&holiday).Error)
+
+	router := testRouter(alice, func(r *gin.Engine) { r.GET("/api/reports/coverage", GetCoverageReport) })
+	query := "/api/reports/coverage?type=DutyTech1&start_date=2030-06-03&end_date=2030-06-06&tz=UTC&region=" + models.RegionEnglandAndWales
+	w := request(router, "GET", query+"&skip_bank_holidays=true", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var report CoverageReport
+	decode(t, w, &report)
+
+	// the date-only end includes the whole of 6 June
+	require.Len(t, report.Slots, 4)
+	statuses := make([]string, 0, len(report.Slots))
+	for _, slot := range report.Slots {
+		statuses = append(statuses, slot.Status)
+	}
+	assert.Equal(t, []string{CoverageCovered, CoverageHoliday, CoverageDoubleCovered, CoverageUncovered}, statuses)
+	assert.Equal(t, []CoverageAssignee{{EventID: first.ID, UserID: int(alice.ID), Username: "alice"}}, report.Slots[0].Assignees)
+	assert.Equal(t, "Summer bank holiday", report.Slots[1].Holiday)
+	require.Len(t, report.Uncovered, 1)
+	assert.True(t, report.Uncovered[0].Start.Equal(day.AddDate(0, 0, 3)))
+	require.Len(t, report.DoubleCovered, 1)
+	assert.Equal(t, []int{int(alice.ID), int(bob.ID)}, report.DoubleCovered[0].UserIDs)
+
+	// without skipping bank holidays they're gaps like any other day, and the report can be CSV
+	w = request(router, "GET", query+"&format=csv&slot=week", nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	rows, err := csv.NewReader(strings.NewReader(w.Body.String())).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, []string{"start", "end", "status", "holiday", "user_ids", "usernames", "event_ids"}, rows[0])
+	assert.Equal(t, CoverageDoubleCovered, rows[1][2])
+	assert.Equal(t, "Summer bank holiday", rows[1][3])
+	assert.Equal(t, "alice;alice;bob", rows[1][5])
+
+	w = request(router, "GET", "/api/reports/coverage?start_date=2030-06-03&end_date=2030-06-06", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = request(router, "GET", "/api/reports/coverage?type=DutyTech1&start_date=2030-06-06&end_date=2030-06-03", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,231 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/glssn/scheduler-api/rota"
+)
+
+// RotaConstraints are the constraints on a generated rota, each defaulting to true when left out.
+type RotaConstraints struct {
+	NoBackToBack     *bool `json:"no_back_to_back"`
+	RespectLeave     *bool `json:"respect_leave"`
+	SkipBankHolidays *bool `json:"skip_bank_holidays"`
+	BalanceWeekends  *bool `json:"balance_weekends"`
+}
+
+type GenerateRotaInput struct {
+	// Type is the Type of the generated events, e.g. DutyTech1
+	Type  string `json:"type" binding:"required"`
+	Title string `json:"title"`
+	// StartDate and EndDate are the first and last days of the rota
+	StartDate time.Time `json:"start_date" binding:"required"`
+	EndDate   time.Time `json:"end_date" binding:"required"`
+	// Period is how long each user is assigned for, day or week, defaulting to week
+	Period  string `json:"period"`
+	UserIDs []uint `json:"user_ids" binding:"required"`
+	// Seed makes generation repeatable, a random seed is used and returned when it's left out
+	Seed *int64 `json:"seed"`
+	// LeaveTypes are the event types users are unavailable during, defaulting to ROTA_LEAVE_TYPES
+	LeaveTypes []string `json:"leave_types"`
+	// Region is whose bank holidays apply, defaulting to the users' region if they share one, otherwise DEFAULT_REGION
+	Region      string          `json:"region"`
+	Constraints RotaConstraints `json:"constraints"`
+}
+
+// RotaUserReport is the load a generated rota gives a user.
+type RotaUserReport struct {
+	rota.UserReport
+	Username string `json:"username"`
+}
+
+// RotaResponse is a generated rota. Its events are drafts for review, and aren't saved.
+type RotaResponse struct {
+	Seed       int64            `json:"seed"`
+	Region     string           `json:"region"`
+	Events     []APIEvent       `json:"events"`
+	Report     []RotaUserReport `json:"report"`
+	Unassigned []time.Time      `json:"unassigned"`
+	Warnings   []string         `json:"warnings"`
+}
+
+// rotaLeaveTypes returns the event types which make users unavailable for a rota, set by the
+// comma separated ROTA_LEAVE_TYPES environment variable and defaulting to the leave type.
+func rotaLeaveTypes() []string {
+	var types []string
+	for _, leaveType := range strings.Split(os.Getenv("ROTA_LEAVE_TYPES"), ",") {
+		if leaveType = strings.TrimSpace(leaveType); leaveType != "" {
+			types = append(types, leaveType)
+		}
+	}
+	if len(types) == 0 {
+		return []string{models.EventTypeLeave}
+	}
+	return types
+}
+
+// enabled returns the value of an optional constraint, which defaults to true.
+func enabled(constraint *bool) bool {
+	return constraint == nil || *constraint
+}
+
+// eventSpan returns the days an event covers. All-day events cover their dates.
+func eventSpan(event APIEvent) rota.Span {
+	if event.StartDay != nil && event.EndDay != nil {
+		return rota.Span{UserID: uint(event.UserID), Start: event.StartDay.In(time.UTC), End: event.EndDay.In(time.UTC)}
+	}
+	end := event.StartDate
+	if event.EndDate.After(event.StartDate) {
+		end = event.EndDate
+		if event.AllDay {
+			end = end.Add(-time.Nanosecond)
+		}
+	}
+	return rota.Span{UserID: uint(event.UserID), Start: event.StartDate, End: end}
+}
+
+// POST /api/rotas/generate
+// Generate a fair rota of draft events for review, which aren't saved
+// Users aren't given back-to-back slots or assigned during their leave, the region's bank holidays are skipped and
+// weekend and holiday load is balanced, unless the constraints turn these off
+// Days already covered by events of the type aren't assigned again
+func GenerateRota(c *gin.Context) {
+	var input GenerateRotaInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := findEventType(initializers.DB, input.Type); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown event type " + input.Type})
+		return
+	}
+	if input.Period == "" {
+		input.Period = rota.Week
+	}
+	if input.Seed == nil {
+		seed := time.Now().UnixNano()
+		input.Seed = &seed
+	}
+	if len(input.LeaveTypes) == 0 {
+		input.LeaveTypes = rotaLeaveTypes()
+	}
+
+	var users []models.User
+	if err := initializers.DB.Where("id IN ?", input.UserIDs).Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find users"})
+		return
+	}
+	usernames := make(map[uint]string, len(users))
+	regions := make(map[string]bool)
+	for _, user := range users {
+		usernames[user.ID] = user.Username
+		regions[regionFor(user)] = true
+	}
+	for _, userID := range input.UserIDs {
+		if _, ok := usernames[userID]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User not found.", "user_id": userID})
+			return
+		}
+	}
+	if input.Region == "" {
+		input.Region = config.DefaultRegion
+		if len(regions) == 1 {
+			for region := range regions {
+				input.Region = region
+			}
+		}
+	}
+	if !models.IsValidRegion(input.Region) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown region."})
+		return
+	}
+
+	// the window runs to the end of the rota's last day
+	windowStart := time.Date(input.StartDate.Year(), input.StartDate.Month(), input.StartDate.Day(), 0, 0, 0, 0, time.UTC)
+	windowEnd := time.Date(input.EndDate.Year(), input.EndDate.Month(), input.EndDate.Day(), 0, 0, 0, 0, time.UTC).
+		Add(24*time.Hour - time.Nanosecond)
+	request := rota.Request{
+		Start:  input.StartDate,
+		End:    input.EndDate,
+		Period: input.Period,
+		Users:  input.UserIDs,
+		Seed:   *input.Seed,
+		Constraints: rota.Constraints{
+			NoBackToBack:     enabled(input.Constraints.NoBackToBack),
+			RespectLeave:     enabled(input.Constraints.RespectLeave),
+			SkipBankHolidays: enabled(input.Constraints.SkipBankHolidays),
+			BalanceWeekends:  enabled(input.Constraints.BalanceWeekends),
+		},
+	}
+
+	leave, err := findOccurrences("type IN ? AND user_id IN ?", []interface{}{input.LeaveTypes, input.UserIDs}, windowStart, windowEnd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find leave"})
+		return
+	}
+	for _, event := range leave {
+		request.Leave = append(request.Leave, eventSpan(event))
+	}
+	holidays, err := findHolidays(input.Region, windowStart, windowEnd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find bank holidays"})
+		return
+	}
+	for _, event := range holidays {
+		if event.StartDay != nil {
+			request.BankHolidays = append(request.BankHolidays, event.StartDay.In(time.UTC))
+			continue
+		}
+		request.BankHolidays = append(request.BankHolidays, event.StartDate)
+	}
+	existing, err := findOccurrences("type = ?", []interface{}{input.Type}, windowStart, windowEnd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find existing events"})
+		return
+	}
+	for _, event := range existing {
+		request.Existing = append(request.Existing, eventSpan(event))
+	}
+
+	result, err := rota.Generate(request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := RotaResponse{
+		Seed:       *input.Seed,
+		Region:     input.Region,
+		Events:     make([]APIEvent, 0, len(result.Assignments)),
+		Unassigned: result.Unassigned,
+		Warnings:   result.Warnings,
+	}
+	for _, assignment := range result.Assignments {
+		draft := models.Event{
+			Type:     input.Type,
+			Title:    input.Title,
+			AllDay:   true,
+			UserID:   int(assignment.UserID),
+			Timezone: config.DefaultTimezone,
+		}
+		setEventDays(&draft, assignment.Start, assignment.End.AddDate(0, 0, 1))
+		event, err := eventToAPIEvent(draft)
+		if err != nil {
+			log.Println("error converting rota assignment:", err)
+			continue
+		}
+		response.Events = append(response.Events, event)
+	}
+	for _, report := range result.Report {
+		response.Report = append(response.Report, RotaUserReport{UserReport: report, Username: usernames[report.UserID]})
+	}
+	c.JSON(http.StatusOK, response)
+}
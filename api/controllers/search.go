@@ -0,0 +1,467 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+)
+
+// Page sizes of an event search
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+// searchSorts maps the fields events can be sorted by onto their columns
+var searchSorts = map[string]string{
+	"start_date": "start_date",
+	"id":         "id",
+	"title":      "title",
+}
+
+// EventSearch is a parsed GET /api/events query. Every filter which is set must match.
+type EventSearch struct {
+	IDs     []uint
+	Types   []string
+	UserIDs []int
+	// Start and End bound the occurrences returned, recurring events are expanded when they're set
+	Start     time.Time
+	End       time.Time
+	AllDay    *bool
+	Recurring *bool
+	// Title matches events whose title contains it, ignoring case
+	Title string
+	// Sort is start_date, id or title, descending if Desc is set
+	Sort   string
+	Desc   bool
+	Limit  int
+	Cursor *eventCursor
+	// Location is the zone of the tz parameter, which dates without an offset are in and results are rendered in
+	Location *time.Location
+}
+
+// EventPage is a page of search results, with the cursor of the next page if there is one.
+type EventPage struct {
+	Data       []APIEvent `json:"data"`
+	NextCursor *string    `json:"next_cursor"`
+}
+
+// eventCursor is the position of the last event of a page, in the search's sort order.
+// Start tells apart the occurrences of a recurring event, which share its ID.
+type eventCursor struct {
+	Sort  string    `json:"s"`
+	Value string    `json:"v"`
+	ID    uint      `json:"id"`
+	Start time.Time `json:"o"`
+}
+
+// encode returns the opaque form of the cursor sent to clients.
+func (cursor eventCursor) encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor returned by a previous search with the same sort.
+func decodeCursor(encoded string, sort string) (*eventCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var cursor eventCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if cursor.Sort != sort {
+		return nil, errors.New("cursor belongs to a search with a different sort")
+	}
+	return &cursor, nil
+}
+
+// sortValue returns the value of the sort field of an event, as stored in a cursor.
+func sortValue(event APIEvent, sort string) string {
+	switch sort {
+	case "id":
+		return strconv.FormatUint(uint64(event.ID), 10)
+	case "title":
+		return event.Title
+	}
+	return event.StartDate.UTC().Format(time.RFC3339Nano)
+}
+
+// cursorFor returns the cursor positioned at an event.
+func cursorFor(event APIEvent, sort string) eventCursor {
+	return eventCursor{Sort: sort, Value: sortValue(event, sort), ID: uint(event.ID), Start: event.StartDate.UTC()}
+}
+
+// compareCursors orders two positions by the sort field, then by ID, then by start.
+func compareCursors(a eventCursor, b eventCursor) int {
+	switch {
+	case a.Sort == "id":
+		// the value is the ID
+	case a.Sort == "start_date":
+		if !a.Start.Equal(b.Start) {
+			if a.Start.Before(b.Start) {
+				return -1
+			}
+			return 1
+		}
+	case a.Value != b.Value:
+		return strings.Compare(a.Value, b.Value)
+	}
+	switch {
+	case a.ID != b.ID:
+		if a.ID < b.ID {
+			return -1
+		}
+		return 1
+	case !a.Start.Equal(b.Start):
+		if a.Start.Before(b.Start) {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// splitQuery returns the values of a query parameter which may be repeated or comma separated, e.g. type=a,b&type=c.
+func splitQuery(c *gin.Context, key string) []string {
+	values := make([]string, 0)
+	for _, value := range c.QueryArray(key) {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+// queryBool parses an optional boolean query parameter.
+func queryBool(c *gin.Context, key string) (*bool, error) {
+	value, ok := c.GetQuery(key)
+	if !ok || value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", key)
+	}
+	return &parsed, nil
+}
+
+// parseEventSearch reads an event search from the query parameters:
+// id, type and user_id, which may be repeated or comma separated, date or start_date and end_date,
+// all_day, recurring, q to search titles, sort, limit, cursor and tz.
+func parseEventSearch(c *gin.Context) (EventSearch, error) {
+	search := EventSearch{Title: strings.TrimSpace(c.Query("q")), Types: splitQuery(c, "type")}
+	loc, ok := requestLocation(c)
+	if !ok {
+		return search, errors.New("unknown timezone")
+	}
+	search.Location = loc
+	for _, value := range splitQuery(c, "id") {
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return search, fmt.Errorf("invalid id %q", value)
+		}
+		search.IDs = append(search.IDs, uint(id))
+	}
+	for _, value := range splitQuery(c, "user_id") {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return search, fmt.Errorf("invalid user_id %q", value)
+		}
+		search.UserIDs = append(search.UserIDs, id)
+	}
+
+	date, startDate, endDate := c.Query("date"), c.Query("start_date"), c.Query("end_date")
+	switch {
+	case date != "" && (startDate != "" || endDate != ""):
+		return search, errors.New("date can't be combined with start_date and end_date")
+	case date != "":
+		day, err := ParseTimeIn(date, search.Location)
+		if err != nil {
+			return search, fmt.Errorf("invalid date: %w", err)
+		}
+		search.Start, search.End = day, day.AddDate(0, 0, 1).Add(-time.Microsecond)
+	case startDate != "" || endDate != "":
+		start, end, err := ConvertDateRangeIn(startDate, endDate, search.Location)
+		if err != nil {
+			return search, errors.New("start_date and end_date must both be given: " + err.Error())
+		}
+		if end.Before(start) {
+			return search, errors.New("end_date must not be before start_date")
+		}
+		search.Start, search.End = start, end
+	}
+
+	var err error
+	if search.AllDay, err = queryBool(c, "all_day"); err != nil {
+		return search, err
+	}
+	if search.Recurring, err = queryBool(c, "recurring"); err != nil {
+		return search, err
+	}
+
+	search.Sort = strings.TrimPrefix(c.DefaultQuery("sort", "start_date"), "-")
+	search.Desc = strings.HasPrefix(c.Query("sort"), "-")
+	if _, ok := searchSorts[search.Sort]; !ok {
+		return search, errors.New("sort must be start_date, id or title, prefixed with - for descending order")
+	}
+
+	search.Limit = defaultSearchLimit
+	if limit := c.Query("limit"); limit != "" {
+		search.Limit, err = strconv.Atoi(limit)
+		if err != nil || search.Limit < 1 || search.Limit > maxSearchLimit {
+			return search, fmt.Errorf("limit must be between 1 and %d", maxSearchLimit)
+		}
+	}
+	if cursor := c.Query("cursor"); cursor != "" {
+		if search.Cursor, err = decodeCursor(cursor, search.Sort); err != nil {
+			return search, err
+		}
+	}
+	return search, nil
+}
+
+// expands reports whether the search returns the occurrences of recurring events in a date range.
+func (search EventSearch) expands() bool {
+	return !search.Start.IsZero()
+}
+
+// scope adds the search's filters to a query.
+func (search EventSearch) scope(tx *gorm.DB) *gorm.DB {
+	if len(search.IDs) > 0 {
+		tx = tx.Where("id IN ?", search.IDs)
+	}
+	if len(search.Types) > 0 {
+		tx = tx.Where("type IN ?", search.Types)
+	}
+	if len(search.UserIDs) > 0 {
+		tx = tx.Where("user_id IN ?", search.UserIDs)
+	}
+	if search.AllDay != nil {
+		tx = tx.Where("all_day = ?", *search.AllDay)
+	}
+	if search.Recurring != nil {
+		if *search.Recurring {
+			tx = tx.Where(recurringCondition)
+		} else {
+			tx = tx.Where("NOT " + recurringCondition)
+		}
+	}
+	if search.Title != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(search.Title))
+		tx = tx.Where(`LOWER(title) LIKE ? ESCAPE '\'`, "%"+escaped+"%")
+	}
+	if search.expands() {
+		tx = tx.Where("start_date <= ?", search.End).
+			Where("(start_date >= ? OR end_date >= ? OR "+recurringCondition+")", search.Start.Add(-24*time.Hour), search.Start)
+	}
+	return tx
+}
+
+// page scopes a query to the events after the cursor, in the search's order, one more than the limit
+// so that it's known whether there's another page.
+func (search EventSearch) page(tx *gorm.DB) *gorm.DB {
+	column, direction, after := searchSorts[search.Sort], "ASC", ">"
+	if search.Desc {
+		direction, after = "DESC", "<"
+	}
+	if search.Cursor != nil {
+		var value interface{} = search.Cursor.Value
+		if search.Sort == "start_date" {
+			value = search.Cursor.Start
+		}
+		if search.Sort == "id" {
+			tx = tx.Where("id "+after+" ?", search.Cursor.ID)
+		} else {
+			tx = tx.Where("("+column+" "+after+" ? OR ("+column+" = ? AND id "+after+" ?))", value, value, search.Cursor.ID)
+		}
+	}
+	if search.Sort != "id" {
+		tx = tx.Order(column + " " + direction)
+	}
+	return tx.Order("id " + direction).Limit(search.Limit + 1)
+}
+
+// errTooManyOccurrences is returned when a recurring event has more occurrences in a search's date range than are
+// expanded, and they can't be paged through in the search's order
+var errTooManyOccurrences = errors.New("a recurring event has too many occurrences in the date range to sort in descending order, narrow the range or sort in ascending order")
+
+// run returns a page of the events matching the search, and the cursor of the next page.
+// Without a date range the events are paged in the database. With one, events which don't recur are still paged
+// in the database, while recurring events are expanded into their occurrences in the range after the cursor,
+// and merged with them. Expansion stops at maxOccurrences per event, in which case the page ends at the last
+// occurrence expanded, with a cursor to carry on from, or errTooManyOccurrences is returned for descending order.
+func (search EventSearch) run(tx *gorm.DB) ([]APIEvent, *eventCursor, error) {
+	var events []models.Event
+	if !search.expands() {
+		if err := search.page(search.scope(tx)).Find(&events).Error; err != nil {
+			return nil, nil, err
+		}
+		return search.paginate(eventsToAPIEvents(events))
+	}
+
+	if err := search.page(search.scope(tx).Where("NOT " + recurringCondition)).Find(&events).Error; err != nil {
+		return nil, nil, err
+	}
+	var recurring []models.Event
+	if err := search.scope(tx).Where(recurringCondition).Find(&recurring).Error; err != nil {
+		return nil, nil, err
+	}
+	occurrences := eventsToAPIEvents(events)
+	// the position of the earliest last occurrence of an event whose expansion was cut off
+	var cutoff *eventCursor
+	for _, event := range recurring {
+		start := search.Start
+		// occurrences before the cursor have been returned already, so needn't be expanded again
+		if search.Cursor != nil && !search.Desc {
+			if search.Sort != "start_date" {
+				position := eventCursor{Sort: search.Sort, Value: event.Title, ID: event.ID, Start: search.Cursor.Start}
+				if compareCursors(position, *search.Cursor) < 0 {
+					continue
+				}
+			}
+			if (search.Sort == "start_date" || event.ID == search.Cursor.ID) && search.Cursor.Start.After(start) {
+				start = search.Cursor.Start
+			}
+		}
+		expanded := expandEvent(event, start, search.End)
+		occurrences = append(occurrences, expanded...)
+		if len(expanded) < maxOccurrences {
+			continue
+		}
+		if search.Desc {
+			return nil, nil, fmt.Errorf("%w (event %d)", errTooManyOccurrences, event.ID)
+		}
+		last := cursorFor(expanded[len(expanded)-1], search.Sort)
+		if cutoff == nil || compareCursors(last, *cutoff) < 0 {
+			cutoff = &last
+		}
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		order := compareCursors(cursorFor(occurrences[i], search.Sort), cursorFor(occurrences[j], search.Sort))
+		if search.Desc {
+			return order > 0
+		}
+		return order < 0
+	})
+	if search.Cursor != nil {
+		after := sort.Search(len(occurrences), func(i int) bool {
+			order := compareCursors(cursorFor(occurrences[i], search.Sort), *search.Cursor)
+			if search.Desc {
+				return order < 0
+			}
+			return order > 0
+		})
+		occurrences = occurrences[after:]
+	}
+	if cutoff == nil {
+		return search.paginate(occurrences)
+	}
+
+	// each event's occurrences are in order, so those up to the cutoff are all there, and the rest are left
+	// for the next page
+	complete := sort.Search(len(occurrences), func(i int) bool {
+		return compareCursors(cursorFor(occurrences[i], search.Sort), *cutoff) > 0
+	})
+	results, next, err := search.paginate(occurrences[:complete])
+	if next == nil && len(results) > 0 {
+		last := cursorFor(results[len(results)-1], search.Sort)
+		next = &last
+	}
+	return results, next, err
+}
+
+// singleLookup reports whether a search only names one event by id, which is how events were looked up by ID
+// before they could be searched, and returns the event itself rather than a page of results.
+func singleLookup(c *gin.Context, search EventSearch) bool {
+	if len(search.IDs) != 1 {
+		return false
+	}
+	for key := range c.Request.URL.Query() {
+		if key != "id" && key != "tz" {
+			return false
+		}
+	}
+	return true
+}
+
+// paginate cuts sorted results down to the limit, returning the cursor of the next page if there are more.
+func (search EventSearch) paginate(results []APIEvent) ([]APIEvent, *eventCursor, error) {
+	if len(results) <= search.Limit {
+		return results, nil, nil
+	}
+	results = results[:search.Limit]
+	next := cursorFor(results[len(results)-1], search.Sort)
+	return results, &next, nil
+}
+
+// GET /api/events
+// Search events, combining any of the filters:
+// id, type and user_id, which may be repeated or comma separated to match any of the values,
+// date for the events on a day, or start_date and end_date for the events in a range, expanding recurring events
+// into their occurrences, all_day and recurring (true or false), and q to search titles
+// Dates without an offset are in the tz parameter's zone, defaulting to the caller's timezone, and events are
+// rendered in it, so that a day runs from midnight to midnight there even across DST changes
+// Results are sorted by sort, which is start_date (default), id or title, prefixed with - for descending order
+// Up to limit results (default 100, at most 1000) are returned as data, with a next_cursor which is passed
+// as cursor to get the next page, and is null on the last page
+// Recurring events are expanded into at most 1000 occurrences at a time, so a page may end early to carry on
+// from the last of them, and a descending sort of a range with more returns a 400 status code
+// Each event has an etag to send in If-Match when changing it, and the page has an ETag, which returns
+// a 304 status code when it's sent in If-None-Match and the page hasn't changed
+// A single id without other filters returns that event alone, as an object rather than a page, or a 404 status code
+// if it doesn't exist, as the lookup by id did before events could be searched
+// Its ETag header is the event's etag, so it can be sent in If-Match to change the event, or in If-None-Match
+// to get a 304 status code until the event changes
+// If a parameter is invalid, return a 400 status code
+func GetEvent(c *gin.Context) {
+	search, err := parseEventSearch(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if singleLookup(c, search) {
+		var event models.Event
+		if err := initializers.DB.Where("id = ?", search.IDs[0]).Limit(1).Find(&event).Error; err != nil || event.ID == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found."})
+			return
+		}
+		apiEvent, err := eventToAPIEvent(event)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read event"})
+			return
+		}
+		respondCachedEvent(c, localiseEvent(apiEvent, search.Location))
+		return
+	}
+	events, next, err := search.run(initializers.DB)
+	if errors.Is(err, errTooManyOccurrences) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search events"})
+		return
+	}
+	page := EventPage{Data: localiseEvents(events, search.Location)}
+	if next != nil {
+		encoded := next.encode()
+		page.NextCursor = &encoded
+	}
+	respondCached(c, page)
+}
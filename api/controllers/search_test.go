@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// search returns the IDs of the events, or occurrences, on a page of search results, and the page.
+func search(t *testing.T, user models.User, query string) ([]uint, EventPage) {
+	t.Helper()
+	w := request(testRouter(user, eventRoutes), "GET", "/api/events/?"+query, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var page EventPage
+	decode(t, w, &page)
+	ids := make([]uint, 0, len(page.Data))
+	for _, event := range page.Data {
+		ids = append(ids, uint(event.ID))
+	}
+	return ids, page
+}
+
+func TestGetEventFilters(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	duty := createEvent(t, alice, "DutyTech1", start)
+	other := createEvent(t, bob, "DutyTech2", start.AddDate(0, 0, 1))
+	leave := createEvent(t, bob, "leave", start.AddDate(0, 0, 2))
+	require.NoError(t, initializers.DB.Model(&duty).Update("title", "Morning 100% cover").Error)
+	require.NoError(t, initializers.DB.Model(&leave).Update("title", "Summer holiday").Error)
+	weekly := createEvent(t, alice, "OnCall", start.AddDate(0, 0, -7))
+	require.NoError(t, initializers.DB.Model(&weekly).Update("rrule", "FREQ=WEEKLY;COUNT=4").Error)
+
+	tests := map[string][]uint{
+		"":                                   {weekly.ID, duty.ID, other.ID, leave.ID},
+		"type=DutyTech1,DutyTech2":           {duty.ID, other.ID},
+		"type=DutyTech1&type=leave":          {duty.ID, leave.ID},
+		fmt.Sprintf("user_id=%d", bob.ID):    {other.ID, leave.ID},
+		"q=HOLIDAY":                          {leave.ID},
+		"q=" + url.QueryEscape("100%"):       {duty.ID},
+		"q=" + url.QueryEscape("0%"):         {duty.ID},
+		"q=" + url.QueryEscape("_"):          {},
+		"recurring=true":                     {weekly.ID},
+		"recurring=false&sort=-start_date":   {leave.ID, other.ID, duty.ID},
+		"sort=title&type=DutyTech1,leave":    {duty.ID, leave.ID},
+		fmt.Sprintf("id=%d,%d", duty.ID, 99): {duty.ID},
+		// a date expands the weekly event's occurrence on the day, ordered by ID after the event starting with it
+		"date=2030-06-03&tz=UTC": {duty.ID, weekly.ID},
+		// a range expands each occurrence in it
+		"start_date=2030-06-01&end_date=2030-06-30&type=OnCall&tz=UTC": {weekly.ID, weekly.ID, weekly.ID},
+	}
+	for query, want := range tests {
+		ids, _ := search(t, alice, query)
+		assert.Equal(t, want, ids, query)
+	}
+
+	for _, query := range []string{"id=one", "user_id=x", "all_day=maybe", "sort=type", "limit=0", "limit=1001",
+		"cursor=nonsense", "date=2030-06-03&start_date=2030-06-01", "start_date=2030-06-03", "tz=Mars/Olympus"} {
+		w := request(testRouter(alice, eventRoutes), "GET", "/api/events/?"+query, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestGetEventPages(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	var want []uint
+	for i := 0; i < 5; i++ {
+		// events on the same day are ordered by ID
+		want = append(want, createEvent(t, alice, "DutyTech1", start.AddDate(0, 0, i/2)).ID)
+	}
+
+	for _, sort := range []string{"start_date", "id", "title"} {
+		var got []uint
+		query := "limit=2&sort=" + sort
+		for pages := 0; pages < 5; pages++ {
+			ids, page := search(t, alice, query)
+			got = append(got, ids...)
+			if page.NextCursor == nil {
+				break
+			}
+			assert.Len(t, ids, 2)
+			query = "limit=2&sort=" + sort + "&cursor=" + *page.NextCursor
+		}
+		assert.Equal(t, want, got, sort)
+	}
+
+	// cursors only continue searches with the same sort
+	_, page := search(t, alice, "limit=2")
+	w := request(testRouter(alice, eventRoutes), "GET", "/api/events/?limit=2&sort=-id&cursor="+*page.NextCursor, nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetEventPagesExpanded(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	start := time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC)
+	weekly := createEvent(t, alice, "OnCall", start.AddDate(0, 0, -7))
+	require.NoError(t, initializers.DB.Model(&weekly).Update("rrule", "FREQ=WEEKLY;COUNT=6").Error)
+	for i := 0; i < 5; i++ {
+		createEvent(t, alice, "DutyTech1", start.AddDate(0, 0, 3*i))
+	}
+
+	for _, sort := range []string{"start_date", "-start_date", "id", "title"} {
+		query := "start_date=2030-06-01&end_date=2030-07-31&tz=UTC&sort=" + sort
+		want, _ := search(t, alice, query)
+		require.Len(t, want, 10, sort)
+		var got []uint
+		page := EventPage{NextCursor: new(string)}
+		for pages := 0; page.NextCursor != nil && pages < 10; pages++ {
+			var ids []uint
+			ids, page = search(t, alice, query+"&limit=3&cursor="+*page.NextCursor)
+			got = append(got, ids...)
+		}
+		assert.Equal(t, want, got, sort)
+	}
+}
+
+func TestGetEventTruncatedExpansion(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	hourly := createEvent(t, alice, "OnCall", time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, initializers.DB.Model(&hourly).Updates(map[string]interface{}{
+		"end_date": hourly.StartDate.Add(30 * time.Minute), "recurring_interval": 3600}).Error)
+	first := createEvent(t, alice, "DutyTech1", time.Date(2030, 7, 30, 9, 0, 0, 0, time.UTC))
+	second := createEvent(t, alice, "DutyTech2", time.Date(2030, 7, 30, 10, 0, 0, 0, time.UTC))
+
+	// the range has more occurrences than one expansion returns, so pages continue from where it stopped
+	query := "start_date=2030-06-01&end_date=2030-07-31&tz=UTC&limit=721"
+	for _, sort := range []string{"start_date", "id", "title"} {
+		var starts []time.Time
+		var singles []uint
+		page := EventPage{NextCursor: new(string)}
+		for pages := 0; page.NextCursor != nil && pages < 5; pages++ {
+			_, page = search(t, alice, query+"&sort="+sort+"&cursor="+*page.NextCursor)
+			for _, event := range page.Data {
+				if uint(event.ID) == hourly.ID {
+					starts = append(starts, event.StartDate)
+				} else {
+					singles = append(singles, uint(event.ID))
+				}
+			}
+		}
+		assert.Equal(t, []uint{first.ID, second.ID}, singles, sort)
+		// the range ends at the start of July 31st, which the last occurrence starts at
+		require.Len(t, starts, 60*24+1, sort)
+		for i := 1; i < len(starts); i++ {
+			assert.Equal(t, time.Hour, starts[i].Sub(starts[i-1]), sort)
+		}
+	}
+
+	// a descending search would have to start from the end of the expansion, which it doesn't reach
+	w := request(testRouter(alice, eventRoutes), "GET", "/api/events/?"+query+"&sort=-start_date", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+}
+
+func TestGetEventByID(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	event := createEvent(t, alice, "DutyTech1", time.Date(2030, 6, 3, 9, 0, 0, 0, time.UTC))
+	router := testRouter(alice, eventRoutes)
+
+	// a lone id returns the event itself
+	w := request(router, "GET", fmt.Sprintf("/api/events/?id=%d&tz=Europe/London", event.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var found APIEvent
+	decode(t, w, &found)
+	assert.Equal(t, float64(event.ID), found.ID)
+	assert.Equal(t, "DutyTech1", found.Type)
+	assert.Equal(t, "+01:00", found.StartDate.Format("Z07:00"))
+
+	w = request(router, "GET", "/api/events/?id=99", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+	// with other filters it's a search like any other
+	ids, _ := search(t, alice, fmt.Sprintf("id=%d&type=DutyTech1", event.ID))
+	assert.Equal(t, []uint{event.ID}, ids)
+	ids, _ = search(t, alice, fmt.Sprintf("id=%d&type=leave", event.ID))
+	assert.Empty(t, ids)
+}
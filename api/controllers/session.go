@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/auth"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+)
+
+// Cookies holding a browser's session tokens
+const (
+	accessTokenCookie  = "Authorization"
+	refreshTokenCookie = "Refresh"
+)
+
+// errSessionRevoked is returned when a refresh token is not accepted
+var errSessionRevoked = errors.New("session expired or revoked")
+
+// AccessTokenResponse is returned when a session's tokens are issued or refreshed.
+// RefreshToken is only set for clients which sent their refresh token in the request body.
+type AccessTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// sessionTokens are a newly issued access and refresh token pair.
+type sessionTokens struct {
+	access        string
+	refresh       string
+	sessionExpiry time.Time
+}
+
+// issueTokens creates a refresh token for a session and signs an access token for it.
+func issueTokens(tx *gorm.DB, session models.Session) (sessionTokens, error) {
+	refresh, err := auth.RandomString(32)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+	if err := tx.Create(&models.RefreshToken{SessionID: session.ID, Hash: auth.HashToken(refresh)}).Error; err != nil {
+		return sessionTokens{}, err
+	}
+	access, _, err := auth.SignAccessToken(session.UserID, session.ID, config.Session.AccessTokenTTL)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+	return sessionTokens{access: access, refresh: refresh, sessionExpiry: session.ExpiresAt}, nil
+}
+
+// setSessionCookies sends a session's tokens to the browser.
+func setSessionCookies(c *gin.Context, tokens sessionTokens) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(accessTokenCookie, tokens.access, int(config.Session.AccessTokenTTL.Seconds()), "", "", false, true)
+	c.SetCookie(refreshTokenCookie, tokens.refresh, int(time.Until(tokens.sessionExpiry).Seconds()), "", "", false, true)
+}
+
+// clearSessionCookies removes a session's tokens from the browser.
+func clearSessionCookies(c *gin.Context) {
+	c.SetCookie(accessTokenCookie, "", -1, "", "", false, true)
+	c.SetCookie(refreshTokenCookie, "", -1, "", "", false, true)
+}
+
+// startSession creates a session for a user who has just logged in and sets its token cookies.
+func startSession(c *gin.Context, user models.User) error {
+	var tokens sessionTokens
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		session := models.Session{
+			UserID:    user.ID,
+			ExpiresAt: time.Now().Add(config.Session.SessionTTL),
+			UserAgent: c.Request.UserAgent(),
+			IPAddress: c.ClientIP(),
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		tokens, err = issueTokens(tx, session)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	setSessionCookies(c, tokens)
+	return nil
+}
+
+// rotateRefreshToken exchanges a refresh token for a new token pair. A refresh token which has
+// already been used is evidence that it was stolen, so its whole session is revoked.
+func rotateRefreshToken(refresh string) (sessionTokens, error) {
+	var tokens sessionTokens
+	reused := false
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+		if err := tx.Preload("Session").Where("hash = ?", auth.HashToken(refresh)).Limit(1).Find(&token).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		if token.ID == 0 || !token.Session.IsActive(now) {
+			return errSessionRevoked
+		}
+
+		// mark the token as used, unless a concurrent request got there first
+		result := tx.Model(&models.RefreshToken{}).Where("id = ? AND used_at IS NULL", token.ID).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return errSessionRevoked
+		}
+
+		if err := tx.Model(&token.Session).Update("last_used_at", now).Error; err != nil {
+			return err
+		}
+		var err error
+		tokens, err = issueTokens(tx, token.Session)
+		return err
+	})
+	if reused {
+		log.Println("Refresh token reused, revoking its session")
+		var token models.RefreshToken
+		initializers.DB.Where("hash = ?", auth.HashToken(refresh)).Limit(1).Find(&token)
+		revokeSessions(initializers.DB.Where("id = ?", token.SessionID))
+	}
+	return tokens, err
+}
+
+// revokeSessions revokes the active sessions matching query, returning how many were revoked.
+func revokeSessions(query *gorm.DB) (int64, error) {
+	result := query.Model(&models.Session{}).Where("revoked_at IS NULL").Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// revokeAccessToken records that an access token's ID may no longer be used,
+// and forgets revoked IDs of tokens which have since expired.
+func revokeAccessToken(claims *auth.AccessClaims) error {
+	initializers.DB.Where("expires_at < ?", time.Now()).Delete(&models.RevokedToken{})
+	return initializers.DB.Create(&models.RevokedToken{JTI: claims.ID, ExpiresAt: claims.ExpiresAt.Time}).Error
+}
+
+// requestAccessToken returns the access token sent with the request, from the cookie or a Bearer header.
+func requestAccessToken(c *gin.Context) string {
+	if token, err := c.Cookie(accessTokenCookie); err == nil && token != "" {
+		return token
+	}
+	return strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+}
+
+// POST /token/refresh
+// Exchange a refresh token for a new access token and refresh token
+// The refresh token is read from the Refresh cookie, or from refresh_token in the body
+// Each refresh token can be used once, reusing one revokes the session
+func RefreshSession(c *gin.Context) {
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	c.ShouldBindJSON(&body)
+	refresh := body.RefreshToken
+	if refresh == "" {
+		refresh, _ = c.Cookie(refreshTokenCookie)
+	}
+	if refresh == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing refresh token"})
+		return
+	}
+
+	tokens, err := rotateRefreshToken(refresh)
+	if errors.Is(err, errSessionRevoked) {
+		clearSessionCookies(c)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired or revoked"})
+		return
+	}
+	if err != nil {
+		log.Println("Failed to refresh session:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		return
+	}
+
+	setSessionCookies(c, tokens)
+	response := AccessTokenResponse{
+		AccessToken: tokens.access,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(config.Session.AccessTokenTTL.Seconds()),
+	}
+	if body.RefreshToken != "" {
+		response.RefreshToken = tokens.refresh
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// POST /logout
+// Log out, revoking the session and the access token the request was made with
+func Logout(c *gin.Context) {
+	if claims, err := auth.ParseAccessToken(requestAccessToken(c)); err == nil {
+		revokeSessions(initializers.DB.Where("id = ?", claims.SessionID))
+		if err := revokeAccessToken(claims); err != nil {
+			log.Println("Failed to revoke access token:", err)
+		}
+	} else if refresh, err := c.Cookie(refreshTokenCookie); err == nil && refresh != "" {
+		var token models.RefreshToken
+		initializers.DB.Where("hash = ?", auth.HashToken(refresh)).Limit(1).Find(&token)
+		if token.ID != 0 {
+			revokeSessions(initializers.DB.Where("id = ?", token.SessionID))
+		}
+	}
+	clearSessionCookies(c)
+	c.Status(http.StatusOK)
+}
+
+// DELETE /api/users/:id/sessions
+// Revoke all of a user's sessions, logging them out everywhere
+func DeleteUserSessions(c *gin.Context) {
+	var user models.User
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found."})
+		return
+	}
+
+	revoked, err := revokeSessions(initializers.DB.Where("user_id = ?", user.ID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions."})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
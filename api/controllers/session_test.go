@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/middleware"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setUpSessions configures the lifetimes of sessions and the secret their access tokens are signed with
+// for the rest of the test.
+func setUpSessions(t *testing.T) {
+	t.Setenv("JWT_AUTH_SECRET_KEY", "test-secret")
+	previous := config.Session
+	config.Session = config.SessionConfig{AccessTokenTTL: time.Minute, SessionTTL: time.Hour}
+	t.Cleanup(func() { config.Session = previous })
+}
+
+// startTestSession logs user in, returning the access and refresh tokens of their new session.
+func startTestSession(t *testing.T, user models.User) (string, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/login", nil)
+	require.NoError(t, startSession(c, user))
+	tokens := make(map[string]string)
+	for _, cookie := range w.Result().Cookies() {
+		tokens[cookie.Name] = cookie.Value
+	}
+	require.NotEmpty(t, tokens[accessTokenCookie])
+	require.NotEmpty(t, tokens[refreshTokenCookie])
+	return tokens[accessTokenCookie], tokens[refreshTokenCookie]
+}
+
+// sessionRouter returns a router with the session endpoints, and /validate which requires authentication.
+func sessionRouter() *gin.Engine {
+	r := gin.New()
+	r.POST("/token/refresh", RefreshSession)
+	r.POST("/logout", Logout)
+	r.GET("/validate", middleware.RequireAuth, Validate)
+	return r
+}
+
+// validate returns the status of a request authenticated with an access token.
+func validate(r *gin.Engine, access string) int {
+	return request(r, "GET", "/validate", nil, "Authorization", "Bearer "+access).Code
+}
+
+// refresh exchanges a refresh token, returning the status and the new tokens.
+func refresh(t *testing.T, r *gin.Engine, token string) (int, AccessTokenResponse) {
+	t.Helper()
+	w := request(r, "POST", "/token/refresh", gin.H{"refresh_token": token})
+	var body AccessTokenResponse
+	if w.Code == http.StatusOK {
+		decode(t, w, &body)
+	}
+	return w.Code, body
+}
+
+func TestRefreshSession(t *testing.T) {
+	setUpDB(t)
+	setUpSessions(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	access, first := startTestSession(t, alice)
+	r := sessionRouter()
+	assert.Equal(t, http.StatusOK, validate(r, access))
+
+	// each refresh token is exchanged for a new pair
+	status, rotated := refresh(t, r, first)
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEqual(t, first, rotated.RefreshToken)
+	assert.Equal(t, http.StatusOK, validate(r, rotated.AccessToken))
+	status, latest := refresh(t, r, rotated.RefreshToken)
+	require.Equal(t, http.StatusOK, status)
+
+	// reusing one revokes the whole session, including the tokens issued since
+	status, _ = refresh(t, r, first)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, _ = refresh(t, r, latest.RefreshToken)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, http.StatusUnauthorized, validate(r, latest.AccessToken))
+	assert.Equal(t, http.StatusUnauthorized, validate(r, access))
+
+	status, _ = refresh(t, r, "unknown")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestLogout(t *testing.T) {
+	setUpDB(t)
+	setUpSessions(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	access, refreshToken := startTestSession(t, alice)
+	otherAccess, _ := startTestSession(t, alice)
+	r := sessionRouter()
+
+	w := request(r, "POST", "/logout", nil, "Authorization", "Bearer "+access)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, validate(r, access))
+	status, _ := refresh(t, r, refreshToken)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	var revoked int64
+	initializers.DB.Model(&models.RevokedToken{}).Count(&revoked)
+	assert.Equal(t, int64(1), revoked)
+
+	// sessions on other devices carry on
+	assert.Equal(t, http.StatusOK, validate(r, otherAccess))
+}
+
+func TestDeleteUserSessions(t *testing.T) {
+	setUpDB(t)
+	setUpSessions(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	admin := createUser(t, "admin", models.RoleAdmin)
+	laptop, laptopRefresh := startTestSession(t, alice)
+	phone, _ := startTestSession(t, alice)
+	other, _ := startTestSession(t, bob)
+	r := sessionRouter()
+	routes := func(r *gin.Engine) {
+		r.DELETE("/api/users/:id/sessions", middleware.RequirePermission(models.PermUsersAdmin), DeleteUserSessions)
+	}
+
+	w := request(testRouter(bob, routes), "DELETE", fmt.Sprintf("/api/users/%d/sessions", alice.ID), nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request(testRouter(admin, routes), "DELETE", "/api/users/99/sessions", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = request(testRouter(admin, routes), "DELETE", fmt.Sprintf("/api/users/%d/sessions", alice.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var body struct {
+		Revoked int64 `json:"revoked"`
+	}
+	decode(t, w, &body)
+	assert.Equal(t, int64(2), body.Revoked)
+
+	assert.Equal(t, http.StatusUnauthorized, validate(r, laptop))
+	assert.Equal(t, http.StatusUnauthorized, validate(r, phone))
+	status, _ := refresh(t, r, laptopRefresh)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, http.StatusOK, validate(r, other))
+}
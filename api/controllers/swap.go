@@ -0,0 +1,466 @@
+package controllers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultSwapTTL is how long a swap request is open for when neither the request nor the
+// SWAP_DEFAULT_TTL environment variable specify an expiry
+const defaultSwapTTL = 7 * 24 * time.Hour
+
+// openSwapStates are the states of swap requests which can still be acted on
+var openSwapStates = []string{models.SwapPending, models.SwapAccepted}
+
+// swapError is returned from swap updates to reject the request with an HTTP status and message.
+type swapError struct {
+	status  int
+	message string
+}
+
+func (err swapError) Error() string {
+	return err.message
+}
+
+type NewSwapInput struct {
+	// EventID is the requester's event to swap
+	EventID uint `json:"event_id" binding:"required"`
+	// RecipientID is who the swap is proposed to, leave empty to offer the event to anyone
+	RecipientID *uint `json:"recipient_id"`
+	// RecipientEventID is the event the requester takes in exchange, leave empty to give the event away
+	RecipientEventID *uint      `json:"recipient_event_id"`
+	Message          string     `json:"message"`
+	ExpiresAt        *time.Time `json:"expires_at"`
+}
+
+// swapRequiresApproval reports whether accepted swaps wait for a manager's approval,
+// set by the SWAP_REQUIRE_APPROVAL environment variable.
+func swapRequiresApproval() bool {
+	required, _ := strconv.ParseBool(os.Getenv("SWAP_REQUIRE_APPROVAL"))
+	return required
+}
+
+// swapTTL returns how long a swap request is open for by default, set by the SWAP_DEFAULT_TTL
+// environment variable and defaulting to a week.
+func swapTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("SWAP_DEFAULT_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultSwapTTL
+}
+
+// expireSwaps marks open swap requests which have passed their expiry as expired.
+func expireSwaps() {
+	err := initializers.DB.Model(&models.SwapRequest{}).
+		Where("status IN ? AND expires_at <= ?", openSwapStates, time.Now()).
+		Update("status", models.SwapExpired).Error
+	if err != nil {
+		log.Println("error expiring swap requests:", err)
+	}
+}
+
+// swappableEvent loads an event which is to be swapped, checking it can be.
+func swappableEvent(id uint) (models.Event, error) {
+	var event models.Event
+	if err := initializers.DB.Where("id = ?", id).First(&event).Error; err != nil {
+		return event, swapError{http.StatusNotFound, "Event not found"}
+	}
+	if isRecurring(event) {
+		return event, swapError{http.StatusBadRequest, "Recurring events can't be swapped, swap a single occurrence instead"}
+	}
+	if !event.StartDate.After(time.Now()) {
+		return event, swapError{http.StatusBadRequest, "Events which have started can't be swapped"}
+	}
+	return event, nil
+}
+
+// receivingUserAllowed reports whether the user an event is being given to may hold events,
+// and has a role which may hold events of its type.
+func receivingUserAllowed(tx *gorm.DB, event models.Event, userID uint) (bool, error) {
+	var user models.User
+	if err := tx.Where("id = ?", userID).Limit(1).Find(&user).Error; err != nil {
+		return false, err
+	}
+	if user.ID == 0 || !user.Can(models.PermEventsWrite) {
+		return false, nil
+	}
+	eventType, ok := findEventType(tx, event.Type)
+	if !ok {
+		return true, nil
+	}
+	return eventType.AllowsRole(user.Role) || user.Can(models.PermEventsAdmin), nil
+}
+
+// performSwap reassigns the events of a swap request which has been accepted, and cancels any other
+// open swap requests for them. The events are locked, and must still belong to the users they did when
+// the swap was requested, and the users receiving them must still be allowed events of their types.
+// The events mustn't break the conflict rules once they've changed hands.
+// It must be called in a transaction in which the swap request is locked.
+func performSwap(c *gin.Context, tx *gorm.DB, swap *models.SwapRequest) error {
+	// the request may have expired since expired requests were last marked
+	if !swap.ExpiresAt.After(time.Now()) {
+		return swapError{http.StatusConflict, "Swap request has expired"}
+	}
+	ids := []uint{swap.RequesterEventID}
+	if swap.RecipientEventID != nil {
+		ids = append(ids, *swap.RecipientEventID)
+	}
+	var events []models.Event
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", ids).Order("id").Find(&events).Error; err != nil {
+		return err
+	}
+	if len(events) != len(ids) {
+		return swapError{http.StatusConflict, "An event in the swap has been deleted"}
+	}
+	for _, event := range events {
+		owner := swap.RequesterID
+		if event.ID != swap.RequesterEventID {
+			owner = *swap.RecipientID
+		}
+		if event.UserID != int(owner) {
+			return swapError{http.StatusConflict, "An event in the swap has changed hands since the swap was requested"}
+		}
+	}
+
+	swapped := make([]models.Event, 0, len(events))
+	for _, event := range events {
+		reassigned := event
+		reassigned.UserID = int(*swap.RecipientID)
+		if event.ID != swap.RequesterEventID {
+			reassigned.UserID = int(swap.RequesterID)
+		}
+		allowed, err := receivingUserAllowed(tx, reassigned, uint(reassigned.UserID))
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return swapError{http.StatusForbidden, "The user receiving an event in the swap may not hold " + event.Type + " events"}
+		}
+		if err := tx.Model(&models.Event{}).Where("id = ?", event.ID).Update("user_id", reassigned.UserID).Error; err != nil {
+			return err
+		}
+		if err := recordEventChange(c, tx, audit.ActionUpdate, &event, &reassigned); err != nil {
+			return err
+		}
+		swapped = append(swapped, reassigned)
+	}
+	// checked once both events have changed hands, as in an exchange they needn't conflict with each other
+	for _, event := range swapped {
+		if err := checkConflicts(tx, event, false); err != nil {
+			return err
+		}
+	}
+
+	swap.Status = models.SwapCompleted
+	return tx.Model(&models.SwapRequest{}).
+		Where("id <> ? AND status IN ?", swap.ID, openSwapStates).
+		Where("(requester_event_id IN ? OR recipient_event_id IN ?)", ids, ids).
+		Update("status", models.SwapCancelled).Error
+}
+
+// updateSwap locks the swap request with the id in the URL, applies change to it and saves it,
+// all in a transaction. change rejects the request by returning a swapError.
+func updateSwap(c *gin.Context, change func(tx *gorm.DB, swap *models.SwapRequest, user models.User) error) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	expireSwaps()
+
+	var swap models.SwapRequest
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", c.Param("id")).First(&swap).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return swapError{http.StatusNotFound, "Swap request not found"}
+		}
+		if err != nil {
+			return err
+		}
+		if err := change(tx, &swap, user); err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Save(&swap).Error
+	})
+
+	var rejected swapError
+	if errors.As(err, &rejected) {
+		c.JSON(rejected.status, gin.H{"error": rejected.message})
+		return
+	}
+	if errors.As(err, &conflictError{}) {
+		respondConflict(c, err)
+		return
+	}
+	if err != nil {
+		log.Println("error updating swap request:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update swap request"})
+		return
+	}
+	c.JSON(http.StatusOK, swap)
+}
+
+// requireSwapStatus rejects acting on a swap request which isn't in the given state.
+func requireSwapStatus(swap *models.SwapRequest, status string) error {
+	if swap.Status != status {
+		return swapError{http.StatusConflict, "Swap request is " + swap.Status}
+	}
+	return nil
+}
+
+// POST /api/swaps
+// Propose swapping one of your events
+// With recipient_event_id the two events swap owners, with only recipient_id the recipient is asked
+// to take the event, and with neither the event is offered to anyone
+// The request expires at expires_at, or after SWAP_DEFAULT_TTL, and at the latest when an event starts
+func CreateSwap(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Validate input
+	var input NewSwapInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+
+	swap, err := newSwapRequest(input, user)
+	var rejected swapError
+	if errors.As(err, &rejected) {
+		c.JSON(rejected.status, gin.H{"error": rejected.message})
+		return
+	}
+	if err == nil {
+		err = initializers.DB.Omit(clause.Associations).Create(&swap).Error
+	}
+	if err != nil {
+		log.Println("error creating swap request:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create swap request"})
+		return
+	}
+	c.JSON(http.StatusCreated, swap)
+}
+
+// newSwapRequest validates a proposed swap and returns the request to create for it.
+func newSwapRequest(input NewSwapInput, user models.User) (models.SwapRequest, error) {
+	swap := models.SwapRequest{
+		RequesterID:      user.ID,
+		RequesterEventID: input.EventID,
+		RecipientID:      input.RecipientID,
+		RecipientEventID: input.RecipientEventID,
+		Status:           models.SwapPending,
+		Message:          input.Message,
+	}
+
+	event, err := swappableEvent(input.EventID)
+	if err != nil {
+		return swap, err
+	}
+	if event.UserID != int(user.ID) {
+		return swap, swapError{http.StatusForbidden, "You can only swap your own events"}
+	}
+	expiresAt := time.Now().Add(swapTTL())
+	if input.ExpiresAt != nil {
+		expiresAt = *input.ExpiresAt
+	}
+	if event.StartDate.Before(expiresAt) {
+		expiresAt = event.StartDate
+	}
+
+	if input.RecipientEventID != nil {
+		recipientEvent, err := swappableEvent(*input.RecipientEventID)
+		if err != nil {
+			return swap, err
+		}
+		recipientID := uint(recipientEvent.UserID)
+		if input.RecipientID != nil && *input.RecipientID != recipientID {
+			return swap, swapError{http.StatusBadRequest, "recipient_event_id doesn't belong to recipient_id"}
+		}
+		swap.RecipientID = &recipientID
+		if recipientEvent.StartDate.Before(expiresAt) {
+			expiresAt = recipientEvent.StartDate
+		}
+	}
+	if swap.RecipientID != nil {
+		if *swap.RecipientID == user.ID {
+			return swap, swapError{http.StatusBadRequest, "You can't swap with yourself"}
+		}
+		var recipient models.User
+		if err := initializers.DB.Where("id = ?", *swap.RecipientID).First(&recipient).Error; err != nil {
+			return swap, swapError{http.StatusBadRequest, "Recipient not found"}
+		}
+	}
+	if !expiresAt.After(time.Now()) {
+		return swap, swapError{http.StatusBadRequest, "expires_at must be in the future"}
+	}
+	swap.ExpiresAt = expiresAt
+
+	// an event can only be in one open swap at a time
+	ids := []uint{swap.RequesterEventID}
+	if swap.RecipientEventID != nil {
+		ids = append(ids, *swap.RecipientEventID)
+	}
+	expireSwaps()
+	var open int64
+	err = initializers.DB.Model(&models.SwapRequest{}).
+		Where("status IN ?", openSwapStates).
+		Where("(requester_event_id IN ? OR recipient_event_id IN ?)", ids, ids).
+		Count(&open).Error
+	if err != nil {
+		return swap, err
+	}
+	if open > 0 {
+		return swap, swapError{http.StatusConflict, "An event in the swap is already in an open swap request"}
+	}
+	return swap, nil
+}
+
+// POST /api/swaps/:id/accept
+// Accept a swap request made to you, or an open offer
+// The swap is carried out immediately, or once approved if SWAP_REQUIRE_APPROVAL is set
+func AcceptSwap(c *gin.Context) {
+	updateSwap(c, func(tx *gorm.DB, swap *models.SwapRequest, user models.User) error {
+		if err := requireSwapStatus(swap, models.SwapPending); err != nil {
+			return err
+		}
+		if swap.RequesterID == user.ID {
+			return swapError{http.StatusBadRequest, "You can't accept your own swap request"}
+		}
+		if swap.RecipientID != nil && *swap.RecipientID != user.ID {
+			return swapError{http.StatusForbidden, "This swap request was made to somebody else"}
+		}
+
+		now := time.Now()
+		swap.RecipientID = &user.ID
+		swap.RespondedAt = &now
+		if swapRequiresApproval() {
+			swap.Status = models.SwapAccepted
+			return nil
+		}
+		return performSwap(c, tx, swap)
+	})
+}
+
+// POST /api/swaps/:id/decline
+// Decline a swap request made to you
+func DeclineSwap(c *gin.Context) {
+	updateSwap(c, func(tx *gorm.DB, swap *models.SwapRequest, user models.User) error {
+		if err := requireSwapStatus(swap, models.SwapPending); err != nil {
+			return err
+		}
+		if swap.RecipientID == nil || *swap.RecipientID != user.ID {
+			return swapError{http.StatusForbidden, "Only the recipient can decline a swap request"}
+		}
+		now := time.Now()
+		swap.Status = models.SwapDeclined
+		swap.RespondedAt = &now
+		return nil
+	})
+}
+
+// POST /api/swaps/:id/cancel
+// Withdraw a swap request you made which hasn't been carried out yet
+func CancelSwap(c *gin.Context) {
+	updateSwap(c, func(tx *gorm.DB, swap *models.SwapRequest, user models.User) error {
+		if !swap.IsOpen() {
+			return swapError{http.StatusConflict, "Swap request is " + swap.Status}
+		}
+		if swap.RequesterID != user.ID && !can(c, models.PermEventsAdmin) {
+			return swapError{http.StatusForbidden, "Only the requester can cancel a swap request"}
+		}
+		swap.Status = models.SwapCancelled
+		return nil
+	})
+}
+
+// POST /api/swaps/:id/approve
+// Approve an accepted swap request, carrying it out
+// Managers can't approve swaps they're part of
+func ApproveSwap(c *gin.Context) {
+	updateSwap(c, func(tx *gorm.DB, swap *models.SwapRequest, user models.User) error {
+		if err := requireSwapStatus(swap, models.SwapAccepted); err != nil {
+			return err
+		}
+		if swap.RequesterID == user.ID || (swap.RecipientID != nil && *swap.RecipientID == user.ID) {
+			return swapError{http.StatusForbidden, "You can't approve your own swap"}
+		}
+		now := time.Now()
+		swap.DecidedByID = &user.ID
+		swap.DecidedAt = &now
+		return performSwap(c, tx, swap)
+	})
+}
+
+// POST /api/swaps/:id/reject
+// Refuse approval of an accepted swap request
+func RejectSwap(c *gin.Context) {
+	updateSwap(c, func(tx *gorm.DB, swap *models.SwapRequest, user models.User) error {
+		if err := requireSwapStatus(swap, models.SwapAccepted); err != nil {
+			return err
+		}
+		now := time.Now()
+		swap.Status = models.SwapRejected
+		swap.DecidedByID = &user.ID
+		swap.DecidedAt = &now
+		return nil
+	})
+}
+
+// listSwaps returns the swap requests matching query, optionally filtered by the "status" query parameter.
+func listSwaps(c *gin.Context, query *gorm.DB) {
+	expireSwaps()
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var swaps []models.SwapRequest
+	if err := query.Order("created_at DESC").Find(&swaps).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get swap requests"})
+		return
+	}
+	c.JSON(http.StatusOK, swaps)
+}
+
+// GET /api/swaps/incoming
+// Get the swap requests made to you, and pending open offers from other users
+func GetIncomingSwaps(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	listSwaps(c, initializers.DB.Where(
+		"recipient_id = ? OR (recipient_id IS NULL AND requester_id <> ? AND status = ?)",
+		user.ID, user.ID, models.SwapPending,
+	))
+}
+
+// GET /api/swaps/outgoing
+// Get the swap requests you made
+func GetOutgoingSwaps(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	listSwaps(c, initializers.DB.Where("requester_id = ?", user.ID))
+}
+
+// GET /api/swaps/approvals
+// Get the accepted swap requests waiting for a manager's approval
+func GetSwapApprovals(c *gin.Context) {
+	listSwaps(c, initializers.DB.Where("status = ?", models.SwapAccepted))
+}
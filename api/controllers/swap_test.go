@@ -0,0 +1,47 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func swapRoutes(r *gin.Engine) {
+	r.POST("/api/swaps/", CreateSwap)
+	r.POST("/api/swaps/:id/accept", AcceptSwap)
+}
+
+func TestAcceptSwapChecksReceivingUserRole(t *testing.T) {
+	setUpDB(t)
+	createEventType(t, models.EventType{Name: "DutyTech1", AllowedRoles: models.RoleEditor})
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleViewer)
+	carol := createUser(t, "carol", models.RoleEditor)
+	event := createEvent(t, alice, "DutyTech1", time.Now().Add(48*time.Hour))
+
+	w := request(testRouter(alice, swapRoutes), "POST", "/api/swaps/", gin.H{"event_id": event.ID})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var swap models.SwapRequest
+	decode(t, w, &swap)
+	accept := fmt.Sprintf("/api/swaps/%d/accept", swap.ID)
+
+	// bob's role may not hold DutyTech1 events
+	w = request(testRouter(bob, swapRoutes), "POST", accept, nil)
+	assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	var unchanged models.Event
+	require.NoError(t, initializers.DB.First(&unchanged, event.ID).Error)
+	assert.Equal(t, int(alice.ID), unchanged.UserID)
+
+	w = request(testRouter(carol, swapRoutes), "POST", accept, nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var swapped models.Event
+	require.NoError(t, initializers.DB.First(&swapped, event.ID).Error)
+	assert.Equal(t, int(carol.ID), swapped.UserID)
+}
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+)
+
+// validTimezone reports whether name is an IANA zone, e.g. Europe/London.
+func validTimezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+// timezoneFor returns the zone of a user's wall-clock times.
+func timezoneFor(user models.User) string {
+	if user.Timezone != "" {
+		return user.Timezone
+	}
+	return config.DefaultTimezone
+}
+
+// locationOf returns the zone of an event's timezone, which is DEFAULT_TIMEZONE if it's empty.
+func locationOf(timezone string) *time.Location {
+	if timezone == "" {
+		return config.DefaultLocation
+	}
+	return config.LoadLocation(timezone)
+}
+
+// eventLocation returns the zone an event's wall-clock times are in.
+func eventLocation(event models.Event) *time.Location {
+	return locationOf(event.Timezone)
+}
+
+// requestLocation returns the zone of the tz query parameter, which times without an offset are parsed in
+// and events are rendered in, defaulting to the caller's timezone.
+func requestLocation(c *gin.Context) (*time.Location, bool) {
+	if tz := c.Query("tz"); tz != "" {
+		if !validTimezone(tz) {
+			return nil, false
+		}
+		return config.LoadLocation(tz), true
+	}
+	user, _ := currentUser(c)
+	return config.LoadLocation(timezoneFor(user)), true
+}
+
+// setEventDays fixes the calendar dates of an all-day event to the dates of start and end as written, so that
+// 2024-03-31T00:00:00+01:00 is 31 March, and sets its StartDate and EndDate to the midnights at the start of its
+// first day and after its last day in its zone. An end at midnight is exclusive, and a zero start or end keeps
+// the current dates. Events which aren't all-day have no calendar dates.
+func setEventDays(event *models.Event, start time.Time, end time.Time) {
+	if !event.AllDay {
+		event.StartDay, event.EndDay = nil, nil
+		return
+	}
+	loc := eventLocation(*event)
+	length := 0
+	if event.StartDay != nil && event.EndDay != nil {
+		length = event.EndDay.DaysSince(*event.StartDay)
+	}
+
+	var startDay models.Date
+	switch {
+	case !start.IsZero():
+		startDay = models.DateOf(start)
+	case event.StartDay != nil:
+		startDay = *event.StartDay
+	default:
+		startDay = models.DateOf(event.StartDate.In(loc))
+	}
+	endDay := startDay.AddDays(length)
+	if !end.IsZero() {
+		endDay = models.DateOf(end)
+		if endDay.In(end.Location()).Equal(end) && endDay.DaysSince(startDay) > 0 {
+			endDay = endDay.AddDays(-1)
+		}
+		if endDay.DaysSince(startDay) < 0 {
+			endDay = startDay
+		}
+	}
+
+	event.StartDay, event.EndDay = &startDay, &endDay
+	event.StartDate = startDay.In(loc)
+	event.EndDate = endDay.AddDays(1).In(loc)
+}
+
+// localiseEvents renders the times of events in a zone.
+func localiseEvents(events []APIEvent, loc *time.Location) []APIEvent {
+	for i := range events {
+		events[i] = localiseEvent(events[i], loc)
+	}
+	return events
+}
+
+// localiseEvent renders the times of an event in a zone.
+func localiseEvent(event APIEvent, loc *time.Location) APIEvent {
+	event.StartDate = event.StartDate.In(loc)
+	if !event.EndDate.IsZero() {
+		event.EndDate = event.EndDate.In(loc)
+	}
+	if event.RecurrenceID != nil {
+		recurrenceID := event.RecurrenceID.In(loc)
+		event.RecurrenceID = &recurrenceID
+	}
+	return event
+}
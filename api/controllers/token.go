@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/auth"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+)
+
+// APIToken is an API token as returned by the API. Token is only set when the token is created.
+type APIToken struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	UserID     uint       `json:"user_id"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Token      string     `json:"token,omitempty"`
+}
+
+// tokenToAPIToken converts an APIToken model to the APIToken returned by the API.
+func tokenToAPIToken(token models.APIToken) APIToken {
+	return APIToken{
+		ID:         token.ID,
+		Name:       token.Name,
+		UserID:     token.UserID,
+		Prefix:     token.Prefix,
+		Scopes:     token.ScopeList(),
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+type NewTokenInput struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// GET /api/tokens
+// Get the authenticated user's API tokens
+// Users with the users:admin permission can get another user's tokens with ?user_id=
+func GetTokens(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := initializers.DB.Where("user_id = ?", user.ID)
+	if userID := c.Query("user_id"); userID != "" {
+		if !can(c, models.PermUsersAdmin) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		query = initializers.DB.Where("user_id = ?", userID)
+	}
+
+	var tokens []models.APIToken
+	if err := query.Order("created_at").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tokens"})
+		return
+	}
+	apiTokens := make([]APIToken, 0, len(tokens))
+	for _, token := range tokens {
+		apiTokens = append(apiTokens, tokenToAPIToken(token))
+	}
+	c.JSON(http.StatusOK, apiTokens)
+}
+
+// POST /api/tokens
+// Create an API token for the authenticated user
+// The token is limited to the given scopes, which must be permissions the user's role grants
+// The token itself is only returned in this response
+func CreateToken(c *gin.Context) {
+	user, ok := currentUser(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Validate input
+	var input NewTokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if len(input.Scopes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one scope is required"})
+		return
+	}
+	for _, scope := range input.Scopes {
+		if !models.ScopesAllow(models.Permissions, scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope})
+			return
+		}
+		if !user.Can(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Your role doesn't grant the scope " + scope})
+			return
+		}
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+
+	tokenString, hash, prefix, err := auth.NewAPIToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+	token := models.APIToken{
+		Name:      input.Name,
+		UserID:    user.ID,
+		Hash:      hash,
+		Prefix:    prefix,
+		Scopes:    strings.Join(input.Scopes, " "),
+		ExpiresAt: input.ExpiresAt,
+	}
+	if err := initializers.DB.Create(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	apiToken := tokenToAPIToken(token)
+	apiToken.Token = tokenString
+	c.JSON(http.StatusCreated, apiToken)
+}
+
+// DELETE /api/tokens/:id
+// Revoke an API token
+// Users may revoke their own tokens, revoking anybody else's requires the users:admin permission
+// If the token doesn't exist, or belongs to somebody else and the caller isn't an admin, return a 404 status code
+func DeleteToken(c *gin.Context) {
+	user, ok := currentUser(c)
+	admin := can(c, models.PermUsersAdmin)
+	if !admin && !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	// other users' tokens are looked up as if they don't exist, so their IDs aren't given away
+	query := initializers.DB.Where("id = ?", c.Param("id"))
+	if !admin {
+		query = query.Where("user_id = ?", user.ID)
+	}
+	var token models.APIToken
+	if err := query.First(&token).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	if err := initializers.DB.Delete(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
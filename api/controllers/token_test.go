@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tokenRoutes(r *gin.Engine) {
+	r.GET("/api/tokens/", GetTokens)
+	r.DELETE("/api/tokens/:id", DeleteToken)
+}
+
+func TestDeleteToken(t *testing.T) {
+	setUpDB(t)
+	alice := createUser(t, "alice", models.RoleEditor)
+	bob := createUser(t, "bob", models.RoleEditor)
+	admin := createUser(t, "admin", models.RoleAdmin)
+	var tokens []models.APIToken
+	for i, user := range []models.User{alice, alice, bob} {
+		token := models.APIToken{Name: "test", UserID: user.ID, Hash: fmt.Sprint(i), Scopes: models.PermEventsRead}
+		require.NoError(t, initializers.DB.Create(&token).Error)
+		tokens = append(tokens, token)
+	}
+
+	tests := []struct {
+		name   string
+		user   models.User
+		id     uint
+		status int
+	}{
+		// somebody else's token looks the same as one which doesn't exist
+		{"somebody else's token", bob, tokens[0].ID, http.StatusNotFound},
+		{"missing token", bob, 99, http.StatusNotFound},
+		{"own token", alice, tokens[0].ID, http.StatusNoContent},
+		{"somebody else's token as an admin", admin, tokens[2].ID, http.StatusNoContent},
+	}
+	for _, test := range tests {
+		w := request(testRouter(test.user, tokenRoutes), "DELETE", fmt.Sprintf("/api/tokens/%d", test.id), nil)
+		assert.Equal(t, test.status, w.Code, test.name+": "+w.Body.String())
+	}
+
+	var remaining []uint
+	require.NoError(t, initializers.DB.Model(&models.APIToken{}).Order("id").Pluck("id", &remaining).Error)
+	assert.Equal(t, []uint{tokens[1].ID}, remaining)
+
+	// only admins list other users' tokens
+	w := request(testRouter(bob, tokenRoutes), "GET", fmt.Sprintf("/api/tokens/?user_id=%d", alice.ID), nil)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = request(testRouter(admin, tokenRoutes), "GET", fmt.Sprintf("/api/tokens/?user_id=%d", alice.ID), nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []APIToken
+	decode(t, w, &listed)
+	require.Len(t, listed, 1)
+	assert.Equal(t, tokens[1].ID, listed[0].ID)
+}
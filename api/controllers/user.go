@@ -6,14 +6,20 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
+	"github.com/glssn/scheduler-api/api/auth"
 	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
 	"github.com/glssn/scheduler-api/initializers"
+	"gorm.io/gorm"
 )
 
 type APIUser struct {
 	ID       float64 `json:"id" gorm:"foreignKey:UserID;references:ID"`
 	Username string  `json:"username"`
 	Role     string  `json:"role"`
+	Region   string  `json:"region"`
+	Timezone string  `json:"timezone"`
 }
 
 // userToAPIUser converts a User struct to an APIUser struct.
@@ -54,8 +60,16 @@ func GetAllUsers(c *gin.Context) {
 	c.JSON(http.StatusOK, users)
 }
 
-// POST /api/user/:id
+type PatchUserInput struct {
+	Role     string `json:"role"`
+	Region   string `json:"region"`
+	Timezone string `json:"timezone"`
+}
+
+// PATCH /api/users/:id
 // Update a user by ID
+// Only the role, region and timezone can be changed, the role must be one of viewer, editor, admin or bot
+// When LDAP_GROUP_ROLES or OIDC_GROUP_ROLES is configured the role is replaced by the group role at the user's next login
 func UpdateUserByID(c *gin.Context) {
 	var user models.User
 
@@ -63,5 +77,277 @@ func UpdateUserByID(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found."})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"user": user})
+
+	before := user
+
+	// Validate input
+	var input PatchUserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if input.Role != "" {
+		if !models.IsValidRole(input.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role."})
+			return
+		}
+		user.Role = models.NormaliseRole(input.Role)
+	}
+	if input.Region != "" {
+		if !models.IsValidRegion(input.Region) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown region."})
+			return
+		}
+		user.Region = input.Region
+	}
+	if input.Timezone != "" {
+		if !validTimezone(input.Timezone) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+			return
+		}
+		user.Timezone = input.Timezone
+	}
+
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		return recordChange(c, tx, audit.ActionUpdate, audit.EntityUser, user.ID, before, user)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update user."})
+		return
+	}
+	c.JSON(http.StatusOK, userToAPIUser(user))
+}
+
+type NewUserInput struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+	Role     string `json:"role"`
+	Region   string `json:"region"`
+	Timezone string `json:"timezone"`
+}
+
+// POST /api/users
+// Create a local user, who logs in with a password stored by the scheduler
+// Requires the local provider to be listed in AUTH_PROVIDERS
+func CreateUser(c *gin.Context) {
+	if !config.ProviderEnabled(config.ProviderLocal) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Local users are not enabled."})
+		return
+	}
+
+	// Validate input
+	var input NewUserInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	role := defaultRole()
+	if input.Role != "" {
+		if !models.IsValidRole(input.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role."})
+			return
+		}
+		role = models.NormaliseRole(input.Role)
+	}
+	if input.Region != "" && !models.IsValidRegion(input.Region) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown region."})
+		return
+	}
+	if input.Timezone != "" && !validTimezone(input.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+	hash, err := auth.HashPassword(input.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var taken int64
+	initializers.DB.Model(&models.User{}).Where("username = ?", input.Username).Count(&taken)
+	if taken > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken."})
+		return
+	}
+
+	user := models.User{
+		Username:     input.Username,
+		Role:         role,
+		Provider:     config.ProviderLocal,
+		Subject:      input.Username,
+		PasswordHash: hash,
+		Region:       input.Region,
+		Timezone:     input.Timezone,
+	}
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return recordChange(c, tx, audit.ActionCreate, audit.EntityUser, user.ID, nil, user)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to create user."})
+		return
+	}
+	c.JSON(http.StatusCreated, userToAPIUser(user))
+}
+
+type PasswordInput struct {
+	Password        string `json:"password" binding:"required"`
+	CurrentPassword string `json:"current_password"`
+}
+
+// PUT /api/users/:id/password
+// Change a local user's password
+// Users may change their own password by giving their current password, admins may change anybody's
+func SetUserPassword(c *gin.Context) {
+	var user models.User
+
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found."})
+		return
+	}
+
+	caller, ok := currentUser(c)
+	isAdmin := can(c, models.PermUsersAdmin)
+	if !isAdmin && (!ok || caller.ID != user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+	if user.Provider != config.ProviderLocal {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only local users have passwords."})
+		return
+	}
+
+	// Validate input
+	var input PasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if !isAdmin {
+		local := auth.NewLocal(func(string) (string, error) { return user.PasswordHash, nil })
+		if _, err := local.Authenticate(user.Subject, input.CurrentPassword); err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Current password is incorrect."})
+			return
+		}
+	}
+	hash, err := auth.HashPassword(input.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+		// the hash isn't part of a user's JSON, so the change is recorded with the password redacted
+		entry, err := newAuditLog(c, requestActor(c), audit.ActionUpdate, audit.EntityUser, user.ID, user, user)
+		if err != nil {
+			return err
+		}
+		entry.Changes["password"] = audit.Change{Before: audit.Redacted, After: audit.Redacted}
+		return tx.Create(&entry).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update password."})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+type RegionInput struct {
+	Region string `json:"region" binding:"required"`
+}
+
+// PUT /api/users/:id/region
+// Declare the region whose bank holidays apply to a user, one of england-and-wales, scotland or northern-ireland
+// Users may set their own region, admins may set anybody's
+func SetUserRegion(c *gin.Context) {
+	var user models.User
+
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found."})
+		return
+	}
+
+	caller, ok := currentUser(c)
+	if !can(c, models.PermUsersAdmin) && (!ok || caller.ID != user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	// Validate input
+	var input RegionInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if !models.IsValidRegion(input.Region) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown region."})
+		return
+	}
+
+	before := user
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("region", input.Region).Error; err != nil {
+			return err
+		}
+		return recordChange(c, tx, audit.ActionUpdate, audit.EntityUser, user.ID, before, user)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update region."})
+		return
+	}
+	c.JSON(http.StatusOK, userToAPIUser(user))
+}
+
+type TimezoneInput struct {
+	Timezone string `json:"timezone" binding:"required"`
+}
+
+// PUT /api/users/:id/timezone
+// Set the IANA timezone a user's events are created and rendered in, e.g. Europe/London
+// Users may set their own timezone, admins may set anybody's
+func SetUserTimezone(c *gin.Context) {
+	var user models.User
+
+	if err := initializers.DB.Where("id = ?", c.Param("id")).First(&user).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "User not found."})
+		return
+	}
+
+	caller, ok := currentUser(c)
+	if !can(c, models.PermUsersAdmin) && (!ok || caller.ID != user.ID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	// Validate input
+	var input TimezoneInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body"})
+		return
+	}
+	if !validTimezone(input.Timezone) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown timezone."})
+		return
+	}
+
+	before := user
+	err := initializers.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("timezone", input.Timezone).Error; err != nil {
+			return err
+		}
+		return recordChange(c, tx, audit.ActionUpdate, audit.EntityUser, user.ID, before, user)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to update timezone."})
+		return
+	}
+	c.JSON(http.StatusOK, userToAPIUser(user))
 }
@@ -1,7 +1,7 @@
 package middleware
 
 import (
-	"fmt"
+	"crypto/subtle"
 	"log"
 	"net/http"
 	"os"
@@ -9,19 +9,60 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/auth"
 	"github.com/glssn/scheduler-api/api/models"
 	"github.com/glssn/scheduler-api/initializers"
-	"github.com/golang-jwt/jwt"
 )
 
-// contains checks if a string slice contains a given string
-func contains(slice []string, str string) bool {
+// lastUsedResolution is how often an API token's LastUsedAt is updated, to avoid a write on every request
+const lastUsedResolution = time.Minute
+
+// containsToken checks if a string slice contains a given token, comparing in constant time
+func containsToken(slice []string, token string) bool {
+	found := false
 	for _, s := range slice {
-		if s == str {
-			return true
+		if s != "" && subtle.ConstantTimeCompare([]byte(s), []byte(token)) == 1 {
+			found = true
 		}
 	}
-	return false
+	return found
+}
+
+// activeSessionUser returns the user of an access token, provided that neither the token nor its
+// session have been revoked.
+func activeSessionUser(sessionID uint, userID uint, jti string) (models.User, bool) {
+	var revoked int64
+	initializers.DB.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&revoked)
+	if revoked > 0 {
+		return models.User{}, false
+	}
+
+	var session models.Session
+	err := initializers.DB.Preload("User").Where("id = ? AND user_id = ?", sessionID, userID).Limit(1).Find(&session).Error
+	if err != nil || session.ID == 0 || session.User.ID == 0 || !session.IsActive(time.Now()) {
+		return models.User{}, false
+	}
+	return session.User, true
+}
+
+// findAPIToken returns the unexpired API token with the given value and its owner.
+func findAPIToken(tokenString string) (models.APIToken, bool) {
+	var token models.APIToken
+	err := initializers.DB.Preload("User").Where("hash = ?", auth.HashToken(tokenString)).Limit(1).Find(&token).Error
+	if err != nil || token.ID == 0 || token.User.ID == 0 || token.IsExpired(time.Now()) {
+		return token, false
+	}
+	return token, true
+}
+
+// allowedTokensRole returns the role of requests authenticated with one of the ALLOWED_TOKENS,
+// set by the ALLOWED_TOKENS_ROLE environment variable and defaulting to viewer.
+func allowedTokensRole() string {
+	role := models.NormaliseRole(os.Getenv("ALLOWED_TOKENS_ROLE"))
+	if !models.IsValidRole(role) {
+		return models.RoleViewer
+	}
+	return role
 }
 
 func RequireAuth(c *gin.Context) {
@@ -33,61 +74,82 @@ func RequireAuth(c *gin.Context) {
 		// Extract the token from the authorization header
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
+		// API tokens act as their owner, limited to the token's scopes
+		if auth.IsAPIToken(tokenString) {
+			token, ok := findAPIToken(tokenString)
+			if !ok {
+				log.Println("API token invalid or expired")
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+			now := time.Now()
+			if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > lastUsedResolution {
+				initializers.DB.Model(&token).UpdateColumn("last_used_at", now)
+			}
+			c.Set("user", token.User)
+			c.Set("scopes", token.ScopeList())
+			c.Set("token", token)
+			c.Next()
+			return
+		}
+
 		// Check if the token is in the list of allowed tokens
 		allowedTokens := strings.Split(os.Getenv("ALLOWED_TOKENS"), ",")
-		if contains(allowedTokens, tokenString) {
+		if containsToken(allowedTokens, tokenString) {
 			// If the token is in the list of allowed tokens, continue with the request
+			// with the role given to allowed tokens, as there's no user to take it from
 			log.Println("Request authenticated using basic auth token")
+			c.Set("role", allowedTokensRole())
 			c.Next()
 			return
 		}
 	}
 
-	// If the request is not authenticated using a Bearer token,
-	// fall back to using the JWT cookie
+	// If the request is not authenticated using an API or allowed token,
+	// fall back to an access token from the cookie or Bearer header
 
 	// Get the JWT from cookie
 	tokenStringSigned, err := c.Cookie("Authorization")
 	if err != nil || tokenStringSigned == "" {
+		tokenStringSigned = strings.TrimPrefix(authHeader, "Bearer ")
+	}
+	if tokenStringSigned == "" {
 		log.Println("Request not authenticated")
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
 	// Parse and validate the JWT
-	token, err := jwt.Parse(tokenStringSigned, func(token *jwt.Token) (interface{}, error) {
-		// Validate the alg is what we expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return []byte(os.Getenv("JWT_AUTH_SECRET_KEY")), nil
-	})
-
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		// Check JWT expiry
-		if float64(time.Now().Unix()) > claims["exp"].(float64) {
-			log.Println("JWT token expired")
-			c.AbortWithStatus(http.StatusUnauthorized)
-		}
+	claims, err := auth.ParseAccessToken(tokenStringSigned)
+	if err != nil {
+		log.Println("JWT token invalid or expired")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
 
-		// Find JWT subject in the database
-		var user models.User
-		initializers.DB.First(&user, claims["sub"])
+	// Find the JWT's user and check it hasn't been revoked
+	userID, _ := claims.UserID()
+	user, ok := activeSessionUser(claims.SessionID, userID, claims.ID)
+	if !ok {
+		log.Println("JWT token revoked")
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
 
-		if user.ID == 0 {
-			log.Println("JWT token invalid")
-			c.AbortWithStatus(http.StatusUnauthorized)
-		}
+	// Attach this user's info to request context
+	c.Set("user", user)
+	c.Set("session_id", claims.SessionID)
 
-		// Attach this user's info to request context
-		c.Set("user", user)
+	// Continue request
+	c.Next()
+}
 
-		// Continue request
-		c.Next()
-		return
-	} else {
-		c.AbortWithStatus(http.StatusUnauthorized)
+// RequireFeedAuth authenticates calendar feed requests, which come from calendar clients that
+// can't set headers or cookies. The token is read from the "token" query parameter and checked
+// the same way as a Bearer token.
+func RequireFeedAuth(c *gin.Context) {
+	if token := c.Query("token"); token != "" && c.Request.Header.Get("Authorization") == "" {
+		c.Request.Header.Set("Authorization", "Bearer "+token)
 	}
+	RequireAuth(c)
 }
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/auth"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/initializers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setUpDB points initializers.DB at an empty in-memory database for the rest of the test.
+func setUpDB(t *testing.T) {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", strings.ReplaceAll(t.Name(), "/", "_"))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(models.User{}, models.APIToken{}, models.Session{}, models.RevokedToken{}))
+
+	previous := initializers.DB
+	initializers.DB = db
+	t.Cleanup(func() {
+		initializers.DB = previous
+		sqlDB.Close()
+	})
+}
+
+// createToken saves an API token for user with scopes, returning the token.
+func createToken(t *testing.T, user models.User, scopes string, expiresAt *time.Time) string {
+	t.Helper()
+	tokenString, hash, prefix, err := auth.NewAPIToken()
+	require.NoError(t, err)
+	token := models.APIToken{Name: "test", UserID: user.ID, Hash: hash, Prefix: prefix, Scopes: scopes, ExpiresAt: expiresAt}
+	require.NoError(t, initializers.DB.Create(&token).Error)
+	return tokenString
+}
+
+// route returns the path of the route requiring permission, e.g. /events/read.
+func route(permission string) string {
+	return "/" + strings.ReplaceAll(permission, ":", "/")
+}
+
+// authRouter returns a router with a route requiring each permission, which responds with the username the request
+// was authenticated as.
+func authRouter() *gin.Engine {
+	r := gin.New()
+	for _, permission := range models.Permissions {
+		r.GET(route(permission), RequireAuth, RequirePermission(permission), func(c *gin.Context) {
+			user, _ := c.Get("user")
+			username := ""
+			if user, ok := user.(models.User); ok {
+				username = user.Username
+			}
+			c.String(http.StatusOK, username)
+		})
+	}
+	r.GET("/tokens", RequireAuth, RejectAPITokens, func(c *gin.Context) { c.Status(http.StatusOK) })
+	return r
+}
+
+// get serves a GET of target authenticated with a bearer token.
+func get(r *gin.Engine, target string, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequireAuthAPIToken(t *testing.T) {
+	setUpDB(t)
+	alice := models.User{Username: "alice", Role: models.RoleEditor}
+	require.NoError(t, initializers.DB.Create(&alice).Error)
+	expired := time.Now().Add(-time.Minute)
+	reader := createToken(t, alice, models.PermEventsRead, nil)
+	writer := createToken(t, alice, models.PermEventsRead+" "+models.PermEventsWrite+" "+models.PermEventsAdmin, nil)
+	old := createToken(t, alice, models.PermEventsRead, &expired)
+	r := authRouter()
+
+	tests := []struct {
+		name   string
+		target string
+		token  string
+		status int
+	}{
+		{"scope granted", route(models.PermEventsRead), reader, http.StatusOK},
+		{"scope not granted", route(models.PermEventsWrite), reader, http.StatusForbidden},
+		{"scope granted by the token but not the role", route(models.PermEventsAdmin), writer, http.StatusForbidden},
+		{"expired", route(models.PermEventsRead), old, http.StatusUnauthorized},
+		{"unknown", route(models.PermEventsRead), auth.APITokenPrefix + "unknown", http.StatusUnauthorized},
+		{"no token", route(models.PermEventsRead), "", http.StatusUnauthorized},
+		{"managing tokens", "/tokens", writer, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		w := get(r, test.target, test.token)
+		assert.Equal(t, test.status, w.Code, test.name)
+		if w.Code == http.StatusOK {
+			assert.Equal(t, "alice", w.Body.String(), test.name)
+		}
+	}
+
+	var used models.APIToken
+	require.NoError(t, initializers.DB.Where("hash = ?", auth.HashToken(reader)).First(&used).Error)
+	assert.NotNil(t, used.LastUsedAt)
+}
+
+func TestRequireAuthAllowedTokens(t *testing.T) {
+	setUpDB(t)
+	t.Setenv("ALLOWED_TOKENS", "first,,second")
+	r := authRouter()
+
+	// allowed tokens are viewers unless ALLOWED_TOKENS_ROLE says otherwise
+	t.Setenv("ALLOWED_TOKENS_ROLE", "")
+	assert.Equal(t, http.StatusOK, get(r, route(models.PermEventsRead), "second").Code)
+	assert.Equal(t, http.StatusForbidden, get(r, route(models.PermEventsWrite), "first").Code)
+	t.Setenv("ALLOWED_TOKENS_ROLE", "Editor")
+	assert.Equal(t, http.StatusOK, get(r, route(models.PermEventsWrite), "first").Code)
+	assert.Equal(t, http.StatusOK, get(r, "/tokens", "first").Code)
+	t.Setenv("ALLOWED_TOKENS_ROLE", "superuser")
+	assert.Equal(t, http.StatusForbidden, get(r, route(models.PermEventsWrite), "first").Code)
+
+	// other tokens, including the empty entry, aren't allowed
+	assert.Equal(t, http.StatusUnauthorized, get(r, route(models.PermEventsRead), "third").Code)
+	assert.Equal(t, http.StatusUnauthorized, get(r, route(models.PermEventsRead), "first,second").Code)
+	assert.Equal(t, http.StatusUnauthorized, get(r, route(models.PermEventsRead), "").Code)
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+)
+
+// requestRole returns the role of the authenticated caller: the role of the user attached to the
+// context, or for requests authenticated without a user, the role set by RequireAuth.
+func requestRole(c *gin.Context) string {
+	if user, ok := c.Get("user"); ok {
+		if user, ok := user.(models.User); ok {
+			return user.Role
+		}
+	}
+	return c.GetString("role")
+}
+
+// scopesAllow reports whether the scopes of the API token the request was authenticated with
+// include permission. Requests authenticated without an API token aren't limited by scopes.
+func scopesAllow(c *gin.Context, permission string) bool {
+	scopes, ok := c.Get("scopes")
+	return !ok || models.ScopesAllow(scopes.([]string), permission)
+}
+
+// RequirePermission returns a middleware which only allows the request to continue
+// if the caller's role, and the scopes of their API token if they used one, grant permission.
+// It must run after RequireAuth.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.RoleHasPermission(requestRole(c), permission) || !scopesAllow(c, permission) {
+			log.Println("Request forbidden, missing permission", permission)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RejectAPITokens returns a 403 status code to requests authenticated with an API token, for the routes managing
+// API tokens. Whatever its scopes, a token could otherwise revoke its owner's other tokens, or mint tokens with
+// a later expiry than its own.
+// It must run after RequireAuth.
+func RejectAPITokens(c *gin.Context) {
+	if _, usedToken := c.Get("token"); usedToken {
+		log.Println("Request forbidden, API tokens can't manage tokens")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API tokens can't be used to manage API tokens"})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// serve returns the status of a request to a route requiring permission, after authenticate has run.
+func serve(authenticate gin.HandlerFunc, permission string) int {
+	r := gin.New()
+	r.GET("/", authenticate, RequirePermission(permission), func(c *gin.Context) { c.Status(http.StatusOK) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	return w.Code
+}
+
+func TestRequirePermission(t *testing.T) {
+	// the permissions each role is expected to grant, everything else is forbidden
+	granted := map[string][]string{
+		models.RoleViewer: {models.PermEventsRead, models.PermUsersRead},
+		models.RoleEditor: {models.PermEventsRead, models.PermEventsWrite, models.PermUsersRead},
+		models.RoleBot:    {models.PermEventsRead, models.PermEventsWrite},
+		models.RoleAdmin:  models.Permissions,
+		"":                nil,
+		"superuser":       nil,
+	}
+	for role, permissions := range granted {
+		for _, permission := range models.Permissions {
+			want := http.StatusForbidden
+			for _, grant := range permissions {
+				if grant == permission {
+					want = http.StatusOK
+				}
+			}
+
+			asUser := func(c *gin.Context) { c.Set("user", models.User{Username: "alice", Role: role}) }
+			assert.Equal(t, want, serve(asUser, permission), "user with role %q, %s", role, permission)
+			// requests without a user, authenticated with an allowed token, have the role set by RequireAuth
+			asRole := func(c *gin.Context) { c.Set("role", role) }
+			assert.Equal(t, want, serve(asRole, permission), "role %q, %s", role, permission)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/glssn/scheduler-api/api/audit"
+)
+
+// RequestIDHeader is the header a request's ID is read from and returned in
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID gives every request an ID, which is returned in the X-Request-ID header and recorded in the
+// audit log against the changes the request makes. A valid ID sent by the client in the same header is kept,
+// so that changes can be traced back to the client's own logs.
+func RequestID(c *gin.Context) {
+	id := c.GetHeader(RequestIDHeader)
+	if !audit.ValidRequestID(id) {
+		id = audit.NewRequestID()
+	}
+	c.Set("request_id", id)
+	c.Header(RequestIDHeader, id)
+	c.Next()
+}
@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// API token belonging to a user, for authenticating scripts and integrations.
+// Only a hash of the token is stored, deleting the row revokes the token.
+type APIToken struct {
+	gorm.Model
+	Name   string
+	User   User
+	UserID uint `gorm:"index"`
+	// Hash is the hex SHA-256 hash of the token
+	Hash string `gorm:"uniqueIndex"`
+	// Prefix is the start of the token, to help users recognise it
+	Prefix string
+	// Scopes is a space-separated list of the permissions the token is limited to
+	Scopes     string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// ScopeList returns the permissions the token is limited to.
+func (token APIToken) ScopeList() []string {
+	return strings.Fields(token.Scopes)
+}
+
+// IsExpired reports whether the token has passed its expiry time.
+func (token APIToken) IsExpired(now time.Time) bool {
+	return token.ExpiresAt != nil && !now.Before(*token.ExpiresAt)
+}
+
+// ScopesAllow reports whether permission is one of scopes.
+func ScopesAllow(scopes []string, permission string) bool {
+	for _, scope := range scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"github.com/glssn/scheduler-api/api/audit"
+)
+
+// auditIgnored are the fields left out of audited changes: the update time, which changes with everything
+// else, and associations, whose changes are recorded against their own entities
+var auditIgnored = []string{"UpdatedAt", "User", "Event"}
+
+// Record of a change to an event or user: who made it, in which request, and what it changed.
+// Audit logs are never updated or deleted.
+type AuditLog struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	// ActorID is the user who made the change, nil for requests authenticated with an allowed token
+	ActorID *uint  `gorm:"index" json:"actor_id"`
+	Actor   string `json:"actor"`
+	// Action is create, update or delete
+	Action string `gorm:"index" json:"action"`
+	// Entity is the kind of thing changed, event or user, and EntityID its ID
+	Entity   string `gorm:"index:idx_audit_logs_entity" json:"entity"`
+	EntityID uint   `gorm:"index:idx_audit_logs_entity" json:"entity_id"`
+	// RequestID is the ID of the API request, or of the bank holiday sync, which made the change
+	RequestID string `gorm:"index" json:"request_id"`
+	// Changes holds the before and after values of the fields which changed
+	Changes audit.Changes `gorm:"type:jsonb;serializer:json" json:"changes"`
+}
+
+// NewAuditLog returns the record of actor changing an entity from before to after, which are nil
+// for a create and a delete respectively.
+func NewAuditLog(actor *User, requestID string, action string, entity string, entityID uint, before interface{}, after interface{}) (AuditLog, error) {
+	changes, err := audit.Diff(before, after, auditIgnored...)
+	if err != nil {
+		return AuditLog{}, err
+	}
+	log := AuditLog{
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		RequestID: requestID,
+		Changes:   changes,
+	}
+	if actor != nil {
+		id := actor.ID
+		log.ActorID, log.Actor = &id, actor.Username
+	}
+	return log, nil
+}
@@ -0,0 +1,93 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DateFormat is the layout of a Date, e.g. 2024-12-25
+const DateFormat = "2006-01-02"
+
+// Calendar date without a time of day or a zone, such as the day of an all-day event.
+// It's stored as a Postgres date and marshalled as 2006-01-02.
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// DateOf returns the date of t in its location.
+func DateOf(t time.Time) Date {
+	year, month, day := t.Date()
+	return Date{Year: year, Month: month, Day: day}
+}
+
+// ParseDate parses a date formatted as 2006-01-02.
+func ParseDate(value string) (Date, error) {
+	t, err := time.Parse(DateFormat, value)
+	if err != nil {
+		return Date{}, err
+	}
+	return DateOf(t), nil
+}
+
+// In returns the midnight at the start of the date in loc.
+func (date Date) In(loc *time.Location) time.Time {
+	return time.Date(date.Year, date.Month, date.Day, 0, 0, 0, 0, loc)
+}
+
+// AddDays returns the date n days later.
+func (date Date) AddDays(n int) Date {
+	return DateOf(date.In(time.UTC).AddDate(0, 0, n))
+}
+
+// DaysSince returns the number of days from other to the date.
+func (date Date) DaysSince(other Date) int {
+	return int(date.In(time.UTC).Sub(other.In(time.UTC)).Hours() / 24)
+}
+
+func (date Date) String() string {
+	return date.In(time.UTC).Format(DateFormat)
+}
+
+func (date Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(date.String())
+}
+
+func (date *Date) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := ParseDate(value)
+	if err != nil {
+		return err
+	}
+	*date = parsed
+	return nil
+}
+
+// Scan reads a date column.
+func (date *Date) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case time.Time:
+		*date = DateOf(value)
+		return nil
+	case string:
+		parsed, err := ParseDate(value)
+		*date = parsed
+		return err
+	case []byte:
+		parsed, err := ParseDate(string(value))
+		*date = parsed
+		return err
+	}
+	return fmt.Errorf("cannot scan %T into a Date", value)
+}
+
+// Value writes the date as 2006-01-02.
+func (date Date) Value() (driver.Value, error) {
+	return date.String(), nil
+}
@@ -12,19 +12,49 @@ type Event struct {
 	Type              string
 	Title             string
 	StartDate         time.Time `json:"start_date"`
-	EndDate           time.Time `gorm:"type:TIMESTAMP" json:"end_date"`
+	EndDate           time.Time `gorm:"type:TIMESTAMPTZ" json:"end_date"`
 	AllDay            bool      `gorm:"default:true" json:"all_day"`
 	RecurringType     string    `json:"recurring_type"`
 	RecurringInterval uint32    `json:"recurring_interval"`
-	User              User
-	UserID            int `json:"user_id"`
+	// RRule is an RFC 5545 recurrence rule, with any EXDATEs on following lines.
+	// When set it takes precedence over RecurringType and RecurringInterval.
+	RRule string `gorm:"column:rrule" json:"rrule"`
+	// ParentID and RecurrenceID are set on override rows which replace a single occurrence of a
+	// recurring event, identified by the occurrence's original start. The parent carries an EXDATE for it.
+	ParentID     *uint      `gorm:"index" json:"parent_id"`
+	RecurrenceID *time.Time `json:"recurrence_id"`
+	// ExternalID identifies the event in an external calendar it was imported from, e.g. an iCalendar UID
+	ExternalID string `gorm:"index" json:"external_id"`
+	// Region is the region a bank holiday applies to, empty for events which apply everywhere
+	Region string `gorm:"index" json:"region"`
+	// Timezone is the IANA zone of the event's wall-clock times, e.g. Europe/London, which the occurrences of
+	// recurring events keep across DST changes. Empty means DEFAULT_TIMEZONE.
+	Timezone string `json:"timezone"`
+	// StartDay and EndDay are the first and last calendar dates of an all-day event. Its StartDate and EndDate
+	// are the midnights at the start of StartDay and after EndDay in its Timezone.
+	StartDay *Date `gorm:"type:date" json:"start_day"`
+	EndDay   *Date `gorm:"type:date" json:"end_day"`
+	// Notes and Bunting are set on bank holidays, e.g. "Substitute day", and whether they're celebrated with bunting
+	Notes   string `json:"notes"`
+	Bunting bool   `json:"bunting"`
+	User    User
+	UserID  int `json:"user_id"`
 }
 
-// Typical event metadata object, referring to an Event
+// Typical event metadata object, referring to an Event.
+// Holds the parsed recurrence rule of a recurring event.
 type EventMeta struct {
 	gorm.Model
-	EventID           int
+	EventID           int `gorm:"uniqueIndex"`
 	Event             Event
 	RecurringStart    uint64
 	RecurringInterval uint32
+	Freq              string
+	Interval          int
+	ByDay             string
+	ByMonthDay        string
+	ByMonth           string
+	Count             int
+	Until             *time.Time
+	ExDates           string
 }
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Event types which are always registered
+const (
+	EventTypeBankHoliday = "bank_holiday"
+	EventTypeLeave       = "leave"
+)
+
+// Registered type of event, describing what an Event.Type means and how events of it are validated.
+type EventType struct {
+	gorm.Model
+	// Name is the value of Event.Type, e.g. DutyTech1. Names of deleted types can be reused.
+	Name  string `gorm:"uniqueIndex:idx_event_types_name,where:deleted_at IS NULL"`
+	Label string
+	// Colour is a hex colour for displaying events of the type, e.g. #1f77b4
+	Colour string
+	// AllDayOnly rejects events of the type which aren't all-day
+	AllDayOnly bool
+	// AllowedRoles is a space-separated list of the roles which may create events of the type, empty allows any role
+	AllowedRoles string
+	// MaxDurationHours is the longest an event of the type may last, 0 for no limit
+	MaxDurationHours int
+	// ExclusivePerDay allows only one event of the type on each day, whoever it belongs to
+	ExclusivePerDay bool
+}
+
+// RoleList returns the roles which may create events of the type.
+func (eventType EventType) RoleList() []string {
+	return strings.Fields(eventType.AllowedRoles)
+}
+
+// AllowsRole reports whether a role may create events of the type.
+func (eventType EventType) AllowsRole(role string) bool {
+	roles := eventType.RoleList()
+	if len(roles) == 0 {
+		return true
+	}
+	for _, allowed := range roles {
+		if allowed == NormaliseRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxDuration returns the longest an event of the type may last, 0 for no limit.
+func (eventType EventType) MaxDuration() time.Duration {
+	return time.Duration(eventType.MaxDurationHours) * time.Hour
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// VersionBaseline is the action of the first version of an event changed before its history was kept,
+// recording how it was before that change
+const VersionBaseline = "baseline"
+
+// Snapshot of an event after a change, so that it can be restored. Versions are numbered from 1 for each event,
+// and the version of a delete is the event as it was when it was deleted.
+type EventVersion struct {
+	ID        uint      `gorm:"primarykey" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	EventID   uint      `gorm:"uniqueIndex:idx_event_versions_event_version" json:"event_id"`
+	Version   int       `gorm:"uniqueIndex:idx_event_versions_event_version" json:"version"`
+	// Action is the audit action which made the version, or baseline
+	Action string `json:"action"`
+	// ActorID is the user who made the change, nil for requests authenticated with an allowed token
+	ActorID   *uint  `json:"actor_id"`
+	Actor     string `json:"actor"`
+	RequestID string `gorm:"index" json:"request_id"`
+	Snapshot  Event  `gorm:"type:jsonb;serializer:json" json:"event"`
+}
+
+// NewEventVersions returns the versions recording a change to an event from before to after, which are nil
+// for a create and a delete respectively, following the latest version of the event.
+// An event without versions which already existed gets a baseline version of how it was before the change.
+func NewEventVersions(latest int, action string, before *Event, after *Event, actor *User, requestID string) []EventVersion {
+	snapshot := after
+	if snapshot == nil {
+		snapshot = before
+	}
+	versions := make([]EventVersion, 0, 2)
+	add := func(action string, event Event) {
+		// the owner is kept as user_id, without the rest of the user
+		event.User = User{}
+		event.DeletedAt = gorm.DeletedAt{}
+		versions = append(versions, EventVersion{
+			EventID:  snapshot.ID,
+			Version:  latest + len(versions) + 1,
+			Action:   action,
+			Snapshot: event,
+		})
+	}
+	// nobody is known to have made the baseline
+	if latest == 0 && before != nil && after != nil {
+		add(VersionBaseline, *before)
+	}
+	add(action, *snapshot)
+	version := &versions[len(versions)-1]
+	version.RequestID = requestID
+	if actor != nil {
+		id := actor.ID
+		version.ActorID, version.Actor = &id, actor.Username
+	}
+	return versions
+}
@@ -0,0 +1,25 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Record of a synchronisation of bank holidays with the configured holiday providers.
+type HolidaySyncRun struct {
+	gorm.Model
+	// Source is the name of the provider the holidays were retrieved from, empty if every provider failed
+	Source     string    `json:"source"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Retrieved  int       `json:"retrieved"`
+	Created    int       `json:"created"`
+	Updated    int       `json:"updated"`
+	Deleted    int       `json:"deleted"`
+	Unchanged  int       `json:"unchanged"`
+	// Error is why the run failed, in which case nothing was changed
+	Error string `json:"error"`
+	// RequestID is the request ID the run's changes are recorded under in the audit log
+	RequestID string `json:"request_id"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// Regions whose public holidays are synchronised, named after the gov.uk divisions
+const (
+	RegionEnglandAndWales = "england-and-wales"
+	RegionScotland        = "scotland"
+	RegionNorthernIreland = "northern-ireland"
+)
+
+// Regions lists every region
+var Regions = []string{RegionEnglandAndWales, RegionScotland, RegionNorthernIreland}
+
+// IsValidRegion reports whether region is a known region.
+func IsValidRegion(region string) bool {
+	for _, known := range Regions {
+		if region == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Login session of a user, from logging in until logging out or expiring.
+// Access tokens issued for the session carry its ID and stop working when it is revoked.
+type Session struct {
+	gorm.Model
+	User       User
+	UserID     uint `gorm:"index"`
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+	LastUsedAt *time.Time
+	UserAgent  string
+	IPAddress  string
+}
+
+// IsActive reports whether the session can still be used.
+func (session Session) IsActive(now time.Time) bool {
+	return session.RevokedAt == nil && now.Before(session.ExpiresAt)
+}
+
+// Refresh token of a session, exchanged for a new access token and a new refresh token.
+// Each refresh token can only be used once: presenting a used one again means it was stolen,
+// and the session is revoked.
+type RefreshToken struct {
+	gorm.Model
+	Session   Session
+	SessionID uint `gorm:"index"`
+	// Hash is the hex SHA-256 hash of the token
+	Hash   string `gorm:"uniqueIndex"`
+	UsedAt *time.Time
+}
+
+// Access token ID (jti) revoked before its expiry, e.g. by logging out.
+// Rows can be removed once the token would have expired anyway.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// States of a swap request
+const (
+	// SwapPending is waiting for the recipient, or anybody for an open offer, to accept
+	SwapPending = "pending"
+	// SwapAccepted has been accepted and is waiting for a manager's approval
+	SwapAccepted = "accepted"
+	// SwapCompleted has been carried out, the events have changed hands
+	SwapCompleted = "completed"
+	// SwapDeclined was declined by the recipient
+	SwapDeclined = "declined"
+	// SwapRejected was refused approval by a manager
+	SwapRejected = "rejected"
+	// SwapCancelled was withdrawn by the requester, or one of its events changed hands in another swap
+	SwapCancelled = "cancelled"
+	// SwapExpired wasn't completed before its expiry
+	SwapExpired = "expired"
+)
+
+// Request to swap an event with another user.
+// With a RecipientEventID the two events swap owners. Without one the recipient takes the requester's
+// event, and without a RecipientID the event is offered to anyone who accepts it.
+type SwapRequest struct {
+	gorm.Model
+	Requester        User      `json:"-"`
+	RequesterID      uint      `gorm:"index" json:"requester_id"`
+	RequesterEvent   Event     `json:"-"`
+	RequesterEventID uint      `gorm:"index" json:"requester_event_id"`
+	Recipient        *User     `json:"-"`
+	RecipientID      *uint     `gorm:"index" json:"recipient_id"`
+	RecipientEvent   *Event    `json:"-"`
+	RecipientEventID *uint     `gorm:"index" json:"recipient_event_id"`
+	Status           string    `gorm:"index" json:"status"`
+	Message          string    `json:"message"`
+	ExpiresAt        time.Time `json:"expires_at"`
+	// RespondedAt is when the recipient accepted or declined
+	RespondedAt *time.Time `json:"responded_at"`
+	// DecidedByID is the manager who approved or rejected the swap
+	DecidedByID *uint      `json:"decided_by_id"`
+	DecidedAt   *time.Time `json:"decided_at"`
+}
+
+// IsOpen reports whether the swap can still be acted on.
+func (swap SwapRequest) IsOpen() bool {
+	return swap.Status == SwapPending || swap.Status == SwapAccepted
+}
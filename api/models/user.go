@@ -1,10 +1,106 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Roles a user can have
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+	RoleBot    = "bot"
+)
+
+// Permissions granted to roles
+const (
+	// PermEventsRead allows reading events
+	PermEventsRead = "events:read"
+	// PermEventsWrite allows creating events and modifying your own events
+	PermEventsWrite = "events:write"
+	// PermEventsAdmin allows modifying anybody's events
+	PermEventsAdmin = "events:admin"
+	// PermUsersRead allows reading users
+	PermUsersRead = "users:read"
+	// PermUsersAdmin allows modifying users, e.g. changing their role
+	PermUsersAdmin = "users:admin"
+	// PermAuditRead allows reading the audit log of changes to events and users
+	PermAuditRead = "audit:read"
+)
+
+// Permissions lists every permission
+var Permissions = []string{PermEventsRead, PermEventsWrite, PermEventsAdmin, PermUsersRead, PermUsersAdmin, PermAuditRead}
+
+// rolePermissions maps each role onto the permissions it grants
+var rolePermissions = map[string][]string{
+	RoleViewer: {PermEventsRead, PermUsersRead},
+	RoleEditor: {PermEventsRead, PermEventsWrite, PermUsersRead},
+	RoleAdmin:  {PermEventsRead, PermEventsWrite, PermEventsAdmin, PermUsersRead, PermUsersAdmin, PermAuditRead},
+	RoleBot:    {PermEventsRead, PermEventsWrite},
+}
+
+// roleRanks orders roles from least to most privileged, for choosing between roles
+var roleRanks = map[string]int{
+	RoleViewer: 1,
+	RoleBot:    2,
+	RoleEditor: 3,
+	RoleAdmin:  4,
+}
 
 // Typical user model
 type User struct {
 	gorm.Model
 	Username string
 	Role     string
+	// Provider and Subject identify the user within the authentication provider they sign in with.
+	// Users from before providers were recorded have neither until they next log in.
+	Provider string `gorm:"uniqueIndex:idx_users_provider_subject,where:subject <> ''" json:"provider"`
+	Subject  string `gorm:"uniqueIndex:idx_users_provider_subject,where:subject <> ''" json:"subject"`
+	// PasswordHash is the bcrypt hash of a local user's password
+	PasswordHash string `json:"-"`
+	// Region decides whose bank holidays apply to the user, empty for DEFAULT_REGION
+	Region string `json:"region"`
+	// Timezone is the IANA zone the user's events are created and rendered in, empty for DEFAULT_TIMEZONE
+	Timezone string `json:"timezone"`
+}
+
+// NormaliseRole returns the canonical form of a role, e.g. "Viewer" becomes "viewer".
+func NormaliseRole(role string) string {
+	return strings.ToLower(strings.TrimSpace(role))
+}
+
+// IsValidRole reports whether role is a known role.
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[NormaliseRole(role)]
+	return ok
+}
+
+// RoleHasPermission reports whether role grants permission.
+func RoleHasPermission(role string, permission string) bool {
+	for _, granted := range rolePermissions[NormaliseRole(role)] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HighestRole returns the most privileged of roles, ignoring unknown roles.
+// It returns an empty string if none of the roles are known.
+func HighestRole(roles ...string) string {
+	highest := ""
+	for _, role := range roles {
+		role = NormaliseRole(role)
+		if roleRanks[role] > roleRanks[highest] {
+			highest = role
+		}
+	}
+	return highest
+}
+
+// Can reports whether the user's role grants permission.
+func (user User) Can(permission string) bool {
+	return RoleHasPermission(user.Role, permission)
 }
@@ -4,35 +4,108 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/glssn/scheduler-api/api/controllers"
 	"github.com/glssn/scheduler-api/api/middleware"
+	"github.com/glssn/scheduler-api/api/models"
 )
 
 func Routes(app *gin.Engine) {
+	// Every request is given an ID, which changes are recorded against in the audit log
+	app.Use(middleware.RequestID)
+
 	// Event endpoints
 	events := app.Group("/api/events")
 	events.Use(middleware.RequireAuth)
-	events.GET("/all", controllers.FindEvents)
+	events.GET("/all", middleware.RequirePermission(models.PermEventsRead), controllers.FindEvents)
 	// events.GET("/:id", controllers.GetEvent)
-	events.GET("/", controllers.GetEvent)
+	events.GET("/", middleware.RequirePermission(models.PermEventsRead), controllers.GetEvent)
+	events.GET("/trash", middleware.RequirePermission(models.PermEventsWrite), controllers.GetTrash)
+	events.GET("/:id/history", middleware.RequirePermission(models.PermEventsRead), controllers.GetEventHistory)
 	// events.GET("/by-id/:id", controllers.GetEventById)
 	// events.GET("/by-type/:type", controllers.GetEventByType)
 	// events.GET("/by-date/:date", controllers.GetEventByDate)
 	// events.GET("/by-date/:start_date", controllers.GetEventByDateRange)
 	// events.GET("?start_date=:end_date", controllers.GetEvent)
-	events.POST("/", controllers.CreateEvent)
-	events.PATCH("/:id", controllers.UpdateEvent)
-	events.DELETE("/:id", controllers.DeleteEvent)
+	events.POST("/", middleware.RequirePermission(models.PermEventsWrite), controllers.CreateEvent)
+	events.POST("/import", middleware.RequirePermission(models.PermEventsWrite), controllers.ImportEvents)
+	events.PATCH("/:id", middleware.RequirePermission(models.PermEventsWrite), controllers.UpdateEvent)
+	events.DELETE("/:id", middleware.RequirePermission(models.PermEventsWrite), controllers.DeleteEvent)
+	events.POST("/:id/restore", middleware.RequirePermission(models.PermEventsWrite), controllers.RestoreEvent)
+
+	// Event type endpoints
+	eventTypes := app.Group("/api/event-types")
+	eventTypes.Use(middleware.RequireAuth)
+	eventTypes.GET("/", middleware.RequirePermission(models.PermEventsRead), controllers.GetEventTypes)
+	eventTypes.GET("/:id", middleware.RequirePermission(models.PermEventsRead), controllers.GetEventType)
+	eventTypes.POST("/", middleware.RequirePermission(models.PermEventsAdmin), controllers.CreateEventType)
+	eventTypes.PATCH("/:id", middleware.RequirePermission(models.PermEventsAdmin), controllers.UpdateEventType)
+	eventTypes.DELETE("/:id", middleware.RequirePermission(models.PermEventsAdmin), controllers.DeleteEventType)
 
 	// Auth endpoints
 	auth := app.Group("/")
 	auth.POST("/login", controllers.Login)
 	auth.GET("/validate", middleware.RequireAuth, controllers.Validate)
 	auth.POST("/logout", controllers.Logout)
+	auth.POST("/token/refresh", controllers.RefreshSession)
+	auth.GET("/.well-known/jwks.json", controllers.GetJWKS)
+	auth.GET("/auth/oidc/login", controllers.OIDCLogin)
+	auth.GET("/auth/oidc/callback", controllers.OIDCCallback)
 
 	// User endpoints
 	users := app.Group("/api/users")
 	users.Use(middleware.RequireAuth)
-	users.GET("/all", controllers.GetAllUsers)
-	users.GET("/:id", controllers.GetUserByID)
+	users.GET("/all", middleware.RequirePermission(models.PermUsersRead), controllers.GetAllUsers)
+	users.GET("/:id", middleware.RequirePermission(models.PermUsersRead), controllers.GetUserByID)
+	users.POST("/", middleware.RequirePermission(models.PermUsersAdmin), controllers.CreateUser)
+	users.PATCH("/:id", middleware.RequirePermission(models.PermUsersAdmin), controllers.UpdateUserByID)
+	users.PUT("/:id/password", controllers.SetUserPassword)
+	users.PUT("/:id/region", controllers.SetUserRegion)
+	users.PUT("/:id/timezone", controllers.SetUserTimezone)
+	users.DELETE("/:id/sessions", middleware.RequirePermission(models.PermUsersAdmin), controllers.DeleteUserSessions)
+
+	// Swap request endpoints
+	swaps := app.Group("/api/swaps")
+	swaps.Use(middleware.RequireAuth)
+	swaps.GET("/incoming", middleware.RequirePermission(models.PermEventsRead), controllers.GetIncomingSwaps)
+	swaps.GET("/outgoing", middleware.RequirePermission(models.PermEventsRead), controllers.GetOutgoingSwaps)
+	swaps.GET("/approvals", middleware.RequirePermission(models.PermEventsAdmin), controllers.GetSwapApprovals)
+	swaps.POST("/", middleware.RequirePermission(models.PermEventsWrite), controllers.CreateSwap)
+	swaps.POST("/:id/accept", middleware.RequirePermission(models.PermEventsWrite), controllers.AcceptSwap)
+	swaps.POST("/:id/decline", middleware.RequirePermission(models.PermEventsWrite), controllers.DeclineSwap)
+	swaps.POST("/:id/cancel", middleware.RequirePermission(models.PermEventsWrite), controllers.CancelSwap)
+	swaps.POST("/:id/approve", middleware.RequirePermission(models.PermEventsAdmin), controllers.ApproveSwap)
+	swaps.POST("/:id/reject", middleware.RequirePermission(models.PermEventsAdmin), controllers.RejectSwap)
+
+	// Rota endpoints
+	rotas := app.Group("/api/rotas")
+	rotas.Use(middleware.RequireAuth)
+	rotas.POST("/generate", middleware.RequirePermission(models.PermEventsAdmin), controllers.GenerateRota)
+
+	// Holiday endpoints
+	holidays := app.Group("/api/holidays")
+	holidays.Use(middleware.RequireAuth)
+	holidays.GET("/", middleware.RequirePermission(models.PermEventsRead), controllers.GetHolidays)
+
+	// Report endpoints
+	reports := app.Group("/api/reports")
+	reports.Use(middleware.RequireAuth)
+	reports.GET("/coverage", middleware.RequirePermission(models.PermEventsRead), controllers.GetCoverageReport)
+
+	// Audit log endpoints
+	audit := app.Group("/api/audit")
+	audit.Use(middleware.RequireAuth)
+	audit.GET("/", middleware.RequirePermission(models.PermAuditRead), controllers.GetAuditLogs)
+
+	// API token endpoints, which API tokens themselves can't use
+	tokens := app.Group("/api/tokens")
+	tokens.Use(middleware.RequireAuth, middleware.RejectAPITokens)
+	tokens.GET("/", controllers.GetTokens)
+	tokens.POST("/", controllers.CreateToken)
+	tokens.DELETE("/:id", controllers.DeleteToken)
+
+	// iCalendar feed endpoints
+	calendar := app.Group("/api/calendar")
+	calendar.Use(middleware.RequireFeedAuth, middleware.RequirePermission(models.PermEventsRead))
+	calendar.GET("/:file", controllers.GetUserCalendar)
+	calendar.GET("/type/:file", controllers.GetTypeCalendar)
 
 	// User/event endpoints
 	userevents := app.Group("/api/events/user")
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// Authentication providers
+const (
+	// ProviderLDAP checks usernames and passwords against an LDAP directory
+	ProviderLDAP = "ldap"
+	// ProviderLocal checks usernames and passwords against bcrypt hashes stored with users
+	ProviderLocal = "local"
+	// ProviderOIDC signs users in with an OpenID Connect provider using the authorization code flow
+	ProviderOIDC = "oidc"
+)
+
+// OIDCConfig holds the settings of the OpenID Connect provider users can sign in with.
+type OIDCConfig struct {
+	// IssuerURL is the issuer identifier, from which the provider's configuration is discovered
+	IssuerURL string
+	// ClientID and ClientSecret are the credentials of the scheduler's client registration
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the address of the callback endpoint, e.g. "https://scheduler.example.com/auth/oidc/callback"
+	RedirectURL string
+	// Scopes are requested in addition to "openid"
+	Scopes []string
+	// UsernameClaim is the ID token claim used as the username, e.g. "preferred_username" or "email"
+	UsernameClaim string
+	// GroupsClaim is the ID token claim listing the user's groups
+	GroupsClaim string
+	// GroupRoles maps lower case group names onto the role given to their members
+	GroupRoles map[string]string
+	// PostLoginURL is where users are redirected after signing in. The user is returned as JSON if it is empty.
+	PostLoginURL string
+}
+
+// AuthProviders lists the enabled authentication providers, in the order they're tried
+var AuthProviders []string
+
+// OIDC holds the OpenID Connect settings loaded by LoadEnvVariables
+var OIDC OIDCConfig
+
+// LoadAuthProviders reads the comma-separated AUTH_PROVIDERS environment variable, defaulting to ldap.
+func LoadAuthProviders() []string {
+	var providers []string
+	for _, provider := range strings.Split(getEnv("AUTH_PROVIDERS", ProviderLDAP), ",") {
+		provider = strings.ToLower(strings.TrimSpace(provider))
+		switch provider {
+		case "":
+			continue
+		case ProviderLDAP, ProviderLocal, ProviderOIDC:
+			providers = append(providers, provider)
+		default:
+			log.Fatalf("AUTH_PROVIDERS contains unknown provider %q, expected %s, %s or %s",
+				provider, ProviderLDAP, ProviderLocal, ProviderOIDC)
+		}
+	}
+	return providers
+}
+
+// LoadOIDCConfig reads the OpenID Connect settings from the environment.
+func LoadOIDCConfig() OIDCConfig {
+	return OIDCConfig{
+		IssuerURL:     strings.TrimSuffix(os.Getenv("OIDC_ISSUER_URL"), "/"),
+		ClientID:      os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret:  os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:        strings.Fields(getEnv("OIDC_SCOPES", "profile email")),
+		UsernameClaim: getEnv("OIDC_USERNAME_CLAIM", "preferred_username"),
+		GroupsClaim:   getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		GroupRoles:    parseGroupRoles("OIDC_GROUP_ROLES", os.Getenv("OIDC_GROUP_ROLES")),
+		PostLoginURL:  os.Getenv("OIDC_POST_LOGIN_URL"),
+	}
+}
+
+// ProviderEnabled reports whether provider is listed in AuthProviders.
+func ProviderEnabled(provider string) bool {
+	for _, enabled := range AuthProviders {
+		if enabled == provider {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// ConflictRule stops a user having an event of type Type at the same time as one of type Blocks.
+// Types are matched as patterns, e.g. DutyTech* matches DutyTech1 and DutyTech2.
+type ConflictRule struct {
+	Type   string
+	Blocks string
+}
+
+// ConflictConfig holds the rules events are checked against when they're created or updated.
+type ConflictConfig struct {
+	Rules []ConflictRule
+	// ExclusiveTypes are the types with at most one event on any day, whoever it belongs to
+	ExclusiveTypes []string
+}
+
+// Conflicts holds the conflict settings loaded by LoadEnvVariables
+var Conflicts ConflictConfig
+
+// MatchType reports whether an event type matches a pattern from the conflict settings.
+func MatchType(pattern string, eventType string) bool {
+	matched, err := path.Match(pattern, eventType)
+	return err == nil && matched
+}
+
+// Matches reports whether a rule applies between events of two types, in either order.
+func (rule ConflictRule) Matches(a string, b string) bool {
+	return MatchType(rule.Type, a) && MatchType(rule.Blocks, b) || MatchType(rule.Type, b) && MatchType(rule.Blocks, a)
+}
+
+// IsExclusive reports whether only one event of a type may be on each day.
+func (conflicts ConflictConfig) IsExclusive(eventType string) bool {
+	for _, pattern := range conflicts.ExclusiveTypes {
+		if MatchType(pattern, eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadConflictConfig reads the conflict rules from the environment.
+// CONFLICT_RULES is a semicolon separated list of type:blocked pairs, e.g. leave:DutyTech*;DutyTech*:DutyTech*,
+// and EXCLUSIVE_TYPES a comma separated list of types, e.g. DutyTech1,DutyTech2.
+func LoadConflictConfig() ConflictConfig {
+	var conflicts ConflictConfig
+	for _, pair := range strings.Split(os.Getenv("CONFLICT_RULES"), ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		eventType, blocks, ok := strings.Cut(pair, ":")
+		rule := ConflictRule{Type: strings.TrimSpace(eventType), Blocks: strings.TrimSpace(blocks)}
+		if !ok || !validPattern(rule.Type) || !validPattern(rule.Blocks) {
+			log.Fatalf("CONFLICT_RULES entry %q must be a pair of event types, e.g. leave:DutyTech*", pair)
+		}
+		conflicts.Rules = append(conflicts.Rules, rule)
+	}
+	for _, eventType := range strings.Split(os.Getenv("EXCLUSIVE_TYPES"), ",") {
+		eventType = strings.TrimSpace(eventType)
+		if eventType == "" {
+			continue
+		}
+		if !validPattern(eventType) {
+			log.Fatalf("EXCLUSIVE_TYPES entry %q is not a valid event type", eventType)
+		}
+		conflicts.ExclusiveTypes = append(conflicts.ExclusiveTypes, eventType)
+	}
+	return conflicts
+}
+
+// validPattern reports whether an event type pattern is non-empty and well formed.
+func validPattern(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return pattern != "" && err == nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConflictConfig(t *testing.T) {
+	t.Setenv("CONFLICT_RULES", " leave:DutyTech* ; DutyTech*:DutyTech*;;")
+	t.Setenv("EXCLUSIVE_TYPES", "DutyTech1, DutyTech2,")
+
+	conflicts := LoadConflictConfig()
+	assert.Equal(t, []ConflictRule{{Type: "leave", Blocks: "DutyTech*"}, {Type: "DutyTech*", Blocks: "DutyTech*"}}, conflicts.Rules)
+	assert.Equal(t, []string{"DutyTech1", "DutyTech2"}, conflicts.ExclusiveTypes)
+
+	t.Setenv("CONFLICT_RULES", "")
+	t.Setenv("EXCLUSIVE_TYPES", "")
+	assert.Equal(t, ConflictConfig{}, LoadConflictConfig())
+}
+
+func TestConflictRuleMatches(t *testing.T) {
+	rule := ConflictRule{Type: "leave", Blocks: "DutyTech*"}
+	assert.True(t, rule.Matches("leave", "DutyTech1"))
+	assert.True(t, rule.Matches("DutyTech2", "leave"))
+	assert.False(t, rule.Matches("leave", "leave"))
+	assert.False(t, rule.Matches("DutyTech1", "DutyTech2"))
+	assert.False(t, rule.Matches("leave", "OnCall"))
+}
+
+func TestIsExclusive(t *testing.T) {
+	conflicts := ConflictConfig{ExclusiveTypes: []string{"DutyTech?", "OnCall"}}
+	assert.True(t, conflicts.IsExclusive("DutyTech1"))
+	assert.True(t, conflicts.IsExclusive("OnCall"))
+	assert.False(t, conflicts.IsExclusive("DutyTech10"))
+	assert.False(t, conflicts.IsExclusive("leave"))
+}
+
+func TestValidPattern(t *testing.T) {
+	assert.True(t, validPattern("DutyTech*"))
+	assert.False(t, validPattern(""))
+	assert.False(t, validPattern("DutyTech["))
+}
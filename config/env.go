@@ -15,6 +15,18 @@ func LoadEnvVariables() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		return
+	}
+	AuthProviders = LoadAuthProviders()
+	LDAP = LoadLDAPConfig()
+	OIDC = LoadOIDCConfig()
+	Session = LoadSessionConfig()
+	JWT = LoadJWTConfig()
+	Conflicts = LoadConflictConfig()
+	DefaultRegion = LoadDefaultRegion()
+	Holidays = LoadHolidayConfig()
+	DefaultTimezone = LoadDefaultTimezone()
+	DefaultLocation = LoadLocation(DefaultTimezone)
+	if ProviderEnabled(ProviderOIDC) && (OIDC.IssuerURL == "" || OIDC.ClientID == "" || OIDC.RedirectURL == "") {
+		log.Fatal("OIDC_ISSUER_URL, OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set to use the oidc provider")
 	}
 }
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"log"
+	"strings"
+)
+
+// Holiday providers which can be listed in HOLIDAY_PROVIDERS
+const (
+	HolidayProviderGovUK = "govuk"
+	HolidayProviderFile  = "file"
+	HolidayProviderICS   = "ics"
+)
+
+// HolidayConfig holds the settings of the sources public holidays are synchronised from.
+type HolidayConfig struct {
+	// Providers are tried in order until one returns holidays
+	Providers []string
+	// GovUKURL is the base URL of the gov.uk bank holidays feed, e.g. https://www.gov.uk
+	GovUKURL string
+	// File is a JSON file in the gov.uk format, such as the bundled snapshot
+	File string
+	// ICSSource is the URL or path of an iCalendar holiday calendar
+	ICSSource string
+	// ICSRegion is the region the holidays in the iCalendar calendar apply to, defaulting to DefaultRegion
+	ICSRegion string
+}
+
+// Holidays holds the holiday settings loaded by LoadEnvVariables
+var Holidays HolidayConfig
+
+// LoadHolidayConfig reads the holiday provider settings from the environment.
+// HOLIDAY_PROVIDERS is a comma separated list of govuk, file and ics, defaulting to govuk,file so that
+// the bundled snapshot is used when gov.uk can't be reached.
+// HOLIDAY_ICS_REGION is checked against the known regions by initializers.CheckConfig.
+func LoadHolidayConfig() HolidayConfig {
+	holidays := HolidayConfig{
+		GovUKURL:  strings.TrimSuffix(getEnv("HOLIDAY_GOVUK_URL", "https://www.gov.uk"), "/"),
+		File:      getEnv("HOLIDAY_FILE", "gov-uk-holidays.json"),
+		ICSSource: getEnv("HOLIDAY_ICS_SOURCE", ""),
+		ICSRegion: strings.ToLower(strings.TrimSpace(getEnv("HOLIDAY_ICS_REGION", DefaultRegion))),
+	}
+	for _, provider := range strings.Split(getEnv("HOLIDAY_PROVIDERS", HolidayProviderGovUK+","+HolidayProviderFile), ",") {
+		provider = strings.ToLower(strings.TrimSpace(provider))
+		switch provider {
+		case "":
+			continue
+		case HolidayProviderGovUK, HolidayProviderFile:
+		case HolidayProviderICS:
+			if holidays.ICSSource == "" {
+				log.Fatal("HOLIDAY_ICS_SOURCE must be set to use the ics holiday provider")
+			}
+		default:
+			log.Fatalf("Unknown holiday provider %q in HOLIDAY_PROVIDERS", provider)
+		}
+		holidays.Providers = append(holidays.Providers, provider)
+	}
+	return holidays
+}
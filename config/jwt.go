@@ -0,0 +1,66 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// JWTKeyFile is a PEM file holding a key access tokens are signed or verified with.
+type JWTKeyFile struct {
+	// KID identifies the key in the header of the tokens it signs
+	KID  string
+	Path string
+}
+
+// JWTConfig holds the keys access tokens are signed with.
+type JWTConfig struct {
+	// Keys are the RS256 or ES256 keys tokens are verified with. Files holding only a public key can
+	// verify tokens but not sign them, for keys being retired.
+	Keys []JWTKeyFile
+	// ActiveKID is the key new tokens are signed with
+	ActiveKID string
+	// Secret is the HS256 secret used when no keys are configured
+	Secret string
+	// AcceptLegacyHS256 keeps accepting tokens signed with the secret once keys are configured, so that
+	// switching to keys doesn't log everybody out. It should be turned off once those tokens have expired.
+	AcceptLegacyHS256 bool
+}
+
+// JWT holds the JWT settings loaded by LoadEnvVariables
+var JWT JWTConfig
+
+// LoadJWTConfig reads the JWT settings from the environment. JWT_SIGNING_KEYS is a comma-separated list
+// of kid:path pairs, e.g. "2024-01:/etc/scheduler/2024-01.pem,2024-06:/etc/scheduler/2024-06.pem",
+// and JWT_ACTIVE_KID defaults to the last key listed.
+func LoadJWTConfig() JWTConfig {
+	jwtConfig := JWTConfig{
+		ActiveKID: os.Getenv("JWT_ACTIVE_KID"),
+		Secret:    os.Getenv("JWT_AUTH_SECRET_KEY"),
+	}
+	for _, pair := range strings.Split(os.Getenv("JWT_SIGNING_KEYS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(pair, ":")
+		if !ok || strings.TrimSpace(kid) == "" || strings.TrimSpace(path) == "" {
+			log.Fatalf("JWT_SIGNING_KEYS entry %q must be of the form kid:path", pair)
+		}
+		jwtConfig.Keys = append(jwtConfig.Keys, JWTKeyFile{KID: strings.TrimSpace(kid), Path: strings.TrimSpace(path)})
+	}
+	if value := os.Getenv("JWT_ACCEPT_LEGACY_HS256"); value != "" {
+		accept, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatal("JWT_ACCEPT_LEGACY_HS256 must be true or false")
+		}
+		jwtConfig.AcceptLegacyHS256 = accept
+	}
+	if jwtConfig.ActiveKID == "" && len(jwtConfig.Keys) > 0 {
+		jwtConfig.ActiveKID = jwtConfig.Keys[len(jwtConfig.Keys)-1].KID
+	}
+	if len(jwtConfig.Keys) == 0 && jwtConfig.Secret == "" {
+		log.Fatal("JWT_SIGNING_KEYS or JWT_AUTH_SECRET_KEY must be set")
+	}
+	return jwtConfig
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LDAP TLS modes
+const (
+	// LDAPTLSNone connects without TLS
+	LDAPTLSNone = "none"
+	// LDAPTLSLDAPS connects with TLS from the start, usually on port 636
+	LDAPTLSLDAPS = "ldaps"
+	// LDAPTLSStartTLS connects without TLS then upgrades the connection with StartTLS
+	LDAPTLSStartTLS = "starttls"
+)
+
+// LDAPConfig holds the settings of the LDAP directory users log in against.
+type LDAPConfig struct {
+	// Host is the host and port of the directory, e.g. "ldap.example.com:389"
+	Host string
+	// TLS is one of none, ldaps or starttls
+	TLS string
+	// CACertFile is a PEM file of CA certificates to trust instead of the system pool
+	CACertFile string
+	// InsecureSkipVerify disables certificate verification, for testing only
+	InsecureSkipVerify bool
+	// BaseDN is where users are searched for, e.g. "dc=example,dc=com"
+	BaseDN string
+	// BindDN and BindPassword are the read-only credentials used to search for users
+	BindDN       string
+	BindPassword string
+	// UserAttribute is matched against the username when searching, e.g. "uid" or "sAMAccountName"
+	UserAttribute string
+	// UserFilter is an optional filter users must also match, e.g. "(objectClass=person)"
+	UserFilter string
+	// Timeout bounds connecting to the directory and each request made to it
+	Timeout time.Duration
+	// GroupAttribute is the attribute of a user's entry listing their groups, e.g. "memberOf"
+	GroupAttribute string
+	// GroupBaseDN and GroupFilter optionally search for the user's groups, for directories without
+	// a memberOf attribute. {dn} and {username} in the filter are replaced with the user's DN and username,
+	// e.g. "(&(objectClass=groupOfUniqueNames)(uniqueMember={dn}))"
+	GroupBaseDN string
+	GroupFilter string
+	// GroupRoles maps lower case group DNs or common names onto the role given to their members
+	GroupRoles map[string]string
+}
+
+// LDAP holds the LDAP settings loaded by LoadEnvVariables
+var LDAP LDAPConfig
+
+// getEnv returns the value of an environment variable, or fallback if it is unset or empty.
+func getEnv(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// LoadLDAPConfig reads the LDAP settings from the environment.
+// The defaults point at the public forumsys.com test directory.
+func LoadLDAPConfig() LDAPConfig {
+	ldapConfig := LDAPConfig{
+		Host:           getEnv("LDAP_HOST", "ldap.forumsys.com:389"),
+		TLS:            strings.ToLower(getEnv("LDAP_TLS", LDAPTLSNone)),
+		CACertFile:     os.Getenv("LDAP_CA_CERT"),
+		BaseDN:         getEnv("LDAP_BASE_DN", "dc=example,dc=com"),
+		BindDN:         getEnv("LDAP_BIND_DN", "cn=read-only-admin,dc=example,dc=com"),
+		BindPassword:   getEnv("LDAP_BIND_PASSWORD", "password"),
+		UserAttribute:  getEnv("LDAP_USER_ATTRIBUTE", "uid"),
+		UserFilter:     os.Getenv("LDAP_USER_FILTER"),
+		Timeout:        10 * time.Second,
+		GroupAttribute: getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		GroupBaseDN:    os.Getenv("LDAP_GROUP_BASE_DN"),
+		GroupFilter:    os.Getenv("LDAP_GROUP_FILTER"),
+		GroupRoles:     parseGroupRoles("LDAP_GROUP_ROLES", os.Getenv("LDAP_GROUP_ROLES")),
+	}
+	if ldapConfig.GroupBaseDN == "" {
+		ldapConfig.GroupBaseDN = ldapConfig.BaseDN
+	}
+
+	switch ldapConfig.TLS {
+	case LDAPTLSNone, LDAPTLSLDAPS, LDAPTLSStartTLS:
+	default:
+		log.Fatalf("LDAP_TLS must be one of %s, %s or %s", LDAPTLSNone, LDAPTLSLDAPS, LDAPTLSStartTLS)
+	}
+
+	if value := os.Getenv("LDAP_INSECURE_SKIP_VERIFY"); value != "" {
+		skip, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Fatal("LDAP_INSECURE_SKIP_VERIFY must be true or false")
+		}
+		ldapConfig.InsecureSkipVerify = skip
+	}
+
+	ldapConfig.Timeout = parseDurationEnv("LDAP_TIMEOUT", ldapConfig.Timeout)
+	return ldapConfig
+}
+
+// parseGroupRoles parses the environment variable key, a semicolon-separated list of group:role pairs
+// where the group is either a DN or a name, e.g.
+// "cn=scheduler-admins,ou=groups,dc=example,dc=com:admin;mathematicians:editor"
+// The roles are checked against the known roles by initializers.CheckConfig.
+func parseGroupRoles(key string, value string) map[string]string {
+	groupRoles := make(map[string]string)
+	for _, pair := range strings.Split(value, ";") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		separator := strings.LastIndex(pair, ":")
+		if separator < 1 {
+			log.Fatalf("%s entry %q must be of the form group:role", key, pair)
+		}
+		group := strings.ToLower(strings.TrimSpace(pair[:separator]))
+		role := strings.ToLower(strings.TrimSpace(pair[separator+1:]))
+		if role == "" {
+			log.Fatalf("%s entry %q must be of the form group:role", key, pair)
+		}
+		groupRoles[group] = role
+	}
+	return groupRoles
+}
@@ -0,0 +1,16 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGroupRoles(t *testing.T) {
+	groupRoles := parseGroupRoles("LDAP_GROUP_ROLES", " CN=Scheduler-Admins,OU=Groups,DC=example,DC=com : Admin;;mathematicians:editor; ")
+	assert.Equal(t, map[string]string{
+		"cn=scheduler-admins,ou=groups,dc=example,dc=com": "admin",
+		"mathematicians": "editor",
+	}, groupRoles)
+	assert.Empty(t, parseGroupRoles("LDAP_GROUP_ROLES", ""))
+}
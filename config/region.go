@@ -0,0 +1,22 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultRegion is the region used when DEFAULT_REGION is unset, models.RegionEnglandAndWales
+const defaultRegion = "england-and-wales"
+
+// DefaultRegion is the region whose holidays apply to users who haven't set one, loaded by LoadEnvVariables
+var DefaultRegion = defaultRegion
+
+// LoadDefaultRegion reads DEFAULT_REGION from the environment, defaulting to england-and-wales.
+// The region is checked against the known regions by initializers.CheckConfig.
+func LoadDefaultRegion() string {
+	region := strings.ToLower(strings.TrimSpace(os.Getenv("DEFAULT_REGION")))
+	if region == "" {
+		return defaultRegion
+	}
+	return region
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// SessionConfig holds the lifetimes of login sessions and their tokens.
+type SessionConfig struct {
+	// AccessTokenTTL is how long an access JWT is valid for before it must be refreshed
+	AccessTokenTTL time.Duration
+	// SessionTTL is how long a session lasts after login, its refresh tokens expire with it
+	SessionTTL time.Duration
+}
+
+// Session holds the session settings loaded by LoadEnvVariables
+var Session SessionConfig
+
+// parseDurationEnv reads a duration from the environment variable key, or returns fallback if it is unset.
+func parseDurationEnv(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Fatalf("%s must be a positive duration, e.g. 15m", key)
+	}
+	return duration
+}
+
+// LoadSessionConfig reads the session settings from the environment.
+func LoadSessionConfig() SessionConfig {
+	return SessionConfig{
+		AccessTokenTTL: parseDurationEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		SessionTTL:     parseDurationEnv("SESSION_TTL", 90*24*time.Hour),
+	}
+}
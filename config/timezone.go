@@ -0,0 +1,38 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	// embed the zone database, the distroless image doesn't have one
+	_ "time/tzdata"
+)
+
+// DefaultTimezone is the zone of events and users which don't have one, loaded by LoadEnvVariables
+var DefaultTimezone = "Europe/London"
+
+// DefaultLocation is DefaultTimezone loaded
+var DefaultLocation = LoadLocation(DefaultTimezone)
+
+// LoadLocation returns the IANA zone with a name, or UTC if it's unknown.
+func LoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// LoadDefaultTimezone reads DEFAULT_TIMEZONE from the environment, defaulting to Europe/London.
+func LoadDefaultTimezone() string {
+	timezone := strings.TrimSpace(os.Getenv("DEFAULT_TIMEZONE"))
+	if timezone == "" {
+		return "Europe/London"
+	}
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		log.Fatalf("DEFAULT_TIMEZONE must be an IANA timezone, e.g. Europe/London: %v", err)
+	}
+	return timezone
+}
@@ -0,0 +1,121 @@
+package holidays
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultGovUKURL is the base URL of the gov.uk bank holidays feed
+const DefaultGovUKURL = "https://www.gov.uk"
+
+// division is the holidays of one region in the gov.uk format.
+type division struct {
+	Division string `json:"division"`
+	Events   []struct {
+		Title   string `json:"title"`
+		Date    string `json:"date"`
+		Notes   string `json:"notes"`
+		Bunting bool   `json:"bunting"`
+	} `json:"events"`
+}
+
+// decodeGovUK reads holidays in the format of https://www.gov.uk/bank-holidays.json, keyed by division.
+func decodeGovUK(r io.Reader) ([]Holiday, error) {
+	var divisions map[string]division
+	if err := json.NewDecoder(r).Decode(&divisions); err != nil {
+		return nil, err
+	}
+	regions := make([]string, 0, len(divisions))
+	for region := range divisions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+
+	holidays := make([]Holiday, 0)
+	for _, region := range regions {
+		for _, event := range divisions[region].Events {
+			date, err := time.Parse("2006-01-02", event.Date)
+			if err != nil {
+				return nil, fmt.Errorf("%s holiday %q: invalid date %q", region, event.Title, event.Date)
+			}
+			holidays = append(holidays, Holiday{
+				Region:  region,
+				Title:   event.Title,
+				Date:    date,
+				Notes:   event.Notes,
+				Bunting: event.Bunting,
+			})
+		}
+	}
+	return holidays, nil
+}
+
+// GovUK retrieves holidays from the gov.uk bank holidays feed.
+type GovUK struct {
+	// BaseURL is the address the feed is served from, DefaultGovUKURL if empty
+	BaseURL string
+	// Client makes the request, http.DefaultClient if nil
+	Client *http.Client
+}
+
+func (provider GovUK) Name() string {
+	return "govuk"
+}
+
+func (provider GovUK) Holidays(ctx context.Context) ([]Holiday, error) {
+	baseURL := provider.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultGovUKURL
+	}
+	body, err := get(ctx, provider.Client, strings.TrimSuffix(baseURL, "/")+"/bank-holidays.json")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return decodeGovUK(body)
+}
+
+// File reads holidays from a JSON file in the gov.uk format, such as the bundled gov-uk-holidays.json.
+type File struct {
+	Path string
+}
+
+func (provider File) Name() string {
+	return "file " + provider.Path
+}
+
+func (provider File) Holidays(ctx context.Context) ([]Holiday, error) {
+	file, err := os.Open(provider.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return decodeGovUK(file)
+}
+
+// get requests a URL, failing unless the response is successful.
+func get(ctx context.Context, client *http.Client, url string) (io.ReadCloser, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp.Body, nil
+}
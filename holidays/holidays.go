@@ -0,0 +1,68 @@
+// Package holidays retrieves public holidays from interchangeable sources, such as the gov.uk feed,
+// a bundled snapshot of it or an iCalendar holiday calendar.
+package holidays
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Holiday is a public holiday on a day in a region.
+type Holiday struct {
+	// Region is the region the holiday applies to, e.g. england-and-wales
+	Region string
+	Title  string
+	// Date is midnight UTC on the day of the holiday
+	Date    time.Time
+	Notes   string
+	Bunting bool
+}
+
+// Key identifies the holiday on a date in a region, whichever provider it came from.
+func Key(region string, date time.Time) string {
+	return region + "/" + date.UTC().Format("2006-01-02")
+}
+
+// Key identifies the holiday, so that a renamed holiday replaces the original rather than adding another.
+func (holiday Holiday) Key() string {
+	return Key(holiday.Region, holiday.Date)
+}
+
+// Provider is a source of public holidays.
+type Provider interface {
+	// Name describes the provider in logs, e.g. govuk
+	Name() string
+	// Holidays returns every holiday known to the provider
+	Holidays(ctx context.Context) ([]Holiday, error)
+}
+
+// Chain is a list of providers which are tried in order, e.g. the gov.uk feed falling back to a local snapshot.
+type Chain []Provider
+
+// Holidays returns the holidays of the first provider which succeeds and returns at least one holiday,
+// along with the name of that provider. If every provider fails, the errors of all of them are returned.
+func (chain Chain) Holidays(ctx context.Context) ([]Holiday, string, error) {
+	if len(chain) == 0 {
+		return nil, "", errors.New("no holiday providers are configured")
+	}
+	var errs []error
+	for _, provider := range chain {
+		holidays, err := provider.Holidays(ctx)
+		if err == nil && len(holidays) == 0 {
+			err = errors.New("no holidays returned")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+			continue
+		}
+		return holidays, provider.Name(), nil
+	}
+	return nil, "", errors.Join(errs...)
+}
+
+// day returns midnight UTC on the date of t.
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
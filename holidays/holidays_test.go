@@ -0,0 +1,146 @@
+package holidays
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const govUKFeed = `{
+	"england-and-wales": {"division": "england-and-wales", "events": [
+		{"title": "Christmas Day", "date": "2024-12-25", "notes": "", "bunting": true}
+	]},
+	"scotland": {"division": "scotland", "events": [
+		{"title": "St Andrew’s Day", "date": "2024-12-02", "notes": "Substitute day", "bunting": true}
+	]}
+}`
+
+func date(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+// stub is a provider returning fixed holidays or an error.
+type stub struct {
+	name     string
+	holidays []Holiday
+	err      error
+}
+
+func (provider stub) Name() string {
+	return provider.name
+}
+
+func (provider stub) Holidays(ctx context.Context) ([]Holiday, error) {
+	return provider.holidays, provider.err
+}
+
+func TestGovUK(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/bank-holidays.json", r.URL.Path)
+		w.Write([]byte(govUKFeed))
+	}))
+	defer server.Close()
+
+	holidays, err := GovUK{BaseURL: server.URL + "/"}.Holidays(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Holiday{
+		{Region: "england-and-wales", Title: "Christmas Day", Date: date(2024, time.December, 25), Bunting: true},
+		{Region: "scotland", Title: "St Andrew’s Day", Date: date(2024, time.December, 2), Notes: "Substitute day", Bunting: true},
+	}, holidays)
+}
+
+func TestGovUKFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := GovUK{BaseURL: server.URL}.Holidays(context.Background())
+	assert.ErrorContains(t, err, "503")
+}
+
+func TestFileBundledSnapshot(t *testing.T) {
+	holidays, err := File{Path: filepath.Join("..", "gov-uk-holidays.json")}.Holidays(context.Background())
+	assert.NoError(t, err)
+	regions := make(map[string]int)
+	for _, holiday := range holidays {
+		regions[holiday.Region]++
+	}
+	assert.Len(t, regions, 3)
+	for _, region := range []string{"england-and-wales", "scotland", "northern-ireland"} {
+		assert.NotZero(t, regions[region], region)
+	}
+}
+
+func TestICS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.ics")
+	calendar := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:christmas\r\nDTSTART;VALUE=DATE:20241225\r\nDTEND;VALUE=DATE:20241227\r\nSUMMARY:Christmas\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:new-year\r\nDTSTART;VALUE=DATE:20250101\r\nRRULE:FREQ=YEARLY\r\nSUMMARY:New Year\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:new-year\r\nRECURRENCE-ID;VALUE=DATE:20260101\r\nDTSTART;VALUE=DATE:20260102\r\nSUMMARY:New Year (substitute)\r\nEND:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\nUID:withdrawn\r\nDTSTART;VALUE=DATE:20250505\r\nSTATUS:CANCELLED\r\nSUMMARY:Withdrawn\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	assert.NoError(t, os.WriteFile(path, []byte(calendar), 0o600))
+
+	holidays, err := ICS{Source: path, Region: "scotland", Until: date(2027, time.June, 1)}.Holidays(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Holiday{
+		{Region: "scotland", Title: "Christmas", Date: date(2024, time.December, 25)},
+		{Region: "scotland", Title: "Christmas", Date: date(2024, time.December, 26)},
+		{Region: "scotland", Title: "New Year", Date: date(2025, time.January, 1)},
+		{Region: "scotland", Title: "New Year", Date: date(2027, time.January, 1)},
+		{Region: "scotland", Title: "New Year (substitute)", Date: date(2026, time.January, 2)},
+	}, holidays)
+}
+
+func TestICSURL(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:a\r\nDTSTART;VALUE=DATE:20240506\r\nSUMMARY:Early May\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"))
+	}))
+	defer server.Close()
+
+	holidays, err := ICS{Source: server.URL + "/holidays.ics", Region: "northern-ireland"}.Holidays(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []Holiday{{Region: "northern-ireland", Title: "Early May", Date: date(2024, time.May, 6)}}, holidays)
+}
+
+func TestChainFallsBack(t *testing.T) {
+	fallback := []Holiday{{Region: "scotland", Title: "Hogmanay", Date: date(2024, time.December, 31)}}
+	chain := Chain{
+		stub{name: "offline", err: errors.New("no route to host")},
+		stub{name: "empty"},
+		stub{name: "snapshot", holidays: fallback},
+		stub{name: "unused", err: errors.New("should not be called")},
+	}
+	holidays, source, err := chain.Holidays(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot", source)
+	assert.Equal(t, fallback, holidays)
+}
+
+func TestChainFails(t *testing.T) {
+	chain := Chain{stub{name: "offline", err: errors.New("no route to host")}, stub{name: "empty"}}
+	_, _, err := chain.Holidays(context.Background())
+	assert.ErrorContains(t, err, "offline: no route to host")
+	assert.ErrorContains(t, err, "empty: no holidays returned")
+
+	_, _, err = Chain{}.Holidays(context.Background())
+	assert.Error(t, err)
+}
+
+func TestKey(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	assert.NoError(t, err)
+	holiday := Holiday{Region: "scotland", Title: "Coronation", Date: date(2023, time.May, 8)}
+	assert.Equal(t, "scotland/2023-05-08", holiday.Key())
+	// a renamed holiday keeps its key, and dates read back in another location still match
+	holiday.Title = "Coronation of King Charles III"
+	assert.Equal(t, Key("scotland", holiday.Date.In(london)), holiday.Key())
+}
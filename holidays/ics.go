@@ -0,0 +1,105 @@
+package holidays
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/glssn/scheduler-api/ical"
+)
+
+// maxICSOccurrences bounds how many occurrences of a recurring holiday are expanded
+const maxICSOccurrences = 1000
+
+// ICS reads holidays from an iCalendar holiday calendar, at a URL or a path.
+// Each day of an event is a holiday, and recurring events are expanded up to Until.
+type ICS struct {
+	// Source is an http(s) URL or a file path
+	Source string
+	// Region is the region every holiday in the calendar applies to
+	Region string
+	// Until is when recurring holidays stop being expanded, two years from now if zero
+	Until time.Time
+	// Client makes the request for a URL, http.DefaultClient if nil
+	Client *http.Client
+}
+
+func (provider ICS) Name() string {
+	return "ics " + provider.Source
+}
+
+func (provider ICS) Holidays(ctx context.Context) ([]Holiday, error) {
+	var body io.ReadCloser
+	var err error
+	if strings.HasPrefix(provider.Source, "http://") || strings.HasPrefix(provider.Source, "https://") {
+		body, err = get(ctx, provider.Client, provider.Source)
+	} else {
+		body, err = os.Open(provider.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	calendar, err := ical.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	until := provider.Until
+	if until.IsZero() {
+		until = time.Now().AddDate(2, 0, 0)
+	}
+
+	// occurrences moved or cancelled by an override aren't expanded from their series
+	overridden := make(map[string]map[time.Time]bool)
+	for _, event := range calendar.Events {
+		if !event.RecurrenceID.IsZero() {
+			if overridden[event.UID] == nil {
+				overridden[event.UID] = make(map[time.Time]bool)
+			}
+			overridden[event.UID][day(event.RecurrenceID)] = true
+		}
+	}
+
+	holidays := make([]Holiday, 0)
+	for _, event := range calendar.Events {
+		if event.Status == "CANCELLED" {
+			continue
+		}
+		starts := []time.Time{event.Start}
+		if event.RRule != nil && event.RecurrenceID.IsZero() {
+			starts = event.RRule.Between(event.Start, event.Start, until, maxICSOccurrences)
+		}
+		length := event.End.Sub(event.Start)
+		for _, start := range starts {
+			if event.RRule != nil && overridden[event.UID][day(start)] {
+				continue
+			}
+			for _, date := range eventDays(start, start.Add(length), event.AllDay) {
+				holidays = append(holidays, Holiday{
+					Region: provider.Region,
+					Title:  event.Summary,
+					Date:   date,
+					Notes:  event.Description,
+				})
+			}
+		}
+	}
+	return holidays, nil
+}
+
+// eventDays returns each day an event is on. All-day events end on the exclusive date of DTEND.
+func eventDays(start time.Time, end time.Time, allDay bool) []time.Time {
+	first, last := day(start), day(end)
+	if allDay || (last.Equal(end) && end.After(start)) {
+		last = last.AddDate(0, 0, -1)
+	}
+	days := []time.Time{first}
+	for date := first.AddDate(0, 0, 1); !date.After(last); date = date.AddDate(0, 0, 1) {
+		days = append(days, date)
+	}
+	return days
+}
@@ -0,0 +1,130 @@
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxLineOctets is the longest a content line may be before it is folded, per RFC 5545 section 3.1.
+const maxLineOctets = 75
+
+// Event is a VEVENT component.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Categories  string
+	Start       time.Time
+	End         time.Time
+	AllDay      bool
+	RRule       *RRule
+	Status      string
+	// RecurrenceID identifies the occurrence of a recurring event which this event overrides
+	RecurrenceID time.Time
+	Created      time.Time
+	LastModified time.Time
+}
+
+// Calendar is a VCALENDAR object containing events.
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+// Encode writes the calendar in iCalendar format.
+func (cal Calendar) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	write := func(line string) {
+		writeFolded(bw, line)
+	}
+
+	write("BEGIN:VCALENDAR")
+	write("VERSION:2.0")
+	write("PRODID:" + cal.ProdID)
+	write("CALSCALE:GREGORIAN")
+	write("METHOD:PUBLISH")
+	if cal.Name != "" {
+		write("X-WR-CALNAME:" + EscapeText(cal.Name))
+	}
+	stamp := time.Now().UTC().Format(dateTimeFormatUTC)
+	for _, event := range cal.Events {
+		write("BEGIN:VEVENT")
+		write("UID:" + event.UID)
+		write("DTSTAMP:" + stamp)
+		if event.AllDay {
+			write("DTSTART;VALUE=DATE:" + event.Start.Format(dateFormat))
+			write("DTEND;VALUE=DATE:" + allDayEnd(event.Start, event.End).Format(dateFormat))
+		} else {
+			write("DTSTART:" + event.Start.UTC().Format(dateTimeFormatUTC))
+			if event.End.After(event.Start) {
+				write("DTEND:" + event.End.UTC().Format(dateTimeFormatUTC))
+			}
+		}
+		write("SUMMARY:" + EscapeText(event.Summary))
+		if event.Description != "" {
+			write("DESCRIPTION:" + EscapeText(event.Description))
+		}
+		if event.Categories != "" {
+			write("CATEGORIES:" + EscapeText(event.Categories))
+		}
+		if event.RRule != nil {
+			write("RRULE:" + event.RRule.ruleString(event.AllDay))
+			for _, line := range event.RRule.exDateLines(event.AllDay) {
+				write(line)
+			}
+		}
+		if event.Status != "" {
+			write("STATUS:" + event.Status)
+		}
+		if !event.Created.IsZero() {
+			write("CREATED:" + event.Created.UTC().Format(dateTimeFormatUTC))
+		}
+		if !event.LastModified.IsZero() {
+			write("LAST-MODIFIED:" + event.LastModified.UTC().Format(dateTimeFormatUTC))
+		}
+		write("END:VEVENT")
+	}
+	write("END:VCALENDAR")
+	return bw.Flush()
+}
+
+// allDayEnd returns the exclusive DTEND date of an all-day event. Events without an end,
+// or ending part way through a day, run until the end of that day.
+func allDayEnd(start time.Time, end time.Time) time.Time {
+	if !end.After(start) {
+		end = start
+	}
+	endDate := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, end.Location())
+	if endDate.Equal(end) && end.After(start) {
+		return endDate
+	}
+	return endDate.AddDate(0, 0, 1)
+}
+
+// EscapeText escapes a TEXT property value.
+func EscapeText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// writeFolded writes a content line terminated by CRLF, folding it so that no line is longer than 75 octets.
+// Lines are only split between UTF-8 characters.
+func writeFolded(w *bufio.Writer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		// continuation lines start with a space, which counts towards their length
+		limit = maxLineOctets - 1
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
@@ -0,0 +1,87 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalendarEncode(t *testing.T) {
+	rule, err := ParseRRule("FREQ=WEEKLY;UNTIL=20240131T000000Z\nEXDATE:20240108T000000Z")
+	assert.NoError(t, err)
+
+	calendar := Calendar{
+		ProdID: "-//test//EN",
+		Events: []Event{
+			{
+				UID:     "event-1@scheduler-api",
+				Summary: "DutyTech1; weekly, " + strings.Repeat("x", 80),
+				Start:   time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+				AllDay:  true,
+				RRule:   rule,
+			},
+			{
+				UID:     "event-2@scheduler-api",
+				Summary: "Shift",
+				Start:   time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC),
+				End:     time.Date(2024, time.January, 1, 17, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, calendar.Encode(&buf))
+	output := buf.String()
+
+	for _, line := range strings.Split(output, "\r\n") {
+		assert.LessOrEqual(t, len(line), maxLineOctets)
+	}
+	unfolded := strings.ReplaceAll(output, "\r\n ", "")
+	assert.Contains(t, unfolded, "DTSTART;VALUE=DATE:20240101\r\nDTEND;VALUE=DATE:20240102\r\n")
+	assert.Contains(t, unfolded, "SUMMARY:DutyTech1\\; weekly\\, x")
+	assert.Contains(t, unfolded, "RRULE:FREQ=WEEKLY;UNTIL=20240131\r\n")
+	assert.Contains(t, unfolded, "EXDATE;VALUE=DATE:20240108\r\n")
+	assert.Contains(t, unfolded, "DTSTART:20240101T090000Z\r\nDTEND:20240101T170000Z\r\n")
+}
+
+func TestParse(t *testing.T) {
+	input := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:leave-1@example.com\r\n" +
+		"SUMMARY:Annual leave\\, Spain\r\n" +
+		"DTSTART;VALUE=DATE:20240805\r\n" +
+		"DTEND;VALUE=DATE:20240810\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"TRIGGER:-PT15M\r\n" +
+		"END:VALARM\r\n" +
+		"END:VEVENT\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:oncall-1@example.com\r\n" +
+		"SUMMARY:On call handover meeting with a summary long enough to need fol\r\n" +
+		" ding\r\n" +
+		"DTSTART;TZID=Europe/London:20240701T090000\r\n" +
+		"DURATION:PT1H30M\r\n" +
+		"RRULE:FREQ=WEEKLY;BYDAY=MO;COUNT=4\r\n" +
+		"EXDATE;TZID=Europe/London:20240708T090000\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+
+	calendar, err := Parse(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Len(t, calendar.Events, 2)
+
+	leave := calendar.Events[0]
+	assert.Equal(t, "Annual leave, Spain", leave.Summary)
+	assert.True(t, leave.AllDay)
+	assert.Equal(t, time.Date(2024, time.August, 10, 0, 0, 0, 0, time.UTC), leave.End)
+
+	onCall := calendar.Events[1]
+	assert.Equal(t, "On call handover meeting with a summary long enough to need folding", onCall.Summary)
+	assert.False(t, onCall.AllDay)
+	assert.Equal(t, 90*time.Minute, onCall.End.Sub(onCall.Start))
+	assert.NotNil(t, onCall.RRule)
+	assert.Len(t, onCall.RRule.Between(onCall.Start, onCall.Start, onCall.Start.AddDate(0, 2, 0), 10), 3)
+}
@@ -0,0 +1,205 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationPattern matches the subset of RFC 5545 DURATION values used by calendar clients, e.g. P1D or PT1H30M.
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// contentLine is a single unfolded iCalendar property.
+type contentLine struct {
+	raw    string
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads an iCalendar object and returns the VEVENTs in it.
+// Floating times are interpreted in UTC. Components other than VEVENT are ignored.
+func Parse(r io.Reader) (*Calendar, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	calendar := &Calendar{}
+	var event []contentLine
+	inCalendar, inEvent, depth := false, false, 0
+	for _, line := range lines {
+		switch {
+		case line.name == "BEGIN" && strings.EqualFold(line.value, "VCALENDAR"):
+			inCalendar = true
+		case !inCalendar:
+			continue
+		case line.name == "BEGIN" && strings.EqualFold(line.value, "VEVENT") && !inEvent:
+			inEvent, event = true, nil
+		case line.name == "BEGIN" && inEvent:
+			// nested components such as VALARM
+			depth++
+		case line.name == "END" && inEvent && depth > 0:
+			depth--
+		case line.name == "END" && strings.EqualFold(line.value, "VEVENT") && inEvent:
+			inEvent = false
+			parsed, err := parseEvent(event)
+			if err != nil {
+				return nil, err
+			}
+			calendar.Events = append(calendar.Events, parsed)
+		case inEvent && depth == 0:
+			event = append(event, line)
+		case line.name == "PRODID":
+			calendar.ProdID = line.value
+		case line.name == "X-WR-CALNAME":
+			calendar.Name = UnescapeText(line.value)
+		}
+	}
+	if !inCalendar {
+		return nil, errors.New("missing VCALENDAR")
+	}
+	return calendar, nil
+}
+
+// unfold reads content lines, joining folded continuation lines.
+func unfold(r io.Reader) ([]contentLine, error) {
+	var raw []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(raw) > 0 {
+			raw[len(raw)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			raw = append(raw, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	lines := make([]contentLine, 0, len(raw))
+	for _, line := range raw {
+		name, params, value := splitContentLine(line)
+		lines = append(lines, contentLine{raw: line, name: name, params: params, value: value})
+	}
+	return lines, nil
+}
+
+// parseEvent converts the properties of a VEVENT into an Event.
+func parseEvent(lines []contentLine) (Event, error) {
+	var event Event
+	var recurrence []string
+	var duration time.Duration
+	hasEnd := false
+
+	for _, line := range lines {
+		switch line.name {
+		case "UID":
+			event.UID = line.value
+		case "SUMMARY":
+			event.Summary = UnescapeText(line.value)
+		case "DESCRIPTION":
+			event.Description = UnescapeText(line.value)
+		case "CATEGORIES":
+			event.Categories = UnescapeText(line.value)
+		case "STATUS":
+			event.Status = strings.ToUpper(line.value)
+		case "DTSTART":
+			start, allDay, err := ParseDateTime(line.value, line.params, time.UTC)
+			if err != nil {
+				return event, fmt.Errorf("event %q: invalid DTSTART %q", event.UID, line.value)
+			}
+			event.Start, event.AllDay = start, allDay
+		case "DTEND":
+			end, _, err := ParseDateTime(line.value, line.params, time.UTC)
+			if err != nil {
+				return event, fmt.Errorf("event %q: invalid DTEND %q", event.UID, line.value)
+			}
+			event.End, hasEnd = end, true
+		case "DURATION":
+			parsed, err := ParseDuration(line.value)
+			if err != nil {
+				return event, fmt.Errorf("event %q: %w", event.UID, err)
+			}
+			duration = parsed
+		case "RECURRENCE-ID":
+			recurrenceID, _, err := ParseDateTime(line.value, line.params, time.UTC)
+			if err != nil {
+				return event, fmt.Errorf("event %q: invalid RECURRENCE-ID %q", event.UID, line.value)
+			}
+			event.RecurrenceID = recurrenceID
+		case "RRULE", "EXDATE":
+			recurrence = append(recurrence, line.raw)
+		case "CREATED":
+			event.Created, _, _ = ParseDateTime(line.value, line.params, time.UTC)
+		case "LAST-MODIFIED":
+			event.LastModified, _, _ = ParseDateTime(line.value, line.params, time.UTC)
+		}
+	}
+	if event.Start.IsZero() {
+		return event, fmt.Errorf("event %q: missing DTSTART", event.UID)
+	}
+	if !hasEnd && duration > 0 {
+		event.End = event.Start.Add(duration)
+	}
+	if len(recurrence) > 0 {
+		rule, err := ParseRRule(strings.Join(recurrence, "\n"))
+		if err != nil {
+			return event, fmt.Errorf("event %q: %w", event.UID, err)
+		}
+		event.RRule = rule
+	}
+	return event, nil
+}
+
+// ParseDuration parses an RFC 5545 DURATION value such as P1D or PT1H30M.
+func ParseDuration(value string) (time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(value)))
+	if match == nil || value == "P" || value == "PT" {
+		return 0, fmt.Errorf("invalid DURATION %q", value)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if match[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid DURATION %q", value)
+		}
+		duration += time.Duration(n) * unit
+	}
+	if match[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+// UnescapeText reverses EscapeText.
+func UnescapeText(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i == len(text)-1 {
+			b.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(text[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,629 @@
+// Package ical implements the parts of RFC 5545 (iCalendar) used by the scheduler.
+package ical
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPeriods bounds how many FREQ periods are walked when expanding a rule,
+// so a rule which never matches (e.g. BYMONTHDAY=31;BYMONTH=2) terminates.
+const maxPeriods = 100000
+
+const (
+	dateFormat        = "20060102"
+	dateTimeFormat    = "20060102T150405"
+	dateTimeFormatUTC = "20060102T150405Z"
+	exDayFormat       = "2006-01-02"
+)
+
+// Supported FREQ values
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Weekday is a BYDAY entry, e.g. MO, 1MO (first Monday) or -1FR (last Friday).
+// N is zero when the entry applies to every matching weekday in the period.
+type Weekday struct {
+	Day time.Weekday
+	N   int
+}
+
+// String formats the weekday as it appears in a BYDAY rule part.
+func (w Weekday) String() string {
+	for name, day := range weekdays {
+		if day == w.Day {
+			if w.N == 0 {
+				return name
+			}
+			return strconv.Itoa(w.N) + name
+		}
+	}
+	return ""
+}
+
+// RRule is a parsed RFC 5545 recurrence rule, together with any EXDATEs which exclude occurrences from it.
+type RRule struct {
+	Freq       string
+	Interval   int
+	ByDay      []Weekday
+	ByMonthDay []int
+	ByMonth    []int
+	Count      int
+	Until      time.Time
+	// ExDates are excluded occurrence start times
+	ExDates []time.Time
+	// ExDays are excluded calendar days, formatted as 2006-01-02, from EXDATE;VALUE=DATE entries
+	ExDays []string
+}
+
+// ParseRRule parses a recurrence rule.
+// The input is either a bare rule such as "FREQ=WEEKLY;BYDAY=MO", or iCalendar content lines
+// separated by newlines, i.e. an "RRULE:" line followed by any number of "EXDATE" lines.
+func ParseRRule(input string) (*RRule, error) {
+	var rule *RRule
+	var exLines []string
+
+	for _, line := range strings.Split(strings.ReplaceAll(input, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, _, value := splitContentLine(line)
+		switch name {
+		case "RRULE":
+			if rule != nil {
+				return nil, errors.New("only one RRULE is supported")
+			}
+			parsed, err := parseRulePart(value)
+			if err != nil {
+				return nil, err
+			}
+			rule = parsed
+		case "EXDATE":
+			exLines = append(exLines, line)
+		case "DTSTART":
+			// the start of the series is always the event's StartDate
+			continue
+		default:
+			if !strings.Contains(line, ":") && strings.Contains(line, "=") {
+				if rule != nil {
+					return nil, errors.New("only one RRULE is supported")
+				}
+				parsed, err := parseRulePart(line)
+				if err != nil {
+					return nil, err
+				}
+				rule = parsed
+				continue
+			}
+			return nil, fmt.Errorf("unsupported recurrence property %q", name)
+		}
+	}
+	if rule == nil {
+		return nil, errors.New("missing RRULE")
+	}
+	for _, line := range exLines {
+		_, params, value := splitContentLine(line)
+		if err := rule.addExDates(params, value); err != nil {
+			return nil, err
+		}
+	}
+	return rule, nil
+}
+
+// splitContentLine splits an iCalendar content line into its upper-cased name, its parameters and its value.
+func splitContentLine(line string) (string, map[string]string, string) {
+	params := map[string]string{}
+	colon := indexOutsideQuotes(line, ':')
+	if colon < 0 {
+		return strings.ToUpper(line), params, ""
+	}
+	head, value := line[:colon], line[colon+1:]
+	parts := strings.Split(head, ";")
+	for _, param := range parts[1:] {
+		if key, val, ok := strings.Cut(param, "="); ok {
+			params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, value
+}
+
+// indexOutsideQuotes returns the index of the first sep which isn't inside a quoted parameter value.
+func indexOutsideQuotes(s string, sep byte) int {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseRulePart parses the value of an RRULE property, e.g. "FREQ=MONTHLY;BYDAY=1MO".
+func parseRulePart(value string) (*RRule, error) {
+	rule := &RRule{Interval: 1}
+	for _, part := range strings.Split(value, ";") {
+		if part == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rule part %q", part)
+		}
+		val = strings.ToUpper(strings.TrimSpace(val))
+		switch strings.ToUpper(strings.TrimSpace(key)) {
+		case "FREQ":
+			switch val {
+			case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+				rule.Freq = val
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid rule part %q", part)
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid rule part %q", part)
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, _, err := ParseDateTime(val, nil, time.UTC)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule part %q", part)
+			}
+			rule.Until = until
+		case "BYDAY":
+			for _, entry := range strings.Split(val, ",") {
+				weekday, err := parseWeekday(entry)
+				if err != nil {
+					return nil, err
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "BYMONTHDAY":
+			for _, entry := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(entry)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid rule part %q", part)
+				}
+				rule.ByMonthDay = append(rule.ByMonthDay, n)
+			}
+		case "BYMONTH":
+			for _, entry := range strings.Split(val, ",") {
+				n, err := strconv.Atoi(entry)
+				if err != nil || n < 1 || n > 12 {
+					return nil, fmt.Errorf("invalid rule part %q", part)
+				}
+				rule.ByMonth = append(rule.ByMonth, n)
+			}
+		case "WKST":
+			// weeks always start on Monday
+			if _, ok := weekdays[val]; !ok {
+				return nil, fmt.Errorf("invalid rule part %q", part)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported rule part %q", key)
+		}
+	}
+	if rule.Freq == "" {
+		return nil, errors.New("missing FREQ")
+	}
+	if rule.Count > 0 && !rule.Until.IsZero() {
+		return nil, errors.New("COUNT and UNTIL are mutually exclusive")
+	}
+	if rule.Freq == FreqDaily || rule.Freq == FreqWeekly {
+		for _, weekday := range rule.ByDay {
+			if weekday.N != 0 {
+				return nil, errors.New("BYDAY ordinals are only allowed with MONTHLY or YEARLY")
+			}
+		}
+	}
+	return rule, nil
+}
+
+// parseWeekday parses a BYDAY entry such as "MO", "1MO" or "-1FR".
+func parseWeekday(entry string) (Weekday, error) {
+	entry = strings.TrimSpace(entry)
+	if len(entry) < 2 {
+		return Weekday{}, fmt.Errorf("invalid BYDAY %q", entry)
+	}
+	day, ok := weekdays[entry[len(entry)-2:]]
+	if !ok {
+		return Weekday{}, fmt.Errorf("invalid BYDAY %q", entry)
+	}
+	weekday := Weekday{Day: day}
+	if ordinal := entry[:len(entry)-2]; ordinal != "" {
+		n, err := strconv.Atoi(ordinal)
+		if err != nil || n == 0 || n < -53 || n > 53 {
+			return Weekday{}, fmt.Errorf("invalid BYDAY %q", entry)
+		}
+		weekday.N = n
+	}
+	return weekday, nil
+}
+
+// ParseDateTime parses an iCalendar DATE or DATE-TIME value.
+// Floating times, and times with a TZID parameter, are interpreted in loc (or the TZID zone when it can be loaded).
+// allDay is true for DATE values, which are returned as midnight in loc.
+func ParseDateTime(value string, params map[string]string, loc *time.Location) (t time.Time, allDay bool, err error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+	if tzid, ok := params["TZID"]; ok {
+		if zone, err := time.LoadLocation(tzid); err == nil {
+			loc = zone
+		}
+	}
+	value = strings.TrimSpace(value)
+	if params["VALUE"] == "DATE" || len(value) == len(dateFormat) {
+		t, err = time.ParseInLocation(dateFormat, value, loc)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err = time.Parse(dateTimeFormatUTC, value)
+		return t, false, err
+	}
+	t, err = time.ParseInLocation(dateTimeFormat, value, loc)
+	return t, false, err
+}
+
+// addExDates adds the values of an EXDATE property to the rule.
+func (r *RRule) addExDates(params map[string]string, value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		t, allDay, err := ParseDateTime(entry, params, time.UTC)
+		if err != nil {
+			return fmt.Errorf("invalid EXDATE %q", entry)
+		}
+		if allDay {
+			r.ExDays = append(r.ExDays, t.Format(exDayFormat))
+			continue
+		}
+		r.ExDates = append(r.ExDates, t)
+	}
+	return nil
+}
+
+// Exclude adds an EXDATE for the occurrence starting at t.
+func (r *RRule) Exclude(t time.Time) {
+	if !r.IsExcluded(t) {
+		r.ExDates = append(r.ExDates, t)
+	}
+}
+
+// IsExcluded reports whether the occurrence starting at t is removed by an EXDATE.
+func (r *RRule) IsExcluded(t time.Time) bool {
+	for _, exDate := range r.ExDates {
+		if exDate.Equal(t) {
+			return true
+		}
+	}
+	day := t.Format(exDayFormat)
+	for _, exDay := range r.ExDays {
+		if exDay == day {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleString formats the RRULE property value, without the "RRULE:" name or any EXDATEs.
+func (r *RRule) RuleString() string {
+	return r.ruleString(false)
+}
+
+// ruleString formats the RRULE property value, with UNTIL as a DATE for series of all-day events.
+func (r *RRule) ruleString(dateOnly bool) string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, weekday := range r.ByDay {
+			days[i] = weekday.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByMonth) > 0 {
+		parts = append(parts, "BYMONTH="+joinInts(r.ByMonth))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() && dateOnly {
+		parts = append(parts, "UNTIL="+r.Until.Format(dateFormat))
+	} else if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(dateTimeFormatUTC))
+	}
+	return strings.Join(parts, ";")
+}
+
+// ExDateLines formats the rule's EXDATEs as iCalendar content lines.
+func (r *RRule) ExDateLines() []string {
+	return r.exDateLines(false)
+}
+
+// exDateLines formats the rule's EXDATEs as content lines, with every EXDATE as a DATE for series of all-day events.
+func (r *RRule) exDateLines(dateOnly bool) []string {
+	var lines []string
+	if dateOnly {
+		values := make([]string, 0, len(r.ExDates)+len(r.ExDays))
+		for _, exDate := range r.ExDates {
+			values = append(values, exDate.Format(dateFormat))
+		}
+		for _, exDay := range r.ExDays {
+			values = append(values, strings.ReplaceAll(exDay, "-", ""))
+		}
+		if len(values) > 0 {
+			lines = append(lines, "EXDATE;VALUE=DATE:"+strings.Join(values, ","))
+		}
+		return lines
+	}
+	if len(r.ExDates) > 0 {
+		values := make([]string, len(r.ExDates))
+		for i, exDate := range r.ExDates {
+			values[i] = exDate.UTC().Format(dateTimeFormatUTC)
+		}
+		lines = append(lines, "EXDATE:"+strings.Join(values, ","))
+	}
+	if len(r.ExDays) > 0 {
+		values := make([]string, len(r.ExDays))
+		for i, exDay := range r.ExDays {
+			values[i] = strings.ReplaceAll(exDay, "-", "")
+		}
+		lines = append(lines, "EXDATE;VALUE=DATE:"+strings.Join(values, ","))
+	}
+	return lines
+}
+
+// String formats the rule as iCalendar content lines, which ParseRRule accepts.
+func (r *RRule) String() string {
+	return strings.Join(append([]string{"RRULE:" + r.RuleString()}, r.ExDateLines()...), "\n")
+}
+
+// joinInts formats a slice of ints as a comma separated list.
+func joinInts(values []int) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = strconv.Itoa(value)
+	}
+	return strings.Join(formatted, ",")
+}
+
+// Between returns the start times of the occurrences of a series starting at dtstart which fall within [from, to].
+// Candidates are generated in dtstart's location so that wall-clock times are kept across DST changes.
+// Like most implementations, dtstart is only an occurrence if it matches the rule.
+// At most limit occurrences are returned.
+func (r *RRule) Between(dtstart time.Time, from time.Time, to time.Time, limit int) []time.Time {
+	occurrences := make([]time.Time, 0)
+	count := 0
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	for period := 0; period < maxPeriods; period++ {
+		candidates, periodStart := r.candidates(dtstart, period*interval)
+		if periodStart.After(to) || (!r.Until.IsZero() && periodStart.After(r.Until)) {
+			break
+		}
+		for _, candidate := range candidates {
+			if candidate.Before(dtstart) {
+				continue
+			}
+			if !r.Until.IsZero() && candidate.After(r.Until) {
+				return occurrences
+			}
+			count++
+			if r.Count > 0 && count > r.Count {
+				return occurrences
+			}
+			if candidate.After(to) {
+				return occurrences
+			}
+			if candidate.Before(from) || r.IsExcluded(candidate) {
+				continue
+			}
+			occurrences = append(occurrences, candidate)
+			if len(occurrences) >= limit {
+				return occurrences
+			}
+		}
+	}
+	return occurrences
+}
+
+// candidates returns the sorted occurrence candidates for the period offset FREQ units from dtstart,
+// together with the start of that period.
+func (r *RRule) candidates(dtstart time.Time, offset int) ([]time.Time, time.Time) {
+	loc := dtstart.Location()
+	hour, min, sec := dtstart.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, dtstart.Nanosecond(), loc)
+	}
+
+	var days []time.Time
+	var periodStart time.Time
+	switch r.Freq {
+	case FreqDaily:
+		day := dtstart.AddDate(0, 0, offset)
+		periodStart = at(day.Year(), day.Month(), day.Day())
+		days = []time.Time{periodStart}
+	case FreqWeekly:
+		// weeks start on Monday
+		weekStart := dtstart.AddDate(0, 0, -((int(dtstart.Weekday())+6)%7)+7*offset)
+		periodStart = at(weekStart.Year(), weekStart.Month(), weekStart.Day())
+		if len(r.ByDay) == 0 {
+			day := dtstart.AddDate(0, 0, 7*offset)
+			days = []time.Time{at(day.Year(), day.Month(), day.Day())}
+			break
+		}
+		for i := 0; i < 7; i++ {
+			day := weekStart.AddDate(0, 0, i)
+			days = append(days, at(day.Year(), day.Month(), day.Day()))
+		}
+	case FreqMonthly:
+		month := time.Date(dtstart.Year(), dtstart.Month()+time.Month(offset), 1, 0, 0, 0, 0, loc)
+		periodStart = at(month.Year(), month.Month(), 1)
+		days = r.monthDays(dtstart, month.Year(), month.Month(), at)
+	case FreqYearly:
+		year := dtstart.Year() + offset
+		periodStart = at(year, time.January, 1)
+		days = r.yearDays(dtstart, year, at)
+	}
+
+	candidates := make([]time.Time, 0, len(days))
+	for _, day := range days {
+		if r.matches(day) {
+			candidates = append(candidates, day)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	return candidates, periodStart
+}
+
+// monthDays returns the candidate days in a month. Without BYDAY or BYMONTHDAY that is dtstart's day of
+// the month, which is skipped in months too short to contain it.
+func (r *RRule) monthDays(dtstart time.Time, year int, month time.Month, at func(int, time.Month, int) time.Time) []time.Time {
+	length := daysIn(year, month)
+	if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+		if dtstart.Day() > length {
+			return nil
+		}
+		return []time.Time{at(year, month, dtstart.Day())}
+	}
+	days := make([]time.Time, 0, length)
+	for day := 1; day <= length; day++ {
+		days = append(days, at(year, month, day))
+	}
+	return days
+}
+
+// yearDays returns the candidate days in a year.
+func (r *RRule) yearDays(dtstart time.Time, year int, at func(int, time.Month, int) time.Time) []time.Time {
+	if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+		months := []time.Month{dtstart.Month()}
+		if len(r.ByMonth) > 0 {
+			months = nil
+			for _, month := range r.ByMonth {
+				months = append(months, time.Month(month))
+			}
+		}
+		var days []time.Time
+		for _, month := range months {
+			if dtstart.Day() <= daysIn(year, month) {
+				days = append(days, at(year, month, dtstart.Day()))
+			}
+		}
+		return days
+	}
+	var days []time.Time
+	for month := time.January; month <= time.December; month++ {
+		for day := 1; day <= daysIn(year, month); day++ {
+			days = append(days, at(year, month, day))
+		}
+	}
+	return days
+}
+
+// matches applies the BYMONTH, BYMONTHDAY and BYDAY filters to a candidate day.
+func (r *RRule) matches(day time.Time) bool {
+	if len(r.ByMonth) > 0 && !containsInt(r.ByMonth, int(day.Month())) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 {
+		length := daysIn(day.Year(), day.Month())
+		found := false
+		for _, monthDay := range r.ByMonthDay {
+			if monthDay == day.Day() || monthDay < 0 && length+monthDay+1 == day.Day() {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.ByDay) > 0 {
+		found := false
+		for _, weekday := range r.ByDay {
+			if weekday.Day != day.Weekday() {
+				continue
+			}
+			if weekday.N == 0 || weekday.N == r.ordinal(day, weekday.N) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ordinal returns the position of day among the same weekdays within its month (or its year for
+// YEARLY rules without BYMONTH), counted from the start when n is positive and from the end when negative.
+func (r *RRule) ordinal(day time.Time, n int) int {
+	yearly := r.Freq == FreqYearly && len(r.ByMonth) == 0
+	if n > 0 {
+		if yearly {
+			return (day.YearDay()-1)/7 + 1
+		}
+		return (day.Day()-1)/7 + 1
+	}
+	if yearly {
+		daysInYear := time.Date(day.Year(), time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+		return -((daysInYear-day.YearDay())/7 + 1)
+	}
+	return -((daysIn(day.Year(), day.Month())-day.Day())/7 + 1)
+}
+
+// daysIn returns the number of days in a month.
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// containsInt checks if an int slice contains a given int
+func containsInt(slice []int, n int) bool {
+	for _, s := range slice {
+		if s == n {
+			return true
+		}
+	}
+	return false
+}
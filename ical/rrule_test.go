@@ -0,0 +1,94 @@
+package ical
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRRuleRoundTrip(t *testing.T) {
+	rule, err := ParseRRule("FREQ=MONTHLY;INTERVAL=2;BYDAY=1MO,-1FR;COUNT=5\nEXDATE;VALUE=DATE:20240101")
+	assert.NoError(t, err)
+	assert.Equal(t, "RRULE:FREQ=MONTHLY;INTERVAL=2;BYDAY=1MO,-1FR;COUNT=5\nEXDATE;VALUE=DATE:20240101", rule.String())
+
+	reparsed, err := ParseRRule(rule.String())
+	assert.NoError(t, err)
+	assert.Equal(t, rule, reparsed)
+}
+
+func TestParseRRuleInvalid(t *testing.T) {
+	for _, input := range []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=DAILY;COUNT=2;UNTIL=20240101",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"RRULE:FREQ=DAILY\nEXDATE:yesterday",
+	} {
+		_, err := ParseRRule(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestBetweenFirstMondayOfMonth(t *testing.T) {
+	rule, err := ParseRRule("RRULE:FREQ=MONTHLY;BYDAY=1MO")
+	assert.NoError(t, err)
+
+	dtstart := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	occurrences := rule.Between(dtstart, dtstart, time.Date(2024, time.April, 30, 0, 0, 0, 0, time.UTC), 100)
+
+	assert.Equal(t, []time.Time{
+		time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.February, 5, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.March, 4, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.April, 1, 9, 0, 0, 0, time.UTC),
+	}, occurrences)
+}
+
+func TestBetweenWeekdaysWithCountAndExDate(t *testing.T) {
+	rule, err := ParseRRule("RRULE:FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR;COUNT=5\nEXDATE;VALUE=DATE:20240103")
+	assert.NoError(t, err)
+
+	dtstart := time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC)
+	occurrences := rule.Between(dtstart, dtstart, dtstart.AddDate(0, 1, 0), 100)
+
+	// COUNT includes the excluded occurrence on the 3rd
+	assert.Equal(t, []time.Time{
+		time.Date(2024, time.January, 1, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.January, 2, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.January, 4, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, time.January, 5, 9, 0, 0, 0, time.UTC),
+	}, occurrences)
+}
+
+func TestBetweenMonthlySkipsShortMonths(t *testing.T) {
+	rule, err := ParseRRule("FREQ=MONTHLY;UNTIL=20240430T235959Z")
+	assert.NoError(t, err)
+
+	dtstart := time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC)
+	occurrences := rule.Between(dtstart, dtstart, dtstart.AddDate(1, 0, 0), 100)
+
+	assert.Equal(t, []time.Time{
+		time.Date(2024, time.January, 31, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.March, 31, 0, 0, 0, 0, time.UTC),
+	}, occurrences)
+}
+
+func TestBetweenKeepsWallClockAcrossDST(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("tzdata unavailable")
+	}
+	rule, err := ParseRRule("FREQ=WEEKLY")
+	assert.NoError(t, err)
+
+	dtstart := time.Date(2024, time.March, 25, 9, 0, 0, 0, london).AddDate(0, 0, -7)
+	occurrences := rule.Between(dtstart, dtstart, dtstart.AddDate(0, 0, 14), 100)
+
+	for _, occurrence := range occurrences {
+		assert.Equal(t, 9, occurrence.Hour())
+	}
+	assert.Len(t, occurrences, 3)
+}
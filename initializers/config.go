@@ -0,0 +1,36 @@
+package initializers
+
+import (
+	"strings"
+
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+)
+
+// CheckConfig checks the settings loaded by config.LoadEnvVariables which name roles or regions,
+// which the config package leaves to the models that define them.
+func CheckConfig() {
+	logger := Logger()
+
+	groupRoles := []struct {
+		key     string
+		mapping map[string]string
+	}{
+		{"LDAP_GROUP_ROLES", config.LDAP.GroupRoles},
+		{"OIDC_GROUP_ROLES", config.OIDC.GroupRoles},
+	}
+	for _, groupRole := range groupRoles {
+		for group, role := range groupRole.mapping {
+			if !models.IsValidRole(role) {
+				logger.Fatalf("%s maps %q to the unknown role %q", groupRole.key, group, role)
+			}
+		}
+	}
+
+	if !models.IsValidRegion(config.DefaultRegion) {
+		logger.Fatalf("DEFAULT_REGION must be one of %s", strings.Join(models.Regions, ", "))
+	}
+	if !models.IsValidRegion(config.Holidays.ICSRegion) {
+		logger.Fatalf("HOLIDAY_ICS_REGION must be one of %s", strings.Join(models.Regions, ", "))
+	}
+}
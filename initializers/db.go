@@ -3,8 +3,10 @@ package initializers
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -22,6 +24,9 @@ func ConnectToDB() {
 	}
 	DB, err = gorm.Open(postgres.Open(dbURL), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Error),
+		// Postgres stores timestamps to the microsecond, so times are set at that precision for the
+		// entity tags of saved events to match the ones read back
+		NowFunc: func() time.Time { return time.Now().Local().Truncate(time.Microsecond) },
 	})
 
 	if err != nil {
@@ -38,10 +43,54 @@ func MigrateDatabase() {
 	// log the start of the function
 	logger.Println("MigrateDatabase: start")
 
+	// end dates used to be stored without a zone, in UTC
+	var endDateType string
+	DB.Raw("SELECT data_type FROM information_schema.columns WHERE table_name = 'events' AND column_name = 'end_date'").Scan(&endDateType)
+	if endDateType == "timestamp without time zone" {
+		DB.Exec("ALTER TABLE events ALTER COLUMN end_date TYPE timestamptz USING end_date AT TIME ZONE 'UTC'")
+	}
+
+	// event types used to be free text, so the types of existing events are registered when the registry is created
+	createRegistry := !DB.Migrator().HasTable(&models.EventType{})
+
 	DB.AutoMigrate(
 		models.Event{},
 		models.EventMeta{},
-		models.User{})
+		models.User{},
+		models.APIToken{},
+		models.Session{},
+		models.RefreshToken{},
+		models.RevokedToken{},
+		models.SwapRequest{},
+		models.EventType{},
+		models.HolidaySyncRun{},
+		models.AuditLog{},
+		models.EventVersion{})
+
+	if createRegistry {
+		backfillEventTypes()
+	}
+
+	// roles used to be capitalised, e.g. "Viewer"
+	DB.Model(&models.User{}).Where("role <> lower(role)").Update("role", gorm.Expr("lower(role)"))
+
+	// bank holidays used to be synchronised for England and Wales only, without a region
+	DB.Model(&models.Event{}).Where("type = ? AND region = ''", models.EventTypeBankHoliday).
+		Update("region", models.RegionEnglandAndWales)
+
+	// all-day events used to be stored as midnight UTC, rather than as dates in their timezone.
+	// An end at midnight was exclusive, as in iCalendar.
+	DB.Exec(`WITH days AS (
+		SELECT id, (start_date AT TIME ZONE 'UTC')::date AS start_day,
+			GREATEST((start_date AT TIME ZONE 'UTC')::date,
+				((end_date AT TIME ZONE 'UTC') - interval '1 microsecond')::date) AS end_day,
+			COALESCE(NULLIF(timezone, ''), ?) AS timezone
+		FROM events WHERE all_day AND start_day IS NULL
+	)
+	UPDATE events SET start_day = days.start_day, end_day = days.end_day,
+		start_date = days.start_day::timestamp AT TIME ZONE days.timezone,
+		end_date = (days.end_day + 1)::timestamp AT TIME ZONE days.timezone
+	FROM days WHERE events.id = days.id`, config.DefaultTimezone)
 
 	// log the end of the function
 	logger.Println("MigrateDatabase: end")
@@ -0,0 +1,84 @@
+package initializers
+
+import (
+	"os"
+	"strings"
+
+	"github.com/glssn/scheduler-api/api/models"
+)
+
+// defaultEventTypes are registered when the registry doesn't have them
+var defaultEventTypes = []models.EventType{
+	{
+		Name:         models.EventTypeBankHoliday,
+		Label:        "Bank holiday",
+		AllDayOnly:   true,
+		AllowedRoles: models.RoleBot + " " + models.RoleAdmin,
+	},
+	{
+		Name:  models.EventTypeLeave,
+		Label: "Leave",
+	},
+}
+
+// backfillEventTypes registers the types used by events from before the registry, so that existing events
+// keep validating. It's run once, when the registry is created, leaving the built in types to SeedEventTypes.
+func backfillEventTypes() {
+	logger := Logger()
+
+	var used []string
+	if err := DB.Unscoped().Model(&models.Event{}).Where("type <> ''").Distinct().Pluck("type", &used).Error; err != nil {
+		logger.Println("backfillEventTypes:", err)
+		return
+	}
+	builtIn := make(map[string]bool, len(defaultEventTypes))
+	for _, eventType := range defaultEventTypes {
+		builtIn[eventType.Name] = true
+	}
+	for _, name := range used {
+		if builtIn[name] {
+			continue
+		}
+		if err := DB.Create(&models.EventType{Name: name, Label: name}).Error; err != nil {
+			logger.Println("backfillEventTypes:", err)
+			continue
+		}
+		logger.Printf("Registered event type %s used by existing events", name)
+	}
+}
+
+// SeedEventTypes registers the built in event types and the ICS_IMPORT_DEFAULT_TYPE.
+// Types used by events which aren't registered, because they were deleted or never were, are logged for an admin
+// to register or fix, rather than registered, so that typos don't become types and deleted types stay deleted.
+func SeedEventTypes() {
+	logger := Logger()
+
+	eventTypes := append([]models.EventType{}, defaultEventTypes...)
+	if importType := os.Getenv("ICS_IMPORT_DEFAULT_TYPE"); importType != "" {
+		eventTypes = append(eventTypes, models.EventType{Name: importType, Label: importType})
+	}
+	for _, eventType := range eventTypes {
+		var existing int64
+		DB.Model(&models.EventType{}).Where("name = ?", eventType.Name).Count(&existing)
+		if existing > 0 {
+			continue
+		}
+		if err := DB.Create(&eventType).Error; err != nil {
+			logger.Println("SeedEventTypes:", err)
+			continue
+		}
+		logger.Printf("Registered event type %s", eventType.Name)
+	}
+
+	var unknown []string
+	err := DB.Model(&models.Event{}).Distinct().
+		Where("type NOT IN (?)", DB.Model(&models.EventType{}).Select("name")).
+		Pluck("type", &unknown).Error
+	if err != nil {
+		logger.Println("SeedEventTypes:", err)
+		return
+	}
+	if len(unknown) > 0 {
+		logger.Printf("Events have types which aren't registered, and can't be changed until they are: %s", strings.Join(unknown, ", "))
+	}
+}
@@ -0,0 +1,24 @@
+package initializers
+
+import (
+	"github.com/glssn/scheduler-api/api/auth"
+	"github.com/glssn/scheduler-api/config"
+)
+
+// LoadSigningKeys loads the keys access tokens are signed with from JWT_SIGNING_KEYS,
+// falling back to the JWT_AUTH_SECRET_KEY secret.
+func LoadSigningKeys() {
+	logger := Logger()
+
+	keySet, err := auth.LoadKeys(config.JWT)
+	if err != nil {
+		logger.Fatalln("LoadSigningKeys:", err)
+	}
+	auth.SetKeys(keySet)
+	if len(config.JWT.Keys) > 0 {
+		logger.Printf("Signing access tokens with JWT key %s", config.JWT.ActiveKID)
+		if config.JWT.AcceptLegacyHS256 && config.JWT.Secret != "" {
+			logger.Println("Still accepting access tokens signed with JWT_AUTH_SECRET_KEY, as JWT_ACCEPT_LEGACY_HS256 is set")
+		}
+	}
+}
@@ -1,31 +1,17 @@
 package initializers
 
 import (
-	"encoding/json"
+	"context"
 	"net/http"
 	"time"
 
+	"github.com/glssn/scheduler-api/api/audit"
 	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+	"github.com/glssn/scheduler-api/holidays"
+	"gorm.io/gorm"
 )
 
-type Response struct {
-	EnglandAndWales UKDivision `json:"england-and-wales"`
-	Scotland        UKDivision `json:"scotland"`
-	NorthernIreland UKDivision `json:"northern-ireland"`
-}
-
-type UKDivision struct {
-	Division string    `json:"division"`
-	Holidays []Holiday `json:"events"`
-}
-
-type Holiday struct {
-	Title   string `json:"title"`
-	Date    string `json:"date"`
-	Notes   string `json:"notes"`
-	Bunting bool   `json:"bunting"`
-}
-
 type EventList struct {
 	Events []models.Event
 }
@@ -34,90 +20,261 @@ func (eventlist *EventList) AddEvent(event models.Event) {
 	eventlist.Events = append(eventlist.Events, event)
 }
 
-func convertToEvent(holidays Response, bot models.User) []models.Event {
+func convertToEvent(retrieved []holidays.Holiday, bot models.User) []models.Event {
 	// create a new logger instance
 	logger := Logger()
 
 	eventlist := EventList{}
 
-	for _, hol := range holidays.EnglandAndWales.Holidays {
-		date, err := time.Parse("2006-01-02", hol.Date)
-		if err != nil {
-			// log the error message
-			logger.Println(err)
+	for _, hol := range retrieved {
+		if !models.IsValidRegion(hol.Region) {
+			// log holidays of regions which aren't synchronised, e.g. a new gov.uk division
+			logger.Printf("Skipping %s bank holiday %q in unknown region %q", hol.Date.Format("2006-01-02"), hol.Title, hol.Region)
 			continue
 		}
 
+		// holidays are whole dates in the default timezone
+		day := models.DateOf(hol.Date)
 		event := models.Event{
-			Type:              "bank_holiday",
+			Type:              models.EventTypeBankHoliday,
 			Title:             hol.Title,
-			StartDate:         date,
+			StartDate:         day.In(config.DefaultLocation),
+			EndDate:           day.AddDays(1).In(config.DefaultLocation),
+			StartDay:          &day,
+			EndDay:            &day,
+			Timezone:          config.DefaultTimezone,
 			AllDay:            true,
-			User:              bot,
+			UserID:            int(bot.ID),
 			RecurringType:     "None",
 			RecurringInterval: 0,
+			Region:            hol.Region,
+			ExternalID:        hol.Key(),
+			Notes:             hol.Notes,
+			Bunting:           hol.Bunting,
 		}
 		eventlist.AddEvent(event)
 	}
 	return eventlist.Events
 }
 
-func retrieveBankHolidays() (bank_holidays Response, err error) {
-	// https://www.gov.uk/bank-holidays.json
-	// create a new logger instance
-	logger := Logger()
+// holidayProviders returns the providers configured by HOLIDAY_PROVIDERS, in the order they're tried.
+func holidayProviders() holidays.Chain {
+	chain := holidays.Chain{}
+	for _, provider := range config.Holidays.Providers {
+		switch provider {
+		case config.HolidayProviderGovUK:
+			chain = append(chain, holidays.GovUK{BaseURL: config.Holidays.GovUKURL, Client: &http.Client{Timeout: 30 * time.Second}})
+		case config.HolidayProviderFile:
+			chain = append(chain, holidays.File{Path: config.Holidays.File})
+		case config.HolidayProviderICS:
+			chain = append(chain, holidays.ICS{
+				Source: config.Holidays.ICSSource,
+				Region: config.Holidays.ICSRegion,
+				Client: &http.Client{Timeout: 30 * time.Second},
+			})
+		}
+	}
+	return chain
+}
+
+// holidayKey returns the key of a synchronised bank holiday. Holidays synchronised before keys were
+// recorded are identified by their region and date.
+func holidayKey(event models.Event) string {
+	if event.ExternalID != "" {
+		return event.ExternalID
+	}
+	if event.StartDay != nil {
+		return holidays.Key(event.Region, event.StartDay.In(time.UTC))
+	}
+	return holidays.Key(event.Region, event.StartDate)
+}
 
-	var holidays Response
+// reconcileBankHolidays makes the bot's bank holidays match the retrieved events, creating new holidays,
+// updating the title, notes and bunting of changed ones and deleting ones which have been removed, and
+// counts the changes in run. Each change is recorded in the audit log and the holiday's history as made by the bot,
+// under the run's request ID.
+// Only holidays within the dates the source covers in a region are deleted, so past years which have
+// dropped out of the gov.uk feed, and regions a source doesn't cover, are kept.
+func reconcileBankHolidays(tx *gorm.DB, events []models.Event, bot models.User, run *models.HolidaySyncRun) error {
+	var existing []models.Event
+	if err := tx.Where("type = ? AND user_id = ?", models.EventTypeBankHoliday, bot.ID).Order("id").Find(&existing).Error; err != nil {
+		return err
+	}
+	current := make(map[string]models.Event, len(existing))
+	deleted := make([]models.Event, 0)
+	for _, event := range existing {
+		if _, duplicate := current[holidayKey(event)]; duplicate {
+			deleted = append(deleted, event)
+			continue
+		}
+		current[holidayKey(event)] = event
+	}
 
-	// Get the JSON data
-	r, err := http.Get("https://www.gov.uk/bank-holidays.json")
-	if err != nil {
-		// log the error message
-		logger.Println(err)
-		return holidays, err
+	// the latest version of each holiday, to number the versions of their changes
+	var latest []models.EventVersion
+	if err := tx.Model(&models.EventVersion{}).Select("event_id, MAX(version) AS version").
+		Where("event_id IN (?)", tx.Model(&models.Event{}).Unscoped().Select("id").Where("user_id = ?", bot.ID)).
+		Group("event_id").Find(&latest).Error; err != nil {
+		return err
+	}
+	versionOf := make(map[uint]int, len(latest))
+	for _, version := range latest {
+		versionOf[version.EventID] = version.Version
+	}
+	logs := make([]models.AuditLog, 0)
+	versions := make([]models.EventVersion, 0)
+	record := func(action string, before *models.Event, after *models.Event) error {
+		id := before
+		if after != nil {
+			id = after
+		}
+		entry, err := models.NewAuditLog(&bot, run.RequestID, action, audit.EntityEvent, id.ID, before, after)
+		if err != nil {
+			return err
+		}
+		logs = append(logs, entry)
+		versions = append(versions, models.NewEventVersions(versionOf[id.ID], action, before, after, &bot, run.RequestID)...)
+		return nil
 	}
-	defer r.Body.Close()
 
-	// Decode and unmarshal the response into structs
-	err = json.NewDecoder(r.Body).Decode(&holidays)
-	if err != nil {
-		// log the error message
-		logger.Println(err)
-		return holidays, err
+	// the first and last date retrieved in each region
+	covered := make(map[string][2]time.Time)
+	retrieved := make(map[string]bool, len(events))
+	created := make([]models.Event, 0)
+	for _, event := range events {
+		if retrieved[event.ExternalID] {
+			continue
+		}
+		retrieved[event.ExternalID] = true
+		span, ok := covered[event.Region]
+		if !ok || event.StartDate.Before(span[0]) {
+			span[0] = event.StartDate
+		}
+		if !ok || event.StartDate.After(span[1]) {
+			span[1] = event.StartDate
+		}
+		covered[event.Region] = span
+
+		old, ok := current[event.ExternalID]
+		if !ok {
+			created = append(created, event)
+			continue
+		}
+		if old.Title == event.Title && old.Notes == event.Notes && old.Bunting == event.Bunting &&
+			old.ExternalID == event.ExternalID && old.Timezone == event.Timezone && old.StartDate.Equal(event.StartDate) {
+			run.Unchanged++
+			continue
+		}
+		updated := old
+		updated.Title, updated.Notes, updated.Bunting = event.Title, event.Notes, event.Bunting
+		updated.ExternalID, updated.Timezone = event.ExternalID, event.Timezone
+		updated.StartDate, updated.EndDate, updated.StartDay, updated.EndDay = event.StartDate, event.EndDate, event.StartDay, event.EndDay
+		if err := tx.Model(&models.Event{}).Where("id = ?", old.ID).Select("title", "notes", "bunting", "external_id", "timezone", "start_date", "end_date", "start_day", "end_day").Updates(models.Event{
+			Title:      event.Title,
+			Notes:      event.Notes,
+			Bunting:    event.Bunting,
+			ExternalID: event.ExternalID,
+			Timezone:   event.Timezone,
+			StartDate:  event.StartDate,
+			EndDate:    event.EndDate,
+			StartDay:   event.StartDay,
+			EndDay:     event.EndDay,
+		}).Error; err != nil {
+			return err
+		}
+		if err := record(audit.ActionUpdate, &old, &updated); err != nil {
+			return err
+		}
+		run.Updated++
+	}
+
+	for key, event := range current {
+		span, ok := covered[event.Region]
+		if !retrieved[key] && ok && !event.StartDate.Before(span[0]) && !event.StartDate.After(span[1]) {
+			deleted = append(deleted, event)
+		}
+	}
+	if len(deleted) > 0 {
+		ids := make([]uint, 0, len(deleted))
+		for i := range deleted {
+			ids = append(ids, deleted[i].ID)
+			if err := record(audit.ActionDelete, &deleted[i], nil); err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&models.Event{}, ids).Error; err != nil {
+			return err
+		}
+	}
+	run.Deleted = len(deleted)
+
+	if len(created) > 0 {
+		if err := tx.CreateInBatches(&created, 100).Error; err != nil {
+			return err
+		}
+		for i := range created {
+			if err := record(audit.ActionCreate, nil, &created[i]); err != nil {
+				return err
+			}
+		}
 	}
-	return holidays, nil
+	run.Created = len(created)
+
+	if len(logs) > 0 {
+		if err := tx.CreateInBatches(&logs, 100).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(&versions, 100).Error
+	}
+	return nil
 }
 
+// PopulateBankHolidays synchronises the bank holidays with the first holiday provider which responds,
+// in a single transaction, and records the outcome as a HolidaySyncRun.
 func PopulateBankHolidays() {
 	// create a new logger instance
 	logger := Logger()
 
-	holidays, err := retrieveBankHolidays()
+	run := models.HolidaySyncRun{StartedAt: time.Now(), RequestID: audit.NewRequestID()}
+	defer func() {
+		run.FinishedAt = time.Now()
+		if err := DB.Create(&run).Error; err != nil {
+			logger.Println("Failed to record bank holiday sync:", err)
+		}
+	}()
+
+	retrieved, source, err := holidayProviders().Holidays(context.Background())
 	if err != nil {
 		// log the error message
-		logger.Println(err)
+		logger.Println("Failed to retrieve bank holidays:", err)
+		run.Error = err.Error()
 		return
 	}
+	run.Source, run.Retrieved = source, len(retrieved)
 	// log the number of bank holidays retrieved
-	logger.Printf("Retrieved %d bank holidays from gov.uk/bank-holidays", len(holidays.EnglandAndWales.Holidays))
+	logger.Printf("Retrieved %d bank holidays from %s", len(retrieved), source)
 
 	// create bank holiday bot user
 	bankHolidayBotUser := models.User{
 		Username: "bank-holiday-bot",
-		Role:     "bot",
+		Role:     models.RoleBot,
 	}
 	// Create the bot user if it doesn't already exist
 	DB.Where(&bankHolidayBotUser).FirstOrCreate(&bankHolidayBotUser)
 	// convert the holidays into models.Event
-	events := convertToEvent(holidays, bankHolidayBotUser)
-	// log the number of bank holiday events added to the database
-	logger.Printf("Adding %d bank holiday events to the database", len(events))
-	// add events to database, currently sequentially
-	for _, event := range events {
-		// only create if there isn't a Type: 'bank_holiday' event on this StartDate
-		DB.FirstOrCreate(&event, models.Event{Type: event.Type, StartDate: event.StartDate})
+	events := convertToEvent(retrieved, bankHolidayBotUser)
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		return reconcileBankHolidays(tx, events, bankHolidayBotUser, &run)
+	})
+	if err != nil {
+		logger.Println("Failed to synchronise bank holidays:", err)
+		run.Error = err.Error()
+		run.Created, run.Updated, run.Deleted, run.Unchanged = 0, 0, 0, 0
+		return
 	}
-	logger.Printf("Successfully synchronised %d bank holiday events with the database", len(events))
+	logger.Printf("Synchronised bank holidays: %d created, %d updated, %d deleted, %d unchanged",
+		run.Created, run.Updated, run.Deleted, run.Unchanged)
 }
 
 func SyncBankHolidays() {
@@ -0,0 +1,45 @@
+package initializers
+
+import (
+	"os"
+
+	"github.com/glssn/scheduler-api/api/auth"
+	"github.com/glssn/scheduler-api/api/models"
+	"github.com/glssn/scheduler-api/config"
+)
+
+// CreateLocalAdmin creates a local admin user from the LOCAL_ADMIN_USERNAME and LOCAL_ADMIN_PASSWORD
+// environment variables, so that there's somebody to create the other local users.
+// Nothing is done unless the local provider is enabled, or if the user already exists.
+func CreateLocalAdmin() {
+	logger := Logger()
+
+	username := os.Getenv("LOCAL_ADMIN_USERNAME")
+	password := os.Getenv("LOCAL_ADMIN_PASSWORD")
+	if !config.ProviderEnabled(config.ProviderLocal) || username == "" || password == "" {
+		return
+	}
+
+	var existing int64
+	DB.Model(&models.User{}).Where("provider = ? AND subject = ?", config.ProviderLocal, username).Count(&existing)
+	if existing > 0 {
+		return
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		logger.Fatalln("LOCAL_ADMIN_PASSWORD:", err)
+	}
+	admin := models.User{
+		Username:     username,
+		Role:         models.RoleAdmin,
+		Provider:     config.ProviderLocal,
+		Subject:      username,
+		PasswordHash: hash,
+	}
+	if err := DB.Create(&admin).Error; err != nil {
+		logger.Println("CreateLocalAdmin:", err)
+		return
+	}
+	logger.Printf("Created local admin user %s", username)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/glssn/scheduler-api/api"
+	"github.com/glssn/scheduler-api/api/middleware"
 	"github.com/glssn/scheduler-api/config"
 	"github.com/glssn/scheduler-api/initializers"
 )
@@ -15,8 +16,12 @@ import (
 func init() {
 	config.LoadEnvVariables()
 	initializers.Logger()
+	initializers.CheckConfig()
+	initializers.LoadSigningKeys()
 	initializers.ConnectToDB()
 	initializers.MigrateDatabase()
+	initializers.SeedEventTypes()
+	initializers.CreateLocalAdmin()
 	initializers.PopulateBankHolidays()
 	go initializers.SyncBankHolidays()
 }
@@ -37,6 +42,9 @@ func main() {
 	}
 
 	config.AllowCredentials = true
+	// let browsers send and read the request IDs changes are audited under, and the entity tags of events
+	config.AddAllowHeaders(middleware.RequestIDHeader, "If-Match", "If-None-Match")
+	config.AddExposeHeaders(middleware.RequestIDHeader, "ETag")
 	app.Use(cors.New(config))
 
 	api.Routes(app)
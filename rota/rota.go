@@ -0,0 +1,306 @@
+// Package rota generates fair on-call rotas.
+package rota
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// Periods a rota can assign users for
+const (
+	Day  = "day"
+	Week = "week"
+)
+
+// maxDays bounds the length of a rota
+const maxDays = 366 * 2
+
+// Constraints control how users are assigned.
+type Constraints struct {
+	// NoBackToBack avoids giving a user two slots in a row, e.g. two weeks running
+	NoBackToBack bool
+	// RespectLeave doesn't assign users to slots overlapping their leave
+	RespectLeave bool
+	// SkipBankHolidays leaves bank holidays uncovered, otherwise they're covered and count as holiday load
+	SkipBankHolidays bool
+	// BalanceWeekends gives slots with weekends or bank holidays to the users with the least such load
+	BalanceWeekends bool
+}
+
+// Span is a user's run of days, from Start to End inclusive.
+type Span struct {
+	UserID uint
+	Start  time.Time
+	End    time.Time
+}
+
+// Request describes the rota to generate. Dates are civil dates, their time of day is ignored.
+type Request struct {
+	Start       time.Time
+	End         time.Time
+	Period      string
+	Users       []uint
+	Seed        int64
+	Constraints Constraints
+	// Leave are the days users are unavailable
+	Leave []Span
+	// BankHolidays are skipped or count as holiday load, depending on the constraints
+	BankHolidays []time.Time
+	// Existing are days already covered, which aren't assigned again and count towards their user's load
+	Existing []Span
+}
+
+// UserReport summarises the load given to a user, including existing assignments.
+type UserReport struct {
+	UserID      uint `json:"user_id"`
+	Slots       int  `json:"slots"`
+	Days        int  `json:"days"`
+	WeekendDays int  `json:"weekend_days"`
+	HolidayDays int  `json:"holiday_days"`
+	LeaveDays   int  `json:"leave_days"`
+}
+
+// Result is a generated rota.
+type Result struct {
+	// Assignments are runs of consecutive covered days given to a user, in date order
+	Assignments []Span
+	// Report has an entry for every user in the pool, in the order they were given
+	Report []UserReport
+	// Unassigned are the days nobody was available for
+	Unassigned []time.Time
+	// Warnings describe constraints which had to be relaxed
+	Warnings []string
+}
+
+// slot is a period to assign a user for.
+type slot struct {
+	start time.Time
+	// days are the days in the slot which need covering
+	days []time.Time
+}
+
+// dateSet is a set of civil dates.
+type dateSet map[time.Time]bool
+
+// civil returns the date of t at midnight UTC.
+func civil(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// isWeekend reports whether day is a Saturday or Sunday.
+func isWeekend(day time.Time) bool {
+	return day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+}
+
+// addSpan adds every day of a span to the set.
+func (set dateSet) addSpan(start time.Time, end time.Time) {
+	for day := civil(start); !day.After(civil(end)); day = day.AddDate(0, 0, 1) {
+		set[day] = true
+	}
+}
+
+// load is the work given to a user so far.
+type load struct {
+	report UserReport
+	// rank breaks ties between equally loaded users, from the seeded shuffle
+	rank int
+	// lastSlot is the index of the user's last slot, -1 if they have none yet
+	lastSlot int
+}
+
+// Generate assigns users to the slots of a rota, deterministically for a given seed.
+// Each slot is given to the available user with the least load, weekend and holiday load first when
+// balancing weekends. Constraints are relaxed rather than leaving a slot uncovered, with a warning.
+func Generate(req Request) (Result, error) {
+	start, end := civil(req.Start), civil(req.End)
+	switch {
+	case len(req.Users) == 0:
+		return Result{}, errors.New("at least one user is required")
+	case end.Before(start):
+		return Result{}, errors.New("end date is before start date")
+	case end.Sub(start) > maxDays*24*time.Hour:
+		return Result{}, fmt.Errorf("rotas can be at most %d days long", maxDays)
+	case req.Period != Day && req.Period != Week:
+		return Result{}, fmt.Errorf("period must be %s or %s", Day, Week)
+	}
+
+	holidays := dateSet{}
+	for _, holiday := range req.BankHolidays {
+		holidays[civil(holiday)] = true
+	}
+
+	loads := make(map[uint]*load, len(req.Users))
+	var pool []uint
+	for _, userID := range req.Users {
+		if loads[userID] == nil {
+			loads[userID] = &load{report: UserReport{UserID: userID}, lastSlot: -1}
+			pool = append(pool, userID)
+		}
+	}
+	shuffled := append([]uint(nil), pool...)
+	random := rand.New(rand.NewSource(req.Seed))
+	random.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	for rank, userID := range shuffled {
+		loads[userID].rank = rank
+	}
+
+	leave := make(map[uint]dateSet)
+	for _, span := range req.Leave {
+		if loads[span.UserID] == nil {
+			continue
+		}
+		if leave[span.UserID] == nil {
+			leave[span.UserID] = dateSet{}
+		}
+		leave[span.UserID].addSpan(span.Start, span.End)
+	}
+	for userID, days := range leave {
+		for day := range days {
+			if !day.Before(start) && !day.After(end) {
+				loads[userID].report.LeaveDays++
+			}
+		}
+	}
+
+	covered := dateSet{}
+	for _, span := range req.Existing {
+		for day := civil(span.Start); !day.After(civil(span.End)); day = day.AddDate(0, 0, 1) {
+			if day.Before(start) || day.After(end) || covered[day] {
+				continue
+			}
+			covered[day] = true
+			if l := loads[span.UserID]; l != nil {
+				l.addDay(day, holidays)
+			}
+		}
+	}
+
+	result := Result{Unassigned: []time.Time{}, Warnings: []string{}, Assignments: []Span{}}
+	slots := makeSlots(start, end, req.Period, func(day time.Time) bool {
+		return covered[day] || (req.Constraints.SkipBankHolidays && holidays[day])
+	})
+	for index, s := range slots {
+		if len(s.days) == 0 {
+			continue
+		}
+		weighted := false
+		for _, day := range s.days {
+			weighted = weighted || isWeekend(day) || holidays[day]
+		}
+
+		// users who aren't on leave during the slot, and didn't have the previous one
+		var available, rested []uint
+		for _, userID := range pool {
+			if req.Constraints.RespectLeave && onLeave(leave[userID], s.days) {
+				continue
+			}
+			available = append(available, userID)
+			if last := loads[userID].lastSlot; !req.Constraints.NoBackToBack || last < 0 || last != index-1 {
+				rested = append(rested, userID)
+			}
+		}
+
+		candidates := rested
+		if len(candidates) == 0 && len(available) > 0 {
+			candidates = available
+			result.Warnings = append(result.Warnings, fmt.Sprintf(
+				"%s: only users who had the previous slot are available, so one has back-to-back slots", s.start.Format("2006-01-02")))
+		}
+		if len(candidates) == 0 {
+			result.Unassigned = append(result.Unassigned, s.days...)
+			result.Warnings = append(result.Warnings, fmt.Sprintf(
+				"%s: nobody is available, %d days are unassigned", s.start.Format("2006-01-02"), len(s.days)))
+			continue
+		}
+
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return loads[candidates[i]].less(loads[candidates[j]], weighted && req.Constraints.BalanceWeekends)
+		})
+		chosen := candidates[0]
+		l := loads[chosen]
+		l.report.Slots++
+		l.lastSlot = index
+		for _, day := range s.days {
+			l.addDay(day, holidays)
+		}
+		result.Assignments = append(result.Assignments, runs(chosen, s.days)...)
+	}
+
+	for _, userID := range pool {
+		result.Report = append(result.Report, loads[userID].report)
+	}
+	return result, nil
+}
+
+// addDay counts a covered day towards a user's load.
+func (l *load) addDay(day time.Time, holidays dateSet) {
+	l.report.Days++
+	if isWeekend(day) {
+		l.report.WeekendDays++
+	}
+	if holidays[day] {
+		l.report.HolidayDays++
+	}
+}
+
+// less reports whether l should be preferred over other for the next slot: the user with the fewest days,
+// or with weekend balancing the fewest weekend and holiday days, with the seeded shuffle breaking ties.
+func (l *load) less(other *load, balanceWeekends bool) bool {
+	if balanceWeekends {
+		mine := l.report.WeekendDays + l.report.HolidayDays
+		theirs := other.report.WeekendDays + other.report.HolidayDays
+		if mine != theirs {
+			return mine < theirs
+		}
+	}
+	if l.report.Days != other.report.Days {
+		return l.report.Days < other.report.Days
+	}
+	return l.rank < other.rank
+}
+
+// makeSlots splits the rota into periods, listing the days in each which need covering.
+// Weekly slots start on the rota's start date.
+func makeSlots(start time.Time, end time.Time, period string, skip func(time.Time) bool) []slot {
+	length := 1
+	if period == Week {
+		length = 7
+	}
+	var slots []slot
+	for slotStart := start; !slotStart.After(end); slotStart = slotStart.AddDate(0, 0, length) {
+		s := slot{start: slotStart}
+		for day := slotStart; day.Before(slotStart.AddDate(0, 0, length)) && !day.After(end); day = day.AddDate(0, 0, 1) {
+			if !skip(day) {
+				s.days = append(s.days, day)
+			}
+		}
+		slots = append(slots, s)
+	}
+	return slots
+}
+
+// onLeave reports whether any of days are in the set of leave days.
+func onLeave(leave dateSet, days []time.Time) bool {
+	for _, day := range days {
+		if leave[day] {
+			return true
+		}
+	}
+	return false
+}
+
+// runs splits a slot's days into spans of consecutive days, around skipped days.
+func runs(userID uint, days []time.Time) []Span {
+	var spans []Span
+	for _, day := range days {
+		if n := len(spans); n > 0 && spans[n-1].End.AddDate(0, 0, 1).Equal(day) {
+			spans[n-1].End = day
+			continue
+		}
+		spans = append(spans, Span{UserID: userID, Start: day, End: day})
+	}
+	return spans
+}
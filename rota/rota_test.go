@@ -0,0 +1,155 @@
+package rota
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func date(month time.Month, day int) time.Time {
+	return time.Date(2024, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+var allConstraints = Constraints{NoBackToBack: true, RespectLeave: true, SkipBankHolidays: true, BalanceWeekends: true}
+
+func TestGenerateDeterministic(t *testing.T) {
+	req := Request{
+		Start:       date(time.January, 1),
+		End:         date(time.March, 31),
+		Period:      Week,
+		Users:       []uint{1, 2, 3, 4},
+		Seed:        42,
+		Constraints: allConstraints,
+	}
+	first, err := Generate(req)
+	assert.NoError(t, err)
+	second, err := Generate(req)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	// 13 weeks between 4 users
+	for _, report := range first.Report {
+		assert.GreaterOrEqual(t, report.Slots, 3)
+		assert.LessOrEqual(t, report.Slots, 4)
+	}
+}
+
+func TestGenerateNoBackToBack(t *testing.T) {
+	result, err := Generate(Request{
+		Start:       date(time.January, 1),
+		End:         date(time.February, 25),
+		Period:      Week,
+		Users:       []uint{1, 2},
+		Seed:        7,
+		Constraints: allConstraints,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, result.Assignments, 8)
+	for i := 1; i < len(result.Assignments); i++ {
+		assert.NotEqual(t, result.Assignments[i-1].UserID, result.Assignments[i].UserID)
+	}
+	assert.Empty(t, result.Warnings)
+}
+
+func TestGenerateRespectsLeave(t *testing.T) {
+	result, err := Generate(Request{
+		Start:       date(time.January, 1),
+		End:         date(time.January, 14),
+		Period:      Week,
+		Users:       []uint{1, 2},
+		Seed:        1,
+		Constraints: allConstraints,
+		Leave:       []Span{{UserID: 1, Start: date(time.January, 3), End: date(time.January, 3)}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []Span{
+		{UserID: 2, Start: date(time.January, 1), End: date(time.January, 7)},
+		{UserID: 1, Start: date(time.January, 8), End: date(time.January, 14)},
+	}, result.Assignments)
+	assert.Equal(t, 1, result.Report[0].LeaveDays)
+
+	// nobody is available when everyone is on leave
+	result, err = Generate(Request{
+		Start:       date(time.January, 1),
+		End:         date(time.January, 1),
+		Period:      Day,
+		Users:       []uint{1},
+		Constraints: allConstraints,
+		Leave:       []Span{{UserID: 1, Start: date(time.January, 1), End: date(time.January, 1)}},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Assignments)
+	assert.Equal(t, []time.Time{date(time.January, 1)}, result.Unassigned)
+	assert.Len(t, result.Warnings, 1)
+}
+
+func TestGenerateBankHolidays(t *testing.T) {
+	req := Request{
+		Start:        date(time.December, 23),
+		End:          date(time.December, 29),
+		Period:       Week,
+		Users:        []uint{1},
+		Constraints:  allConstraints,
+		BankHolidays: []time.Time{date(time.December, 25), date(time.December, 26)},
+	}
+	result, err := Generate(req)
+	assert.NoError(t, err)
+	// the week is split around the skipped holidays
+	assert.Equal(t, []Span{
+		{UserID: 1, Start: date(time.December, 23), End: date(time.December, 24)},
+		{UserID: 1, Start: date(time.December, 27), End: date(time.December, 29)},
+	}, result.Assignments)
+	assert.Equal(t, UserReport{UserID: 1, Slots: 1, Days: 5, WeekendDays: 2}, result.Report[0])
+
+	req.Constraints.SkipBankHolidays = false
+	result, err = Generate(req)
+	assert.NoError(t, err)
+	assert.Len(t, result.Assignments, 1)
+	assert.Equal(t, UserReport{UserID: 1, Slots: 1, Days: 7, WeekendDays: 2, HolidayDays: 2}, result.Report[0])
+}
+
+func TestGenerateBalancesWeekends(t *testing.T) {
+	result, err := Generate(Request{
+		Start:       date(time.January, 1),
+		End:         date(time.March, 31),
+		Period:      Day,
+		Users:       []uint{1, 2, 3},
+		Seed:        3,
+		Constraints: allConstraints,
+	})
+	assert.NoError(t, err)
+	// 26 weekend days between 3 users
+	for _, report := range result.Report {
+		assert.GreaterOrEqual(t, report.WeekendDays, 8)
+		assert.LessOrEqual(t, report.WeekendDays, 9)
+	}
+}
+
+func TestGenerateExisting(t *testing.T) {
+	result, err := Generate(Request{
+		Start:       date(time.January, 1),
+		End:         date(time.January, 4),
+		Period:      Day,
+		Users:       []uint{1, 2},
+		Constraints: allConstraints,
+		Existing:    []Span{{UserID: 1, Start: date(time.January, 1), End: date(time.January, 2)}},
+	})
+	assert.NoError(t, err)
+	// the existing days aren't assigned again, and count towards user 1's load
+	assert.Equal(t, []Span{
+		{UserID: 2, Start: date(time.January, 3), End: date(time.January, 3)},
+		{UserID: 1, Start: date(time.January, 4), End: date(time.January, 4)},
+	}, result.Assignments)
+	assert.Equal(t, 3, result.Report[0].Days)
+	assert.Equal(t, 1, result.Report[1].Days)
+}
+
+func TestGenerateInvalid(t *testing.T) {
+	_, err := Generate(Request{Start: date(time.January, 2), End: date(time.January, 1), Period: Day, Users: []uint{1}})
+	assert.Error(t, err)
+	_, err = Generate(Request{Start: date(time.January, 1), End: date(time.January, 2), Period: Day})
+	assert.Error(t, err)
+	_, err = Generate(Request{Start: date(time.January, 1), End: date(time.January, 2), Period: "month", Users: []uint{1}})
+	assert.Error(t, err)
+}